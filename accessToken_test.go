@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAccessTokenAuthenticates(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	pat, _ := createAccessToken(t, a, token, "todos:read", "todos:write")
+	assert.Regexp(t, "^tdp_", pat)
+
+	id := createTodo(t, a, pat, "from a script")
+	ids, _ := listTodos(t, a, token, "/todos")
+	assert.Equal(t, []string{id}, ids, "the todo belongs to the owner of the token")
+
+	status, response := a.do(t, "GET", "/tokens", nil, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	tokens, _ := response["data"].([]any)
+	if assert.Len(t, tokens, 1) {
+		assert.NotEmpty(t, tokens[0].(map[string]any)["last_used_at"])
+		assert.NotContains(t, tokens[0], "token", "the secret is only shown once")
+	}
+}
+
+func TestAccessTokenScopes(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	pat, _ := createAccessToken(t, a, token, "todos:read")
+
+	status, _ := a.do(t, "GET", "/todos", nil, bearer(pat)...)
+	assert.Equal(t, 200, status, "granted scope")
+	status, _ = a.do(t, "POST", "/todos", map[string]string{"title": "denied"}, bearer(pat)...)
+	assert.Equal(t, 403, status, "todos:write is missing")
+	status, _ = a.do(t, "GET", "/workspaces", nil, bearer(pat)...)
+	assert.Equal(t, 403, status, "workspaces:read is missing")
+	status, _ = a.do(t, "POST", "/tokens", map[string]any{"name": "bad", "scopes": []string{"admin"}}, bearer(token)...)
+	assert.Equal(t, 400, status, "unknown scope")
+}
+
+func TestRevokedAndExpiredAccessTokens(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	revoked, revokedId := createAccessToken(t, a, token, "todos:read")
+	expired, expiredId := createAccessToken(t, a, token, "todos:read")
+	kept, _ := createAccessToken(t, a, token, "todos:read")
+
+	status, _ := a.do(t, "DELETE", "/tokens/"+revokedId, nil, bearer(token)...)
+	assert.Equal(t, 200, status)
+	_, err := a.db.Exec("UPDATE personal_access_tokens SET expires_at=? WHERE id=?", time.Now().UTC().Add(-time.Minute), expiredId)
+	assert.NoError(t, err)
+
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(revoked)...)
+	assert.Equal(t, 401, status, "revoked token")
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(expired)...)
+	assert.Equal(t, 401, status, "expired token")
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(kept)...)
+	assert.Equal(t, 200, status, "other tokens keep working")
+	status, _ = a.do(t, "GET", "/todos", nil, bearer("tdp_unknown")...)
+	assert.Equal(t, 401, status, "unknown token")
+}
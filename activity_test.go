@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"todo-api/todo"
+	"todo-api/user"
+)
+
+func count(t *testing.T, a *testApp, query string, args ...any) int {
+	var n int
+	assert.NoError(t, a.db.QueryRow(query, args...).Scan(&n))
+	return n
+}
+
+// activity returns the events of a page of /activity and the cursor of the next one.
+func activity(t *testing.T, a *testApp, token string, path string) ([]map[string]any, string) {
+	status, response := a.do(t, "GET", path, nil, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	var events []map[string]any
+	data, _ := response["data"].([]any)
+	for _, event := range data {
+		events = append(events, event.(map[string]any))
+	}
+	cursor, _ := response["next_cursor"].(string)
+	return events, cursor
+}
+
+func TestActivityRecordsMutations(t *testing.T) {
+	a := newTestApp(t)
+	owner, _ := a.register(t, "owner@example.com")
+	a.register(t, "other@example.com")
+	ownerId := userId(t, a, owner)
+	id := createTodo(t, a, owner, "logged")
+
+	status, _ := a.do(t, "PUT", "/todos/"+id, map[string]string{"title": "changed"}, bearer(owner)...)
+	assert.Equal(t, 200, status)
+	status, _ = a.do(t, "PUT", "/todos/"+id+"/shares", map[string]string{"email": "other@example.com", "role": "viewer"}, bearer(owner)...)
+	assert.Equal(t, 200, status)
+	status, _ = a.do(t, "DELETE", "/todos/"+id, nil, bearer(owner)...)
+	assert.Equal(t, 200, status)
+
+	events, _ := activity(t, a, owner, "/activity")
+	var actions []any
+	requestIds := map[any]bool{}
+	for _, event := range events {
+		assert.Equal(t, id, event["todo_id"])
+		assert.Equal(t, ownerId, event["actor_id"])
+		assert.NotEmpty(t, event["request_id"])
+		actions = append(actions, event["action"])
+		requestIds[event["request_id"]] = true
+	}
+	assert.Equal(t, []any{"delete", "share", "update", "create"}, actions)
+	assert.Len(t, requestIds, 4, "each event carries the id of its request")
+	if assert.Len(t, events, 4) {
+		changes := events[2]["changes"].(map[string]any)
+		assert.Equal(t, map[string]any{"before": "logged", "after": "changed"}, changes["title"])
+	}
+}
+
+func TestMutationsFailWithoutTheirEvent(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "owner@example.com")
+	other, _ := a.register(t, "other@example.com")
+	ownerId, otherId := user.Id(userId(t, a, token)), user.Id(userId(t, a, other))
+	storage := todo.NewSqliteStorage(a.db)
+	// without an actor the event cannot be written, the mutation in the same transaction must not be either
+	ctx := context.Background()
+
+	_, err := storage.Create(ctx, ownerId, "", "lost", "")
+	assert.Error(t, err)
+	assert.Equal(t, 0, count(t, a, "SELECT COUNT(*) FROM todos"), "create without its event")
+
+	created, err := storage.Create(todo.WithEventMeta(ctx, todo.EventMeta{ActorId: ownerId}), ownerId, "", "kept", "")
+	assert.NoError(t, err)
+
+	_, err = storage.Update(ctx, created.Id, "lost", "")
+	assert.Error(t, err)
+	_, err = storage.Assign(ctx, created.Id, ownerId, ownerId)
+	assert.Error(t, err)
+	err = storage.Share(ctx, created.Id, otherId, todo.RoleViewer)
+	assert.Error(t, err)
+	err = storage.Delete(ctx, created.Id)
+	assert.Error(t, err)
+
+	current, err := storage.GetById(ctx, created.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "kept", current.Title)
+	assert.Empty(t, current.AssigneeId)
+	assert.Equal(t, 0, count(t, a, "SELECT COUNT(*) FROM todo_shares"), "share without its event")
+	assert.Equal(t, 1, count(t, a, "SELECT COUNT(*) FROM todo_events"), "only the create was logged")
+}
+
+func TestActivityPagination(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	for range 7 {
+		createTodo(t, a, token, "paged")
+	}
+	var expected []string
+	rows, err := a.db.Query("SELECT id FROM todo_events ORDER BY id DESC")
+	assert.NoError(t, err)
+	for rows.Next() {
+		var id string
+		assert.NoError(t, rows.Scan(&id))
+		expected = append(expected, id)
+	}
+	assert.NoError(t, rows.Close())
+
+	var seen []string
+	pages := 0
+	for path := "/activity?limit=3"; path != ""; pages++ {
+		events, cursor := activity(t, a, token, path)
+		for _, event := range events {
+			seen = append(seen, event["id"].(string))
+		}
+		path = ""
+		if cursor != "" {
+			path = "/activity?limit=3&cursor=" + cursor
+		}
+		if pages > 5 {
+			t.Fatal("the cursor does not advance")
+		}
+	}
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, expected, seen, "every event once, newest first")
+}
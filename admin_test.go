@@ -0,0 +1,130 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// registerAdmin registers a user, grants it the admin role and returns the access token of a login with the role.
+func registerAdmin(t *testing.T, a *testApp, email string) string {
+	a.register(t, email)
+	_, err := a.db.Exec("UPDATE users SET role='admin' WHERE email=?", email)
+	assert.NoError(t, err)
+	token, _ := login(t, a, email, "admin")
+	return token
+}
+
+func TestAdminEndpointsRequireTheRole(t *testing.T) {
+	a := newTestApp(t)
+	admin := registerAdmin(t, a, "admin@example.com")
+	normal, _ := a.register(t, "user@example.com")
+	pat, _ := createAccessToken(t, a, admin, "todos:read")
+
+	for _, path := range []string{"/admin/users", "/admin/stats", "/admin/users/" + userId(t, a, admin)} {
+		status, _ := a.do(t, "GET", path, nil, bearer(normal)...)
+		assert.Equal(t, 403, status, "normal user: %s", path)
+		status, _ = a.do(t, "GET", path, nil, bearer(pat)...)
+		assert.Equal(t, 403, status, "access token of an admin: %s", path)
+		status, _ = a.do(t, "GET", path, nil, bearer(admin)...)
+		assert.Equal(t, 200, status, "admin: %s", path)
+	}
+	status, _ := a.do(t, "POST", "/admin/users/"+userId(t, a, admin)+"/disable", nil, bearer(normal)...)
+	assert.Equal(t, 403, status, "normal user disables an admin")
+}
+
+func TestDisableUser(t *testing.T) {
+	a := newTestApp(t)
+	admin := registerAdmin(t, a, "admin@example.com")
+	token, refreshToken := a.register(t, "user@example.com")
+	id := userId(t, a, token)
+
+	status, response := a.do(t, "POST", "/admin/users/"+id+"/disable", nil, bearer(admin)...)
+	assert.Equal(t, 200, status, response)
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(token)...)
+	assert.Equal(t, 401, status, "access token right after the disable")
+	status, _ = refresh(t, a, refreshToken)
+	assert.Equal(t, 401, status, "refresh token of the disabled user")
+	status, _ = a.do(t, "POST", "/login", map[string]string{"email": "user@example.com", "password": testPassword})
+	assert.Equal(t, 403, status, "login of the disabled user")
+
+	status, _ = a.do(t, "POST", "/admin/users/"+id+"/enable", nil, bearer(admin)...)
+	assert.Equal(t, 200, status)
+	login(t, a, "user@example.com", "laptop")
+
+	status, _ = a.do(t, "POST", "/admin/users/"+userId(t, a, admin)+"/disable", nil, bearer(admin)...)
+	assert.Equal(t, 400, status, "admin disables itself")
+}
+
+func TestForceLogout(t *testing.T) {
+	a := newTestApp(t)
+	admin := registerAdmin(t, a, "admin@example.com")
+	token, refreshToken := a.register(t, "user@example.com")
+	other, _ := login(t, a, "user@example.com", "phone")
+	pat, _ := createAccessToken(t, a, token, "todos:read")
+
+	status, response := a.do(t, "POST", "/admin/users/"+userId(t, a, token)+"/logout", nil, bearer(admin)...)
+	assert.Equal(t, 200, status, response)
+	for _, revoked := range []string{token, other} {
+		status, _ = a.do(t, "GET", "/todos", nil, bearer(revoked)...)
+		assert.Equal(t, 401, status, "access token right after the logout")
+	}
+	status, _ = refresh(t, a, refreshToken)
+	assert.Equal(t, 401, status, "refresh token after the logout")
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(pat)...)
+	assert.Equal(t, 200, status, "personal access tokens stay valid")
+	login(t, a, "user@example.com", "laptop")
+}
+
+func TestUpdateRole(t *testing.T) {
+	a := newTestApp(t)
+	admin := registerAdmin(t, a, "admin@example.com")
+	token, _ := a.register(t, "user@example.com")
+	promote := map[string]string{"role": "admin"}
+
+	status, _ := a.do(t, "PUT", "/admin/users/"+userId(t, a, admin)+"/role", map[string]string{"role": "user"}, bearer(admin)...)
+	assert.Equal(t, 400, status, "admin demotes itself")
+	status, _ = a.do(t, "GET", "/admin/stats", nil, bearer(admin)...)
+	assert.Equal(t, 200, status, "still an admin")
+
+	status, response := a.do(t, "PUT", "/admin/users/"+userId(t, a, token)+"/role", promote, bearer(admin)...)
+	assert.Equal(t, 200, status, response)
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(token)...)
+	assert.Equal(t, 401, status, "token with the previous role")
+	promoted, _ := login(t, a, "user@example.com", "laptop")
+	status, _ = a.do(t, "GET", "/admin/stats", nil, bearer(promoted)...)
+	assert.Equal(t, 200, status, "token with the new role")
+
+	status, _ = a.do(t, "PUT", "/admin/users/unknown/role", promote, bearer(admin)...)
+	assert.Equal(t, 404, status, "unknown user")
+	status, _ = a.do(t, "PUT", "/admin/users/"+userId(t, a, promoted)+"/role", map[string]string{"role": "root"}, bearer(admin)...)
+	assert.Equal(t, 400, status, "unknown role")
+}
+
+func TestAdminStats(t *testing.T) {
+	a := newTestApp(t)
+	admin := registerAdmin(t, a, "admin@example.com")
+	user, _ := a.register(t, "user@example.com")
+	disabled, _ := a.register(t, "disabled@example.com")
+	createTodo(t, a, user, "first")
+	createTodo(t, a, user, "second")
+	createWorkspace(t, a, user, "team")
+	createAccessToken(t, a, user, "todos:read")
+	status, _ := a.do(t, "POST", "/admin/users/"+userId(t, a, disabled)+"/disable", nil, bearer(admin)...)
+	assert.Equal(t, 200, status)
+
+	status, response := a.do(t, "GET", "/admin/stats", nil, bearer(admin)...)
+	assert.Equal(t, 200, status, response)
+	assert.Equal(t, map[string]any{
+		"users":              3.0,
+		"verified_users":     0.0,
+		"two_factor_users":   0.0,
+		"admins":             1.0,
+		"disabled_users":     1.0,
+		"deletion_scheduled": 0.0,
+		// the registration and login of the admin and the registration of the user, the disabled user's was revoked
+		"active_sessions": 3.0,
+		"access_tokens":   1.0,
+		"todos":           2.0,
+		"workspaces":      1.0,
+	}, response)
+}
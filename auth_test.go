@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"todo-api/user"
+	"todo-api/utils"
+)
+
+func refresh(t *testing.T, a *testApp, refreshToken string) (int, string) {
+	status, response := a.do(t, "POST", "/token/refresh", map[string]string{"refresh_token": refreshToken})
+	next, _ := response["refresh_token"].(string)
+	return status, next
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	a := newTestApp(t)
+	_, first := a.register(t, "user@example.com")
+
+	status, second := refresh(t, a, first)
+	assert.Equal(t, 200, status)
+	assert.NotEqual(t, first, second)
+
+	status, third := refresh(t, a, second)
+	assert.Equal(t, 200, status)
+	assert.NotEqual(t, second, third)
+
+	status, _ = refresh(t, a, "unknown")
+	assert.Equal(t, 401, status, "unknown refresh token")
+}
+
+func TestRefreshTokenReuseRevokesTheFamily(t *testing.T) {
+	a := newTestApp(t)
+	_, first := a.register(t, "user@example.com")
+
+	status, response := a.do(t, "POST", "/token/refresh", map[string]string{"refresh_token": first})
+	assert.Equal(t, 200, status, response)
+	token, _ := response["token"].(string)
+	second, _ := response["refresh_token"].(string)
+
+	// the first token was stolen and is used again
+	status, _ = refresh(t, a, first)
+	assert.Equal(t, 401, status, "reused refresh token")
+
+	status, _ = refresh(t, a, second)
+	assert.Equal(t, 401, status, "latest refresh token of the revoked family")
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(token)...)
+	assert.Equal(t, 401, status, "access token of the revoked family")
+}
+
+func TestLogoutRevokesTheSession(t *testing.T) {
+	a := newTestApp(t)
+	token, refreshToken := a.register(t, "user@example.com")
+
+	status, _ := a.do(t, "POST", "/logout", map[string]string{"refresh_token": refreshToken}, bearer(token)...)
+	assert.Equal(t, 200, status)
+
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(token)...)
+	assert.Equal(t, 401, status, "access token after logout")
+	status, _ = refresh(t, a, refreshToken)
+	assert.Equal(t, 401, status, "refresh token after logout")
+}
+
+func TestConcurrentLoginGuessesAreThrottled(t *testing.T) {
+	// slow hashes, so the guesses overlap
+	a := newTestApp(t, "ARGON2_TIME", "4", "ARGON2_MEMORY", "8192")
+	a.register(t, "user@example.com")
+
+	const guesses = 20
+	statuses := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for i := range guesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, _ := a.do(t, "POST", "/login", map[string]string{"email": "user@example.com", "password": fmt.Sprintf("wrong password %d", i)})
+			statuses <- status
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	// three failures are free, every further guess waits for the backoff
+	assert.LessOrEqual(t, counts[400], 3, counts)
+	assert.Equal(t, guesses, counts[400]+counts[429], counts)
+
+	status, _ := a.do(t, "POST", "/login", map[string]string{"email": "user@example.com", "password": testPassword})
+	assert.Equal(t, 429, status, "right password during the backoff")
+}
+
+func TestSuccessfulLoginsAreNotThrottled(t *testing.T) {
+	a := newTestApp(t)
+	a.register(t, "user@example.com")
+
+	// more logins than the ip gets free failures
+	for range 25 {
+		status, response := a.do(t, "POST", "/login", map[string]string{"email": "user@example.com", "password": testPassword})
+		assert.Equal(t, 200, status, response)
+	}
+}
+
+func passwordHash(t *testing.T, a *testApp, email string) string {
+	var hash string
+	assert.NoError(t, a.db.QueryRow("SELECT password_hash FROM users WHERE email=?", email).Scan(&hash))
+	return hash
+}
+
+// oldHash hashes the password with cheaper argon2 parameters than the app uses.
+func oldHash(t *testing.T, a *testApp, password string) string {
+	utils.SetArgonParams(utils.ArgonParams{Time: 1, Memory: 32, Threads: 1})
+	defer utils.SetArgonParams(utils.ArgonParams{Time: a.config.Argon2Time, Memory: a.config.Argon2Memory, Threads: a.config.Argon2Threads})
+	hash, err := utils.HashPassword(password)
+	assert.NoError(t, err)
+	return hash
+}
+
+func TestLoginUpgradesOldHashes(t *testing.T) {
+	a := newTestApp(t)
+	a.register(t, "user@example.com")
+	old := oldHash(t, a, testPassword)
+	_, err := a.db.Exec("UPDATE users SET password_hash=? WHERE email=?", old, "user@example.com")
+	assert.NoError(t, err)
+	assert.True(t, utils.NeedsRehash(old))
+
+	login(t, a, "user@example.com", "laptop")
+	upgraded := passwordHash(t, a, "user@example.com")
+	assert.NotEqual(t, old, upgraded)
+	assert.False(t, utils.NeedsRehash(upgraded), "hashed with the current parameters")
+	assert.Contains(t, upgraded, fmt.Sprintf("m=%d,t=%d,p=%d", a.config.Argon2Memory, a.config.Argon2Time, a.config.Argon2Threads))
+
+	login(t, a, "user@example.com", "phone")
+	assert.Equal(t, upgraded, passwordHash(t, a, "user@example.com"), "a current hash is kept")
+}
+
+func TestRehashKeepsAChangedPassword(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	storage := user.NewSqliteUsersStorage(a.db)
+	// the login read the old hash, then the password was changed before the rehash was stored
+	old := oldHash(t, a, testPassword)
+	changed := passwordHash(t, a, "user@example.com")
+	rehashed, err := utils.HashPassword(testPassword)
+	assert.NoError(t, err)
+
+	err = storage.UpdatePasswordHash(context.Background(), user.Id(userId(t, a, token)), old, rehashed)
+	assert.NoError(t, err)
+	assert.Equal(t, changed, passwordHash(t, a, "user@example.com"), "the changed password is not overwritten")
+}
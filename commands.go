@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"todo-api/user"
+)
+
+// runCommand runs an administrative command instead of the server, e.g. `todo-api unlock user@example.com`
+// or `todo-api role admin@example.com admin`.
+func runCommand(db *sql.DB, args []string) error {
+	ctx := context.Background()
+	usersStorage := user.NewSqliteUsersStorage(db)
+
+	switch args[0] {
+	case "unlock":
+		if len(args) != 2 {
+			return errors.New("usage: unlock <email>")
+		}
+		return user.UnlockAccount(ctx, usersStorage, args[1])
+	case "role":
+		// grants the first admin role, later ones can be granted in the admin api
+		if len(args) != 3 || !user.Role(args[2]).Valid() {
+			return errors.New("usage: role <email> <user|admin>")
+		}
+		u, err := usersStorage.GetUserByEmail(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		err = usersStorage.SetRole(ctx, u.Id, user.Role(args[2]))
+		if err != nil {
+			return err
+		}
+		// the role is carried in the access tokens, the user has to log in again
+		_, err = usersStorage.RevokeAllTokens(ctx, u.Id)
+		return err
+	default:
+		return fmt.Errorf("unknown command %s", args[0])
+	}
+}
@@ -5,13 +5,65 @@ import (
 	"fmt"
 	"github.com/caarlos0/env/v11"
 	"os"
+	"slices"
+	"strings"
+	"time"
 )
 
+// defaultJwtSecret is the envDefault of JwtSecret, only good for development.
+const defaultJwtSecret = "mySecret"
+
 type AppConfig struct {
-	IsProduction bool   `env:"IS_PRODUCTION" envDefault:"false"`
-	Port         int    `env:"PORT" envDefault:"3000"`
-	SqliteDbPath string `env:"SQLITE_DB_PATH" envDefault:"./db.sqlite"`
-	JwtSecret    string `env:"JWT_SECRET" envDefault:"mySecret"`
+	IsProduction    bool          `env:"IS_PRODUCTION" envDefault:"false"`
+	Port            int           `env:"PORT" envDefault:"3000"`
+	SqliteDbPath    string        `env:"SQLITE_DB_PATH" envDefault:"./db.sqlite"`
+	JwtSecret       string        `env:"JWT_SECRET" envDefault:"mySecret"`
+	JwtKeysDir      string        `env:"JWT_KEYS_DIR" envDefault:""`
+	AccessTokenTTL  time.Duration `env:"ACCESS_TOKEN_TTL" envDefault:"15m"`
+	RefreshTokenTTL time.Duration `env:"REFRESH_TOKEN_TTL" envDefault:"720h"`
+	PublicUrl       string        `env:"PUBLIC_URL" envDefault:"http://localhost:3000"`
+	// invitation emails link to the page of the frontend that accepts them, PUBLIC_URL/invitation if empty
+	InvitationPageUrl string `env:"INVITATION_PAGE_URL" envDefault:""`
+	// the issuer and audience are set in access tokens and checked when they are verified, unless empty;
+	// the leeway tolerates clocks of the servers running the api drifting apart
+	JwtIssuer   string        `env:"JWT_ISSUER" envDefault:""`
+	JwtAudience string        `env:"JWT_AUDIENCE" envDefault:""`
+	JwtLeeway   time.Duration `env:"JWT_LEEWAY" envDefault:"0s"`
+	// AuthMode cookie keeps the tokens of logins in HttpOnly cookies instead of the response body, for browser clients;
+	// requests authenticated by the cookie need a csrf token, bearer tokens keep working in both modes
+	AuthMode       string `env:"AUTH_MODE" envDefault:"bearer"`
+	CookieSameSite string `env:"COOKIE_SAME_SITE" envDefault:"lax"`
+	Mailer         string `env:"MAILER" envDefault:"log"`
+	MailFrom       string `env:"MAIL_FROM" envDefault:"todo-api@localhost"`
+	MailDir        string `env:"MAIL_DIR" envDefault:"./data/mail"`
+	SmtpHost       string `env:"SMTP_HOST" envDefault:"localhost"`
+	SmtpPort       int    `env:"SMTP_PORT" envDefault:"25"`
+	SmtpUsername   string `env:"SMTP_USERNAME" envDefault:""`
+	SmtpPassword   string `env:"SMTP_PASSWORD" envDefault:""`
+	// argon2id cost of new password hashes, existing hashes are upgraded on login
+	Argon2Time    uint32 `env:"ARGON2_TIME" envDefault:"1"`
+	Argon2Memory  uint32 `env:"ARGON2_MEMORY" envDefault:"61440"`
+	Argon2Threads uint8  `env:"ARGON2_THREADS" envDefault:"4"`
+	// rules for new passwords, the strength is on the zxcvbn scale from 0 to 4
+	PasswordMinLength     int    `env:"PASSWORD_MIN_LENGTH" envDefault:"8"`
+	PasswordMinStrength   int    `env:"PASSWORD_MIN_STRENGTH" envDefault:"2"`
+	BreachedPasswordsFile string `env:"BREACHED_PASSWORDS_FILE" envDefault:""`
+	// AccountDeletionGrace is how long a deleted account can be restored before its data is removed
+	AccountDeletionGrace time.Duration `env:"ACCOUNT_DELETION_GRACE" envDefault:"0s"`
+	// data exports are kept in ExportDir and can be downloaded for ExportTTL
+	ExportDir string        `env:"EXPORT_DIR" envDefault:"./exports"`
+	ExportTTL time.Duration `env:"EXPORT_TTL" envDefault:"24h"`
+	// OpenID Connect login, enabled by the issuer; the redirect url is PUBLIC_URL/auth/oidc/callback
+	OidcIssuer        string `env:"OIDC_ISSUER" envDefault:""`
+	OidcClientId      string `env:"OIDC_CLIENT_ID" envDefault:""`
+	OidcClientSecret  string `env:"OIDC_CLIENT_SECRET" envDefault:""`
+	OidcScopes        string `env:"OIDC_SCOPES" envDefault:"openid email profile"`
+	OidcAutoProvision bool   `env:"OIDC_AUTO_PROVISION" envDefault:"true"`
+	// OAuth clients send users to /oauth/authorize, which forwards them to the consent page of the frontend,
+	// PUBLIC_URL/consent if empty
+	OauthConsentUrl string `env:"OAUTH_CONSENT_URL" envDefault:""`
+	// RequireVerifiedEmail blocks users with an unverified email from changing todos
+	RequireVerifiedEmail bool `env:"REQUIRE_VERIFIED_EMAIL" envDefault:"false"`
 }
 
 func (c *AppConfig) IsDev() bool {
@@ -22,8 +74,37 @@ func (c *AppConfig) Address() string {
 	return fmt.Sprintf(":%d", c.Port)
 }
 
+func (c *AppConfig) SmtpAddress() string {
+	return fmt.Sprintf("%s:%d", c.SmtpHost, c.SmtpPort)
+}
+
+func (c *AppConfig) CookieAuth() bool {
+	return c.AuthMode == "cookie"
+}
+
+// SecureCookies reports whether cookies are limited to https, which is the case when the api is served over it.
+func (c *AppConfig) SecureCookies() bool {
+	return strings.HasPrefix(c.PublicUrl, "https://")
+}
+
+// InvitationUrl is the page that accepts a workspace invitation, the token is added as the token query parameter.
+func (c *AppConfig) InvitationUrl() string {
+	if c.InvitationPageUrl != "" {
+		return c.InvitationPageUrl
+	}
+	return strings.TrimRight(c.PublicUrl, "/") + "/invitation"
+}
+
+func (c *AppConfig) ConsentUrl() string {
+	if c.OauthConsentUrl != "" {
+		return c.OauthConsentUrl
+	}
+	return strings.TrimRight(c.PublicUrl, "/") + "/consent"
+}
+
 func (c *AppConfig) DebugString() string {
-	return fmt.Sprintf("IsProduction: %v\nPort: %d\ndb: %s\n", c.IsProduction, c.Port, c.SqliteDbPath)
+	return fmt.Sprintf("IsProduction: %v\nPort: %d\ndb: %s\nmailer: %s\njwt keys: %s\nauth mode: %s\nrequire verified email: %v\nargon2: t=%d m=%d p=%d\n",
+		c.IsProduction, c.Port, c.SqliteDbPath, c.Mailer, c.JwtKeysDir, c.AuthMode, c.RequireVerifiedEmail, c.Argon2Time, c.Argon2Memory, c.Argon2Threads)
 }
 
 func (c *AppConfig) Validate() error {
@@ -35,8 +116,77 @@ func (c *AppConfig) Validate() error {
 		return fmt.Errorf("sqlite db path %s does not exist", c.SqliteDbPath)
 	}
 
-	if c.IsProduction && c.JwtSecret == "" {
-		return errors.New("jwt secret is required in production mode, set JWT_SECRET environment variable")
+	if c.JwtKeysDir != "" {
+		if stat, err := os.Stat(c.JwtKeysDir); err != nil || !stat.IsDir() {
+			return fmt.Errorf("jwt keys dir %s is not a directory", c.JwtKeysDir)
+		}
+	}
+
+	// the secret also signs email verification and export download links, so it is needed even with a keys dir,
+	// and the default one would let anyone forge them
+	if c.IsProduction && (c.JwtSecret == "" || c.JwtSecret == defaultJwtSecret) {
+		return errors.New("jwt secret is required in production mode, set JWT_SECRET environment variable to a random value")
+	}
+
+	if c.AccessTokenTTL <= 0 || c.RefreshTokenTTL <= 0 {
+		return errors.New("token lifetimes must be positive")
+	}
+
+	if c.JwtLeeway < 0 || c.JwtLeeway > 5*time.Minute {
+		return errors.New("jwt leeway must be between 0s and 5m")
+	}
+
+	if c.ExportTTL <= 0 {
+		return errors.New("export lifetime must be positive")
+	}
+
+	if c.AccountDeletionGrace < 0 {
+		return errors.New("account deletion grace period must not be negative")
+	}
+
+	if c.Argon2Time < 1 || c.Argon2Threads < 1 {
+		return errors.New("argon2 time and threads must be at least 1")
+	}
+
+	if c.Argon2Memory < 8*uint32(c.Argon2Threads) {
+		return errors.New("argon2 memory must be at least 8 KiB per thread")
+	}
+
+	if c.PasswordMinLength < 1 || c.PasswordMinLength > 255 {
+		return errors.New("password min length must in range 1-255")
+	}
+
+	if c.PasswordMinStrength < 0 || c.PasswordMinStrength > 4 {
+		return errors.New("password min strength must in range 0-4")
+	}
+
+	if c.OidcIssuer != "" && c.OidcClientId == "" {
+		return errors.New("oidc client id is required with an oidc issuer, set OIDC_CLIENT_ID environment variable")
+	}
+
+	if c.OidcIssuer != "" && !slices.Contains(strings.Fields(c.OidcScopes), "openid") {
+		return errors.New("oidc scopes must include openid")
+	}
+
+	if c.AuthMode != "bearer" && c.AuthMode != "cookie" {
+		return fmt.Errorf("unknown auth mode %s, expected bearer or cookie", c.AuthMode)
+	}
+
+	if c.CookieSameSite != "strict" && c.CookieSameSite != "lax" && c.CookieSameSite != "none" {
+		return fmt.Errorf("unknown cookie same site mode %s, expected strict, lax or none", c.CookieSameSite)
+	}
+
+	// browsers reject SameSite=None cookies without the Secure attribute
+	if c.CookieSameSite == "none" && !c.SecureCookies() {
+		return errors.New("cookie same site mode none needs an https public url")
+	}
+
+	if c.Mailer != "log" && c.Mailer != "file" && c.Mailer != "smtp" {
+		return fmt.Errorf("unknown mailer %s, expected log, file or smtp", c.Mailer)
+	}
+
+	if c.Mailer == "smtp" && (c.SmtpPort < 1 || c.SmtpPort > 65535) {
+		return errors.New("smtp port must in range 1-65535")
 	}
 
 	return nil
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateJwtSecret(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "db.sqlite")
+	assert.NoError(t, os.WriteFile(db, nil, 0o600))
+
+	t.Setenv("SQLITE_DB_PATH", db)
+	t.Setenv("IS_PRODUCTION", "true")
+	config, err := FromEnv()
+	assert.NoError(t, err)
+
+	// the default secret signs links anyone could forge
+	assert.Error(t, config.Validate())
+
+	config.JwtSecret = ""
+	assert.Error(t, config.Validate())
+
+	config.JwtSecret = "a random production secret"
+	assert.NoError(t, config.Validate())
+
+	config.IsProduction = false
+	config.JwtSecret = defaultJwtSecret
+	assert.NoError(t, config.Validate())
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// cookies returns the cookies set by the response, by name.
+func cookies(resp *http.Response) map[string]*http.Cookie {
+	set := map[string]*http.Cookie{}
+	for _, cookie := range resp.Cookies() {
+		set[cookie.Name] = cookie
+	}
+	return set
+}
+
+// registerWithCookies registers a user in cookie mode and returns the access token and csrf cookies.
+func registerWithCookies(t *testing.T, a *testApp, email string) map[string]*http.Cookie {
+	resp := a.send(t, "POST", "/register", map[string]string{"email": email, "password": testPassword, "name": "Test User"})
+	defer resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	set := cookies(resp)
+	assert.True(t, set["access_token"].HttpOnly)
+	assert.True(t, set["refresh_token"].HttpOnly)
+	assert.Equal(t, "/token/refresh", set["refresh_token"].Path)
+	assert.False(t, set["csrf_token"].HttpOnly, "the page reads the csrf token")
+	return set
+}
+
+func TestCsrfInCookieMode(t *testing.T) {
+	a := newTestApp(t, "AUTH_MODE", "cookie")
+	set := registerWithCookies(t, a, "user@example.com")
+	cookie := "access_token=" + set["access_token"].Value + "; csrf_token=" + set["csrf_token"].Value
+	todo := map[string]string{"title": "from the browser"}
+
+	status, _ := a.do(t, "GET", "/todos", nil, "Cookie", cookie)
+	assert.Equal(t, 200, status, "reads need no csrf token")
+
+	status, _ = a.do(t, "POST", "/todos", todo, "Cookie", cookie)
+	assert.Equal(t, 403, status, "missing csrf header")
+	status, _ = a.do(t, "POST", "/todos", todo, "Cookie", cookie, "X-CSRF-Token", "guessed")
+	assert.Equal(t, 403, status, "wrong csrf header")
+	status, _ = a.do(t, "POST", "/todos", todo, "Cookie", "access_token="+set["access_token"].Value, "X-CSRF-Token", "")
+	assert.Equal(t, 403, status, "no csrf cookie")
+
+	status, response := a.do(t, "POST", "/todos", todo, "Cookie", cookie, "X-CSRF-Token", set["csrf_token"].Value)
+	assert.Equal(t, 200, status, response)
+
+	// a header cannot be sent by another site, so bearer requests need no csrf token
+	status, response = a.do(t, "POST", "/todos", todo, bearer(set["access_token"].Value)...)
+	assert.Equal(t, 200, status, response)
+}
+
+func TestRefreshAndLogoutInCookieMode(t *testing.T) {
+	a := newTestApp(t, "AUTH_MODE", "cookie")
+	set := registerWithCookies(t, a, "user@example.com")
+
+	resp := a.send(t, "POST", "/token/refresh", nil, "Cookie", "refresh_token="+set["refresh_token"].Value)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	refreshed := cookies(resp)
+	assert.NotEqual(t, set["refresh_token"].Value, refreshed["refresh_token"].Value, "the refresh token rotates")
+	assert.NotEqual(t, set["csrf_token"].Value, refreshed["csrf_token"].Value, "a new csrf token comes with the access token")
+
+	cookie := "access_token=" + refreshed["access_token"].Value + "; csrf_token=" + refreshed["csrf_token"].Value
+	resp = a.send(t, "POST", "/logout", nil, "Cookie", cookie, "X-CSRF-Token", refreshed["csrf_token"].Value)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	for _, name := range []string{"access_token", "refresh_token", "csrf_token"} {
+		cleared := cookies(resp)[name]
+		if assert.NotNil(t, cleared, name) {
+			assert.Empty(t, cleared.Value, name)
+			assert.True(t, cleared.Expires.Before(time.Now()), name)
+		}
+	}
+
+	status, _ := a.do(t, "GET", "/todos", nil, "Cookie", cookie)
+	assert.Equal(t, 401, status, "access token cookie after logout")
+}
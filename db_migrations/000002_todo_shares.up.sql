@@ -0,0 +1,10 @@
+CREATE TABLE todo_shares
+(
+    todo_id    varchar   NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+    user_id    varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role       varchar   NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (todo_id, user_id)
+);
+
+CREATE INDEX idx_todo_shares_user_id ON todo_shares (user_id);
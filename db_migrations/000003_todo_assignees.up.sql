@@ -0,0 +1,15 @@
+ALTER TABLE todos ADD COLUMN assignee_id varchar REFERENCES users (id) ON DELETE SET NULL;
+ALTER TABLE todos ADD COLUMN assigned_at timestamp;
+
+CREATE INDEX idx_todos_assignee_id ON todos (assignee_id);
+
+CREATE TABLE todo_assignments
+(
+    id          varchar   NOT NULL PRIMARY KEY,
+    todo_id     varchar   NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+    assignee_id varchar REFERENCES users (id) ON DELETE CASCADE,
+    assigned_by varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    assigned_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_todo_assignments_todo_id ON todo_assignments (todo_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_todos_workspace_id;
+ALTER TABLE todos DROP COLUMN workspace_id;
+DROP TABLE IF EXISTS workspace_invitations;
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
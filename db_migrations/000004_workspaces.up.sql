@@ -0,0 +1,38 @@
+-- a workspace stays when its creator is deleted, the ownership goes to another member
+CREATE TABLE workspaces
+(
+    id         varchar   NOT NULL PRIMARY KEY,
+    name       varchar   NOT NULL,
+    created_by varchar   NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE workspace_members
+(
+    workspace_id varchar   NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+    user_id      varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role         varchar   NOT NULL,
+    joined_at    timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE INDEX idx_workspace_members_user_id ON workspace_members (user_id);
+
+CREATE TABLE workspace_invitations
+(
+    id           varchar   NOT NULL PRIMARY KEY,
+    workspace_id varchar   NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+    email        varchar   NOT NULL,
+    role         varchar   NOT NULL,
+    token_hash   varchar   NOT NULL UNIQUE,
+    invited_by   varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at   timestamp NOT NULL,
+    accepted_at  timestamp,
+    created_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_workspace_invitations_workspace_id ON workspace_invitations (workspace_id);
+
+ALTER TABLE todos ADD COLUMN workspace_id varchar REFERENCES workspaces (id) ON DELETE CASCADE;
+
+CREATE INDEX idx_todos_workspace_id ON todos (workspace_id);
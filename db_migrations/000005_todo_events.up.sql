@@ -0,0 +1,13 @@
+CREATE TABLE todo_events
+(
+    id         varchar   NOT NULL PRIMARY KEY,
+    todo_id    varchar   NOT NULL,
+    actor_id   varchar   NOT NULL,
+    action     varchar   NOT NULL,
+    changes    varchar   NOT NULL,
+    request_id varchar,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_todo_events_todo_id ON todo_events (todo_id, id);
+CREATE INDEX idx_todo_events_actor_id ON todo_events (actor_id, id);
@@ -0,0 +1,15 @@
+CREATE TABLE todo_revisions
+(
+    todo_id    varchar   NOT NULL,
+    revision   integer   NOT NULL,
+    action     varchar   NOT NULL,
+    snapshot   varchar   NOT NULL,
+    shares     varchar,
+    actor_id   varchar   NOT NULL,
+    undo       boolean   NOT NULL DEFAULT FALSE,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    undone_at  timestamp,
+    PRIMARY KEY (todo_id, revision)
+);
+
+CREATE INDEX idx_todo_revisions_actor_id ON todo_revisions (actor_id, created_at);
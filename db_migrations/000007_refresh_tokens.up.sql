@@ -0,0 +1,14 @@
+CREATE TABLE refresh_tokens
+(
+    id         varchar PRIMARY KEY,
+    family_id  varchar   NOT NULL,
+    user_id    varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash varchar   NOT NULL UNIQUE,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at timestamp NOT NULL,
+    used_at    timestamp,
+    revoked_at timestamp
+);
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
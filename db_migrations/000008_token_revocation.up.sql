@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN token_generation integer NOT NULL DEFAULT 0;
+
+CREATE TABLE revoked_tokens
+(
+    jti        varchar   NOT NULL PRIMARY KEY,
+    user_id    varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at timestamp NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
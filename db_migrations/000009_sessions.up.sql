@@ -0,0 +1,14 @@
+CREATE TABLE sessions
+(
+    id           varchar   NOT NULL PRIMARY KEY,
+    user_id      varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    device_name  varchar   NOT NULL DEFAULT '',
+    user_agent   varchar   NOT NULL DEFAULT '',
+    ip           varchar   NOT NULL DEFAULT '',
+    created_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at   timestamp NOT NULL,
+    revoked_at   timestamp
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
@@ -0,0 +1,14 @@
+CREATE TABLE personal_access_tokens
+(
+    id           varchar   NOT NULL PRIMARY KEY,
+    user_id      varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         varchar   NOT NULL,
+    hint         varchar   NOT NULL,
+    token_hash   varchar   NOT NULL UNIQUE,
+    scopes       varchar   NOT NULL,
+    created_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at   timestamp,
+    last_used_at timestamp
+);
+
+CREATE INDEX idx_personal_access_tokens_user_id ON personal_access_tokens (user_id);
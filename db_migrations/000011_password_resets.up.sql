@@ -0,0 +1,11 @@
+CREATE TABLE password_resets
+(
+    id         varchar   NOT NULL PRIMARY KEY,
+    user_id    varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash varchar   NOT NULL UNIQUE,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at timestamp NOT NULL,
+    used_at    timestamp
+);
+
+CREATE INDEX idx_password_resets_user_id ON password_resets (user_id);
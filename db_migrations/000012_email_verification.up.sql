@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN email_verified_at timestamp;
+ALTER TABLE users ADD COLUMN verification_sent_at timestamp;
+
+-- accounts created before verification was introduced are trusted
+UPDATE users SET email_verified_at=CURRENT_TIMESTAMP;
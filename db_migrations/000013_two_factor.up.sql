@@ -0,0 +1,27 @@
+ALTER TABLE users ADD COLUMN totp_secret varchar;
+ALTER TABLE users ADD COLUMN totp_enabled_at timestamp;
+-- the last accepted time step, a code cannot be used twice
+ALTER TABLE users ADD COLUMN totp_last_step integer NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes
+(
+    id         varchar   NOT NULL PRIMARY KEY,
+    user_id    varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash  varchar   NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    used_at    timestamp
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+CREATE TABLE mfa_challenges
+(
+    id          varchar   NOT NULL PRIMARY KEY,
+    user_id     varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash  varchar   NOT NULL UNIQUE,
+    device_name varchar   NOT NULL DEFAULT '',
+    attempts    integer   NOT NULL DEFAULT 0,
+    created_at  timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at  timestamp NOT NULL,
+    used_at     timestamp
+);
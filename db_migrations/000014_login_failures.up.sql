@@ -0,0 +1,7 @@
+-- failed logins per account ("email:...") and per client ip ("ip:...")
+CREATE TABLE login_failures
+(
+    key             varchar   NOT NULL PRIMARY KEY,
+    failures        integer   NOT NULL DEFAULT 0,
+    last_failure_at timestamp NOT NULL
+);
@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN sort_order;
+ALTER TABLE users DROP COLUMN sort_by;
+ALTER TABLE users DROP COLUMN locale;
+ALTER TABLE users DROP COLUMN timezone;
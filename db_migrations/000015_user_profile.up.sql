@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN timezone varchar NOT NULL DEFAULT 'UTC';
+ALTER TABLE users ADD COLUMN locale varchar NOT NULL DEFAULT 'en';
+-- default order of the todo list
+ALTER TABLE users ADD COLUMN sort_by varchar NOT NULL DEFAULT 'id';
+ALTER TABLE users ADD COLUMN sort_order varchar NOT NULL DEFAULT 'desc';
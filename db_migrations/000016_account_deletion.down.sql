@@ -0,0 +1,23 @@
+CREATE TABLE new_todos
+(
+    id           varchar   NOT NULL PRIMARY KEY,
+    user_id      varchar   NOT NULL REFERENCES users (id),
+    title        varchar   NOT NULL,
+    description  varchar,
+    created_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    assignee_id  varchar REFERENCES users (id) ON DELETE SET NULL,
+    assigned_at  timestamp,
+    workspace_id varchar REFERENCES workspaces (id) ON DELETE CASCADE
+);
+INSERT INTO new_todos SELECT * FROM todos;
+DROP TABLE todos;
+ALTER TABLE new_todos RENAME TO todos;
+
+CREATE INDEX idx_todos_user_id ON todos (user_id);
+CREATE INDEX idx_todos_assignee_id ON todos (assignee_id);
+CREATE INDEX idx_todos_workspace_id ON todos (workspace_id);
+
+DROP INDEX IF EXISTS idx_users_deleted_at;
+
+ALTER TABLE users DROP COLUMN deleted_at;
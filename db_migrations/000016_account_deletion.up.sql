@@ -0,0 +1,26 @@
+-- accounts are disabled when their deletion is requested and purged after the grace period
+ALTER TABLE users ADD COLUMN deleted_at timestamp;
+
+CREATE INDEX idx_users_deleted_at ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- the todos of a purged user go with the users row like the rest of its data;
+-- SQLite cannot change the reference of the existing todos.user_id column, so the table is copied over
+CREATE TABLE new_todos
+(
+    id           varchar   NOT NULL PRIMARY KEY,
+    user_id      varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    title        varchar   NOT NULL,
+    description  varchar,
+    created_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    assignee_id  varchar REFERENCES users (id) ON DELETE SET NULL,
+    assigned_at  timestamp,
+    workspace_id varchar REFERENCES workspaces (id) ON DELETE CASCADE
+);
+INSERT INTO new_todos SELECT * FROM todos;
+DROP TABLE todos;
+ALTER TABLE new_todos RENAME TO todos;
+
+CREATE INDEX idx_todos_user_id ON todos (user_id);
+CREATE INDEX idx_todos_assignee_id ON todos (assignee_id);
+CREATE INDEX idx_todos_workspace_id ON todos (workspace_id);
@@ -0,0 +1,14 @@
+CREATE TABLE exports
+(
+    id           varchar   NOT NULL PRIMARY KEY,
+    user_id      varchar   NOT NULL,
+    status       varchar   NOT NULL,
+    error        varchar   NOT NULL DEFAULT '',
+    created_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at timestamp,
+    expires_at   timestamp
+);
+
+CREATE INDEX idx_exports_user_id ON exports (user_id);
+-- a user can wait for a single export at a time
+CREATE UNIQUE INDEX idx_exports_pending ON exports (user_id) WHERE status = 'pending';
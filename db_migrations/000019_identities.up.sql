@@ -0,0 +1,25 @@
+CREATE TABLE identities
+(
+    id            varchar   NOT NULL PRIMARY KEY,
+    user_id       varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    issuer        varchar   NOT NULL,
+    subject       varchar   NOT NULL,
+    email         varchar   NOT NULL DEFAULT '',
+    created_at    timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_login_at timestamp,
+    UNIQUE (issuer, subject)
+);
+
+CREATE INDEX idx_identities_user_id ON identities (user_id);
+
+-- logins in progress at the provider, a state is used once
+CREATE TABLE oidc_states
+(
+    state_hash    varchar   NOT NULL PRIMARY KEY,
+    nonce         varchar   NOT NULL,
+    code_verifier varchar   NOT NULL,
+    device_name   varchar   NOT NULL DEFAULT '',
+    -- set when an authenticated user links the identity instead of logging in
+    link_user_id  varchar REFERENCES users (id) ON DELETE CASCADE,
+    expires_at    timestamp NOT NULL
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_sessions_client_id;
+ALTER TABLE sessions DROP COLUMN scope;
+ALTER TABLE sessions DROP COLUMN client_id;
+DROP TABLE IF EXISTS oauth_codes;
+DROP TABLE IF EXISTS oauth_clients;
@@ -0,0 +1,35 @@
+-- third-party applications users grant access to their account
+CREATE TABLE oauth_clients
+(
+    id            varchar   NOT NULL PRIMARY KEY,
+    owner_id      varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name          varchar   NOT NULL,
+    -- empty for public clients, e.g. single page or mobile apps, which rely on PKCE alone
+    secret_hash   varchar   NOT NULL DEFAULT '',
+    -- space separated, like scopes
+    redirect_uris varchar   NOT NULL,
+    scopes        varchar   NOT NULL,
+    created_at    timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_oauth_clients_owner_id ON oauth_clients (owner_id);
+
+CREATE TABLE oauth_codes
+(
+    code_hash      varchar   NOT NULL PRIMARY KEY,
+    client_id      varchar   NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    user_id        varchar   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri   varchar   NOT NULL,
+    scope          varchar   NOT NULL,
+    code_challenge varchar   NOT NULL,
+    expires_at     timestamp NOT NULL,
+    used_at        timestamp,
+    -- the session the code was exchanged for, revoked when the code is presented again
+    session_id     varchar
+);
+
+-- a grant to a client is a session of the user limited to the scope
+ALTER TABLE sessions ADD COLUMN client_id varchar;
+ALTER TABLE sessions ADD COLUMN scope varchar NOT NULL DEFAULT '';
+
+CREATE INDEX idx_sessions_client_id ON sessions (client_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS dpop_proofs;
+ALTER TABLE sessions DROP COLUMN dpop_jkt;
@@ -0,0 +1,9 @@
+-- thumbprint of the key the tokens of the session are bound to by DPoP proofs, empty for bearer tokens
+ALTER TABLE sessions ADD COLUMN dpop_jkt varchar NOT NULL DEFAULT '';
+
+-- ids of the DPoP proofs seen until they expire, so every proof is accepted once
+CREATE TABLE dpop_proofs
+(
+    jti_hash   varchar   NOT NULL PRIMARY KEY,
+    expires_at timestamp NOT NULL
+);
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"todo-api/user"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	a := newTestApp(t)
+	token, refreshToken := a.register(t, "user@example.com")
+
+	status, _ := a.do(t, "DELETE", "/me", map[string]string{"password": "wrong password"}, bearer(token)...)
+	assert.Equal(t, 400, status, "wrong password")
+	status, _ = a.do(t, "DELETE", "/me", map[string]string{}, bearer(token)...)
+	assert.Equal(t, 400, status, "missing password")
+
+	status, response := a.do(t, "DELETE", "/me", map[string]string{"password": testPassword}, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(token)...)
+	assert.Equal(t, 401, status, "access token after the deletion")
+	status, _ = refresh(t, a, refreshToken)
+	assert.Equal(t, 401, status, "refresh token after the deletion")
+	status, _ = a.do(t, "POST", "/login", map[string]string{"email": "user@example.com", "password": testPassword})
+	assert.Equal(t, 403, status, "login after the deletion")
+}
+
+// removePassword turns the user into one that only logs in through an identity provider.
+func removePassword(t *testing.T, a *testApp, email string) {
+	_, err := a.db.Exec("UPDATE users SET password_hash='' WHERE email=?", email)
+	assert.NoError(t, err)
+}
+
+func ageSessions(t *testing.T, a *testApp, age time.Duration) {
+	_, err := a.db.Exec("UPDATE sessions SET created_at=?", time.Now().UTC().Add(-age))
+	assert.NoError(t, err)
+}
+
+func TestDeleteAccountWithoutPassword(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	removePassword(t, a, "user@example.com")
+
+	ageSessions(t, a, time.Hour)
+	status, _ := a.do(t, "DELETE", "/me", map[string]string{}, bearer(token)...)
+	assert.Equal(t, 403, status, "login an hour ago")
+
+	ageSessions(t, a, time.Minute)
+	status, response := a.do(t, "DELETE", "/me", map[string]string{}, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+}
+
+func TestChangeEmailWithoutPassword(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	removePassword(t, a, "user@example.com")
+	change := map[string]string{"email": "new@example.com"}
+
+	ageSessions(t, a, time.Hour)
+	status, _ := a.do(t, "PATCH", "/me", change, bearer(token)...)
+	assert.Equal(t, 403, status, "login an hour ago")
+
+	ageSessions(t, a, time.Minute)
+	status, response := a.do(t, "PATCH", "/me", change, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	assert.Equal(t, "new@example.com", response["email"])
+}
+
+func TestPurgeRemovesTheData(t *testing.T) {
+	a := newTestApp(t)
+	deleted, _ := a.register(t, "deleted@example.com")
+	other, _ := a.register(t, "other@example.com")
+	deletedId := userId(t, a, deleted)
+
+	own := createTodo(t, a, deleted, "own")
+	status, _ := a.do(t, "PUT", "/todos/"+own+"/shares", map[string]string{"email": "other@example.com", "role": "viewer"}, bearer(deleted)...)
+	assert.Equal(t, 200, status)
+	gone := createTodo(t, a, deleted, "deleted before")
+	status, _ = a.do(t, "DELETE", "/todos/"+gone, nil, bearer(deleted)...)
+	assert.Equal(t, 200, status)
+	shared := createTodo(t, a, other, "shared")
+	status, _ = a.do(t, "PUT", "/todos/"+shared+"/shares", map[string]string{"email": "deleted@example.com", "role": "editor"}, bearer(other)...)
+	assert.Equal(t, 200, status)
+
+	status, response := a.do(t, "POST", "/workspaces", map[string]string{"name": "alone"}, bearer(deleted)...)
+	assert.Equal(t, 200, status, response)
+	workspaceId, _ := response["id"].(string)
+	status, response = a.do(t, "POST", "/workspaces/"+workspaceId+"/todos", map[string]string{"title": "in the workspace"}, bearer(deleted)...)
+	assert.Equal(t, 200, status, response)
+
+	status, response = a.do(t, "DELETE", "/me", map[string]string{"password": testPassword}, bearer(deleted)...)
+	assert.Equal(t, 200, status, response)
+	purged, err := user.NewSqliteUsersStorage(a.db).PurgeDeleted(context.Background(), time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	assert.Equal(t, 0, count(t, a, "SELECT count(*) FROM users WHERE id=?", deletedId))
+	assert.Equal(t, 0, count(t, a, "SELECT count(*) FROM todos WHERE user_id=?", deletedId))
+	assert.Equal(t, 0, count(t, a, "SELECT count(*) FROM todo_shares WHERE user_id=?", deletedId))
+	assert.Equal(t, 0, count(t, a, "SELECT count(*) FROM todo_revisions WHERE todo_id IN (?, ?)", own, gone))
+	assert.Equal(t, 0, count(t, a, "SELECT count(*) FROM workspaces WHERE id=?", workspaceId))
+	assert.Equal(t, 0, count(t, a, "SELECT count(*) FROM todos WHERE workspace_id=?", workspaceId))
+	assert.Equal(t, 0, count(t, a, "SELECT count(*) FROM sessions WHERE user_id=?", deletedId))
+	assert.Equal(t, 0, count(t, a, "SELECT count(*) FROM refresh_tokens WHERE user_id=?", deletedId))
+
+	// the todos of others stay
+	status, _ = a.do(t, "GET", "/todos/"+shared+"/revisions", nil, bearer(other)...)
+	assert.Equal(t, 200, status)
+	status, _ = a.do(t, "GET", "/todos/"+own+"/revisions", nil, bearer(other)...)
+	assert.Equal(t, 403, status, "todo of the deleted user")
+}
@@ -0,0 +1,354 @@
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+	"todo-api/config"
+	"todo-api/mail"
+	"todo-api/todo"
+	"todo-api/user"
+	"todo-api/workspace"
+)
+
+const (
+	// pageSize is how many todos or events are read from the storage at once.
+	pageSize = 100
+	// buildTimeout bounds the time an archive is built, pending exports older than that are failed.
+	buildTimeout = 10 * time.Minute
+)
+
+// Exporter builds the archives of the data of a user in the background.
+type Exporter struct {
+	config     *config.AppConfig
+	storage    Storage
+	users      user.Storage
+	todos      todo.Storage
+	workspaces workspace.Storage
+	mailer     mail.Mailer
+}
+
+func NewExporter(config *config.AppConfig, storage Storage, users user.Storage, todos todo.Storage, workspaces workspace.Storage, mailer mail.Mailer) *Exporter {
+	return &Exporter{config: config, storage: storage, users: users, todos: todos, workspaces: workspaces, mailer: mailer}
+}
+
+// path is where the archive of the export is stored.
+func (e *Exporter) path(id Id) string {
+	return filepath.Join(e.config.ExportDir, string(id)+".zip")
+}
+
+// Start builds the archive of the export in a new goroutine and mails the download link when it is ready.
+func (e *Exporter) Start(export Export) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), buildTimeout)
+		defer cancel()
+
+		expiresAt := time.Now().Add(e.config.ExportTTL)
+		err := e.build(ctx, export)
+		if err != nil {
+			log.Printf("export %s failed: %v", export.Id, err)
+			err = e.storage.Fail(context.Background(), export.Id, "the archive could not be created", expiresAt)
+			if err != nil {
+				log.Printf("export %s: %v", export.Id, err)
+			}
+			return
+		}
+
+		err = e.storage.Complete(ctx, export.Id, expiresAt)
+		if err == nil {
+			err = e.notify(ctx, export, expiresAt)
+		}
+		if err != nil {
+			log.Printf("export %s: %v", export.Id, err)
+		}
+	}()
+}
+
+// RunCleanup removes the expired archives every interval. It never returns.
+func (e *Exporter) RunCleanup(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx := context.Background()
+		now := time.Now()
+		err := e.storage.FailStale(ctx, now.Add(-buildTimeout), now.Add(e.config.ExportTTL))
+		if err != nil {
+			log.Printf("export cleanup failed: %v", err)
+		}
+
+		exports, err := e.storage.GetExpired(ctx, now)
+		if err != nil {
+			log.Printf("export cleanup failed: %v", err)
+			continue
+		}
+		for _, export := range exports {
+			err = os.Remove(e.path(export.Id))
+			if err == nil || errors.Is(err, os.ErrNotExist) {
+				err = e.storage.Delete(ctx, export.Id)
+			}
+			if err != nil {
+				log.Printf("export cleanup of %s failed: %v", export.Id, err)
+			}
+		}
+	}
+}
+
+func (e *Exporter) notify(ctx context.Context, export Export, expiresAt time.Time) error {
+	u, err := e.users.GetById(ctx, export.UserId)
+	if err != nil {
+		return err
+	}
+	return e.mailer.Send(ctx, mail.Message{
+		To:      u.Email,
+		Subject: "Your data export is ready",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nthe export of your data is ready, download it until %s:\n\n%s\n\nIf you did not request it, change your password.",
+			u.Name, expiresAt.UTC().Format(time.RFC1123), downloadUrl(e.config, export.Id, expiresAt),
+		),
+	})
+}
+
+// build writes the archive to a temporary file first, so a partial archive can never be downloaded.
+func (e *Exporter) build(ctx context.Context, export Export) error {
+	err := os.MkdirAll(e.config.ExportDir, 0o700)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(e.config.ExportDir, string(export.Id)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	err = e.write(ctx, export.UserId, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), e.path(export.Id))
+}
+
+func (e *Exporter) write(ctx context.Context, userId user.Id, w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	u, err := e.users.GetById(ctx, userId)
+	if err != nil {
+		return err
+	}
+	sessions, err := e.users.GetSessions(ctx, userId)
+	if err != nil {
+		return err
+	}
+	accessTokens, err := e.users.GetAccessTokens(ctx, userId)
+	if err != nil {
+		return err
+	}
+	identities, err := e.users.GetIdentities(ctx, userId)
+	if err != nil {
+		return err
+	}
+	memberships, err := e.memberships(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range []struct {
+		name  string
+		value any
+	}{
+		{"profile.json", u},
+		{"sessions.json", sessions},
+		{"access_tokens.json", accessTokens},
+		{"identities.json", identities},
+		{"workspaces.json", memberships},
+	} {
+		err = writeJson(archive, file.name, file.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = e.writeTodosJson(ctx, archive, userId)
+	if err != nil {
+		return err
+	}
+	err = e.writeTodosCsv(ctx, archive, userId)
+	if err != nil {
+		return err
+	}
+	err = e.writeActivity(ctx, archive, userId)
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+type membership struct {
+	workspace.Workspace
+	Role     workspace.Role `json:"role"`
+	JoinedAt time.Time      `json:"joined_at"`
+}
+
+func (e *Exporter) memberships(ctx context.Context, userId user.Id) ([]membership, error) {
+	workspaces, err := e.workspaces.GetByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	memberships := make([]membership, 0, len(workspaces))
+	for _, w := range workspaces {
+		member, err := e.workspaces.GetMember(ctx, w.Id, userId)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, membership{Workspace: w, Role: member.Role, JoinedAt: member.JoinedAt})
+	}
+	return memberships, nil
+}
+
+// eachTodo calls fn with every todo the user can see, one page at a time.
+func (e *Exporter) eachTodo(ctx context.Context, userId user.Id, fn func(todo.Todo) error) error {
+	options := todo.FindOptions{
+		Limit:     pageSize,
+		SortBy:    todo.IdName,
+		SortOrder: todo.SortAscending,
+		Owner:     todo.OwnerAny,
+	}
+	for {
+		todos, err := e.todos.GetByUserId(ctx, userId, options)
+		if err != nil {
+			return err
+		}
+		for _, t := range todos {
+			if err = fn(t); err != nil {
+				return err
+			}
+		}
+		if len(todos) < pageSize {
+			return nil
+		}
+		options.Offset += pageSize
+	}
+}
+
+func (e *Exporter) writeTodosJson(ctx context.Context, archive *zip.Writer, userId user.Id) error {
+	w, err := create(archive, "todos.json")
+	if err != nil {
+		return err
+	}
+	list := newJsonList(w)
+	err = e.eachTodo(ctx, userId, func(t todo.Todo) error {
+		return list.Add(t)
+	})
+	if err != nil {
+		return err
+	}
+	return list.Close()
+}
+
+func (e *Exporter) writeTodosCsv(ctx context.Context, archive *zip.Writer, userId user.Id) error {
+	w, err := create(archive, "todos.csv")
+	if err != nil {
+		return err
+	}
+	table := csv.NewWriter(w)
+	err = table.Write([]string{"id", "user_id", "workspace_id", "title", "description", "assignee_id", "created_at", "updated_at"})
+	if err != nil {
+		return err
+	}
+	err = e.eachTodo(ctx, userId, func(t todo.Todo) error {
+		return table.Write([]string{
+			string(t.Id), string(t.UserId), string(t.WorkspaceId), t.Title, t.Description, string(t.AssigneeId),
+			t.CreatedAt.UTC().Format(time.RFC3339), t.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	table.Flush()
+	return table.Error()
+}
+
+// writeActivity exports the events caused by the user, newest first.
+func (e *Exporter) writeActivity(ctx context.Context, archive *zip.Writer, userId user.Id) error {
+	w, err := create(archive, "activity.json")
+	if err != nil {
+		return err
+	}
+	list := newJsonList(w)
+	options := todo.ActivityOptions{Limit: pageSize}
+	for {
+		events, err := e.todos.GetActivity(ctx, userId, options)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if event.ActorId != userId {
+				continue
+			}
+			if err = list.Add(event); err != nil {
+				return err
+			}
+		}
+		if len(events) < pageSize {
+			return list.Close()
+		}
+		options.Cursor = events[len(events)-1].Id
+	}
+}
+
+// create adds a file to the archive, dated now.
+func create(archive *zip.Writer, name string) (io.Writer, error) {
+	return archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+}
+
+func writeJson(archive *zip.Writer, name string, value any) error {
+	w, err := create(archive, name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// jsonList streams a json array item by item.
+type jsonList struct {
+	w     io.Writer
+	count int
+}
+
+func newJsonList(w io.Writer) *jsonList {
+	return &jsonList{w: w}
+}
+
+func (l *jsonList) Add(value any) error {
+	item, err := json.MarshalIndent(value, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	separator := "[\n  "
+	if l.count > 0 {
+		separator = ",\n  "
+	}
+	l.count++
+	_, err = io.WriteString(l.w, separator+string(item))
+	return err
+}
+
+func (l *jsonList) Close() error {
+	end := "\n]\n"
+	if l.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(l.w, end)
+	return err
+}
@@ -0,0 +1,160 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/mattn/go-sqlite3"
+	"github.com/oklog/ulid/v2"
+	"strings"
+	"time"
+	"todo-api/user"
+)
+
+type Id string
+
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusReady   Status = "ready"
+	StatusFailed  Status = "failed"
+)
+
+var (
+	NotFound       = errors.New("export not found")
+	AlreadyPending = errors.New("an export is already being prepared")
+)
+
+type Export struct {
+	Id          Id         `json:"id"`
+	UserId      user.Id    `json:"-"`
+	Status      Status     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+type Storage interface {
+	Create(ctx context.Context, userId user.Id) (Export, error)
+	GetById(ctx context.Context, id Id) (Export, error)
+	Complete(ctx context.Context, id Id, expiresAt time.Time) error
+	// Fail marks the export as failed, the failure is shown until expiresAt.
+	Fail(ctx context.Context, id Id, reason string, expiresAt time.Time) error
+
+	// FailStale fails the pending exports created before createdBefore, their build was interrupted, e.g. by a restart.
+	FailStale(ctx context.Context, createdBefore time.Time, expiresAt time.Time) error
+	// GetExpired returns the exports expired before now and the exports of purged accounts.
+	GetExpired(ctx context.Context, now time.Time) ([]Export, error)
+	Delete(ctx context.Context, id Id) error
+}
+
+type SqliteStorage struct {
+	db *sql.DB
+}
+
+func NewSqliteStorage(db *sql.DB) *SqliteStorage {
+	return &SqliteStorage{db: db}
+}
+
+func (s SqliteStorage) Create(ctx context.Context, userId user.Id) (Export, error) {
+	id := Id(ulid.Make().String())
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO exports (id, user_id, status, created_at) VALUES (?, ?, ?, ?)",
+		id, userId, StatusPending, time.Now().UTC())
+	if err != nil {
+		return Export{}, mapError(err)
+	}
+	return s.GetById(ctx, id)
+}
+
+func (s SqliteStorage) GetById(ctx context.Context, id Id) (Export, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, status, error, created_at, completed_at, expires_at
+		FROM exports WHERE id=?
+	`, id)
+	export, err := scanExport(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Export{}, NotFound
+	}
+	return export, err
+}
+
+func (s SqliteStorage) Complete(ctx context.Context, id Id, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE exports SET status=?, completed_at=?, expires_at=? WHERE id=? AND status=?",
+		StatusReady, time.Now().UTC(), expiresAt.UTC(), id, StatusPending)
+	return err
+}
+
+func (s SqliteStorage) Fail(ctx context.Context, id Id, reason string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE exports SET status=?, error=?, completed_at=?, expires_at=? WHERE id=? AND status=?",
+		StatusFailed, reason, time.Now().UTC(), expiresAt.UTC(), id, StatusPending)
+	return err
+}
+
+func (s SqliteStorage) FailStale(ctx context.Context, createdBefore time.Time, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE exports SET status=?, error=?, completed_at=?, expires_at=? WHERE status=? AND created_at<?",
+		StatusFailed, "the export was interrupted", time.Now().UTC(), expiresAt.UTC(), StatusPending, createdBefore.UTC())
+	return err
+}
+
+func (s SqliteStorage) GetExpired(ctx context.Context, now time.Time) ([]Export, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, status, error, created_at, completed_at, expires_at
+		FROM exports
+		WHERE expires_at<=? OR user_id NOT IN (SELECT id FROM users)
+	`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []Export
+	for rows.Next() {
+		export, err := scanExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+func (s SqliteStorage) Delete(ctx context.Context, id Id) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM exports WHERE id=?", id)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanExport(row scanner) (Export, error) {
+	var export Export
+	var completedAt, expiresAt sql.NullTime
+	err := row.Scan(&export.Id, &export.UserId, &export.Status, &export.Error, &export.CreatedAt, &completedAt, &expiresAt)
+	if err != nil {
+		return Export{}, err
+	}
+	if completedAt.Valid {
+		export.CompletedAt = &completedAt.Time
+	}
+	if expiresAt.Valid {
+		export.ExpiresAt = &expiresAt.Time
+	}
+	return export, nil
+}
+
+func mapError(err error) error {
+	var sqlErr sqlite3.Error
+	if errors.As(err, &sqlErr) {
+		if errors.Is(sqlErr.Code, sqlite3.ErrConstraint) && strings.HasPrefix(err.Error(), "UNIQUE constraint failed") {
+			return AlreadyPending
+		}
+	}
+	return err
+}
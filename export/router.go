@@ -0,0 +1,122 @@
+package export
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v3"
+	"net/url"
+	"strconv"
+	"time"
+	"todo-api/config"
+	"todo-api/user"
+)
+
+func SetupRoutes(app *fiber.App, config *config.AppConfig, auth fiber.Handler, storage Storage, exporter *Exporter) {
+	app.Post("/me/export", CreateHandler(config, storage, exporter), auth, user.RequireSession)
+	app.Get("/me/exports/:id", GetHandler(config, storage), auth, user.RequireSession)
+	// the signed link is the authorization, so it can be opened from the email
+	app.Get("/exports/:id/download", DownloadHandler(config, storage, exporter))
+}
+
+type dto struct {
+	Export
+	DownloadUrl string `json:"download_url,omitempty"`
+}
+
+func toDto(config *config.AppConfig, export Export) dto {
+	d := dto{Export: export}
+	if export.Status == StatusReady && export.ExpiresAt != nil {
+		d.DownloadUrl = downloadUrl(config, export.Id, *export.ExpiresAt)
+	}
+	return d
+}
+
+// CreateHandler starts an export of the data of the user, the archive is built in the background.
+func CreateHandler(config *config.AppConfig, storage Storage, exporter *Exporter) fiber.Handler {
+	type CreateResponse dto
+
+	return func(ctx fiber.Ctx) error {
+		export, err := storage.Create(ctx.Context(), user.FromContext(ctx).Id)
+		if err != nil {
+			if errors.Is(err, AlreadyPending) {
+				return fiber.NewError(fiber.StatusConflict, err.Error())
+			}
+			return err
+		}
+
+		exporter.Start(export)
+
+		return ctx.Status(fiber.StatusAccepted).JSON(CreateResponse(toDto(config, export)))
+	}
+}
+
+func GetHandler(config *config.AppConfig, storage Storage) fiber.Handler {
+	type GetResponse dto
+
+	return func(ctx fiber.Ctx) error {
+		export, err := storage.GetById(ctx.Context(), Id(ctx.Params("id")))
+		if err != nil && !errors.Is(err, NotFound) {
+			return err
+		}
+		if err != nil || export.UserId != user.FromContext(ctx).Id {
+			return fiber.NewError(fiber.StatusNotFound, NotFound.Error())
+		}
+
+		return ctx.JSON(GetResponse(toDto(config, export)))
+	}
+}
+
+func DownloadHandler(config *config.AppConfig, storage Storage, exporter *Exporter) fiber.Handler {
+	type DownloadRequest struct {
+		Expires   int64  `query:"expires"`
+		Signature string `query:"signature"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		id := Id(ctx.Params("id"))
+		req := DownloadRequest{}
+		err := ctx.Bind().Query(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		expiresAt := time.Unix(req.Expires, 0)
+		if !hmac.Equal([]byte(req.Signature), []byte(signDownload(config, id, expiresAt))) || time.Now().After(expiresAt) {
+			return fiber.NewError(fiber.StatusForbidden, "invalid or expired download link")
+		}
+
+		export, err := storage.GetById(ctx.Context(), id)
+		if err != nil && !errors.Is(err, NotFound) {
+			return err
+		}
+		if err != nil || export.Status != StatusReady {
+			return fiber.NewError(fiber.StatusNotFound, NotFound.Error())
+		}
+
+		return ctx.Download(exporter.path(id), fmt.Sprintf("todo-api-export-%s.zip", export.CreatedAt.UTC().Format("2006-01-02")))
+	}
+}
+
+// downloadKey derives the key that signs download links from the jwt secret, like the email verification key.
+func downloadKey(config *config.AppConfig) []byte {
+	mac := hmac.New(sha256.New, []byte(config.JwtSecret))
+	mac.Write([]byte("export-download"))
+	return mac.Sum(nil)
+}
+
+func signDownload(config *config.AppConfig, id Id, expiresAt time.Time) string {
+	mac := hmac.New(sha256.New, downloadKey(config))
+	mac.Write([]byte(string(id) + "." + strconv.FormatInt(expiresAt.Unix(), 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// downloadUrl returns a link to the archive that works without a login until expiresAt.
+func downloadUrl(config *config.AppConfig, id Id, expiresAt time.Time) string {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("signature", signDownload(config, id, expiresAt))
+	return fmt.Sprintf("%s/exports/%s/download?%s", config.PublicUrl, url.PathEscape(string(id)), query.Encode())
+}
@@ -0,0 +1,82 @@
+package export
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"todo-api/config"
+)
+
+// readyStorage knows a single export that is ready for download.
+type readyStorage struct {
+	Storage
+	export Export
+}
+
+func (s readyStorage) GetById(_ context.Context, id Id) (Export, error) {
+	if id != s.export.Id {
+		return Export{}, NotFound
+	}
+	return s.export, nil
+}
+
+func downloadApp(t *testing.T, appConfig *config.AppConfig) (*fiber.App, Export) {
+	export := Export{Id: "01J0EXPORT", Status: StatusReady, CreatedAt: time.Now()}
+	assert.NoError(t, os.WriteFile(filepath.Join(appConfig.ExportDir, string(export.Id)+".zip"), []byte("archive"), 0o600))
+
+	app := fiber.New()
+	app.Get("/exports/:id/download", DownloadHandler(appConfig, readyStorage{export: export}, &Exporter{config: appConfig}))
+	return app, export
+}
+
+func download(t *testing.T, app *fiber.App, link string) (int, string) {
+	parsed, err := url.Parse(link)
+	assert.NoError(t, err)
+	resp, err := app.Test(httptest.NewRequest("GET", parsed.RequestURI(), nil))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestDownloadLink(t *testing.T) {
+	appConfig := &config.AppConfig{JwtSecret: "test secret", PublicUrl: "http://localhost:3000", ExportDir: t.TempDir()}
+	app, export := downloadApp(t, appConfig)
+	expiresAt := time.Now().Add(time.Hour)
+	link := downloadUrl(appConfig, export.Id, expiresAt)
+
+	status, body := download(t, app, link)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "archive", body)
+
+	status, _ = download(t, app, strings.Replace(link, "signature=", "signature=x", 1))
+	assert.Equal(t, 403, status, "changed signature")
+
+	later := strconv.FormatInt(expiresAt.Add(time.Hour).Unix(), 10)
+	status, _ = download(t, app, strings.Replace(link, strconv.FormatInt(expiresAt.Unix(), 10), later, 1))
+	assert.Equal(t, 403, status, "extended expiry")
+
+	status, _ = download(t, app, downloadUrl(&config.AppConfig{JwtSecret: "other secret"}, export.Id, expiresAt))
+	assert.Equal(t, 403, status, "signed with another secret")
+
+	status, _ = download(t, app, strings.Replace(link, string(export.Id), "01J0OTHER", 1))
+	assert.Equal(t, 403, status, "link of another export")
+}
+
+func TestExpiredDownloadLink(t *testing.T) {
+	appConfig := &config.AppConfig{JwtSecret: "test secret", PublicUrl: "http://localhost:3000", ExportDir: t.TempDir()}
+	app, export := downloadApp(t, appConfig)
+
+	status, _ := download(t, app, downloadUrl(appConfig, export.Id, time.Now().Add(-time.Second)))
+	assert.Equal(t, 403, status)
+}
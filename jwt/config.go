@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
 	"time"
 
@@ -15,6 +16,9 @@ import (
 var (
 	// ErrJWTAlg is returned when the JWT header did not contain the expected algorithm.
 	ErrJWTAlg = errors.New("the JWT header did not contain the expected algorithm")
+
+	// ErrJWTMissingClaim is returned when the JWT did not contain one of the RequiredClaims.
+	ErrJWTMissingClaim = errors.New("the JWT is missing a required claim")
 )
 
 // Config defines the config for JWT middleware
@@ -86,6 +90,29 @@ type Config struct {
 	// At least one of the following is required: KeyFunc, JWKSetURLs, SigningKeys, or SigningKey.
 	// The order of precedence is: KeyFunc, JWKSetURLs, SigningKeys, SigningKey.
 	JWKSetURLs []string
+
+	// Issuer is the value the "iss" claim has to match. It is not checked if empty.
+	// Optional. Default: ""
+	Issuer string
+
+	// Audience is a value the "aud" claim has to contain. It is not checked if empty.
+	// Optional. Default: ""
+	Audience string
+
+	// Leeway is the clock skew tolerated when checking the "exp" and "nbf" claims,
+	// for tokens issued by servers whose clocks drift apart.
+	// Optional. Default: 0
+	Leeway time.Duration
+
+	// RequiredClaims are the claims a token has to contain, e.g. "exp" or "sub". Tokens without one of them,
+	// or with a null value, fail with ErrJWTMissingClaim. Requiring "exp" also rejects tokens that never expire.
+	// Optional. Default: nil
+	RequiredClaims []string
+
+	// DPoP enables tokens bound to a key of the client, which are sent with the DPoP scheme
+	// in the Authorization header, whatever the TokenLookup. See DPoPConfig.
+	// Optional. Default: nil
+	DPoP *DPoPConfig
 }
 
 // SigningKey holds information about the recognized cryptographic keys used to sign JWTs by this program.
@@ -137,33 +164,67 @@ func makeCfg(config []Config) (cfg Config) {
 	}
 
 	if cfg.KeyFunc == nil {
-		if len(cfg.SigningKeys) > 0 || len(cfg.JWKSetURLs) > 0 {
-			var givenKeys map[string]keyfunc.GivenKey
-			if cfg.SigningKeys != nil {
-				givenKeys = make(map[string]keyfunc.GivenKey, len(cfg.SigningKeys))
-				for kid, key := range cfg.SigningKeys {
-					givenKeys[kid] = keyfunc.NewGivenCustom(key.Key, keyfunc.GivenKeyOptions{
-						Algorithm: key.JWTAlg,
-					})
-				}
-			}
-			if len(cfg.JWKSetURLs) > 0 {
-				var err error
-				cfg.KeyFunc, err = multiKeyfunc(givenKeys, cfg.JWKSetURLs)
-				if err != nil {
-					panic("Failed to create keyfunc from JWK Set URL: " + err.Error())
-				}
-			} else {
-				cfg.KeyFunc = keyfunc.NewGiven(givenKeys).Keyfunc
-			}
-		} else {
-			cfg.KeyFunc = signingKeyFunc(cfg.SigningKey)
+		var err error
+		cfg.KeyFunc, err = makeKeyFunc(cfg)
+		if err != nil {
+			panic("Failed to create keyfunc from JWK Set URL: " + err.Error())
 		}
 	}
 
 	return cfg
 }
 
+// parserOptions turns the claim checks of the config into options of the jwt parser.
+func (cfg *Config) parserOptions() []jwt.ParserOption {
+	var options []jwt.ParserOption
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+	if cfg.Leeway > 0 {
+		options = append(options, jwt.WithLeeway(cfg.Leeway))
+	}
+	if slices.Contains(cfg.RequiredClaims, "exp") {
+		options = append(options, jwt.WithExpirationRequired())
+	}
+	return options
+}
+
+// KeyFunc returns the function the middleware would verify signatures with for the config,
+// e.g. to verify tokens that do not come with a request, like the ID tokens of an OpenID Connect provider.
+// Unlike New it returns an error instead of panicking when a JWK Set cannot be fetched.
+func KeyFunc(config Config) (jwt.Keyfunc, error) {
+	if config.KeyFunc != nil {
+		return config.KeyFunc, nil
+	}
+	if config.SigningKey.Key == nil && len(config.SigningKeys) == 0 && len(config.JWKSetURLs) == 0 {
+		return nil, errors.New("at least one of the following is required: KeyFunc, JWKSetURLs, SigningKeys, or SigningKey")
+	}
+	return makeKeyFunc(config)
+}
+
+func makeKeyFunc(cfg Config) (jwt.Keyfunc, error) {
+	if len(cfg.SigningKeys) == 0 && len(cfg.JWKSetURLs) == 0 {
+		return signingKeyFunc(cfg.SigningKey), nil
+	}
+
+	var givenKeys map[string]keyfunc.GivenKey
+	if cfg.SigningKeys != nil {
+		givenKeys = make(map[string]keyfunc.GivenKey, len(cfg.SigningKeys))
+		for kid, key := range cfg.SigningKeys {
+			givenKeys[kid] = keyfunc.NewGivenCustom(key.Key, keyfunc.GivenKeyOptions{
+				Algorithm: key.JWTAlg,
+			})
+		}
+	}
+	if len(cfg.JWKSetURLs) > 0 {
+		return multiKeyfunc(givenKeys, cfg.JWKSetURLs)
+	}
+	return keyfunc.NewGiven(givenKeys).Keyfunc, nil
+}
+
 func multiKeyfunc(givenKeys map[string]keyfunc.GivenKey, jwkSetURLs []string) (jwt.Keyfunc, error) {
 	opts := keyfuncOptions(givenKeys)
 	multiple := make(map[string]keyfunc.Options, len(jwkSetURLs))
@@ -0,0 +1,322 @@
+package jwtware
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DPoPScheme is the Authorization scheme of tokens bound to a key, see RFC 9449 section 7.1.
+const DPoPScheme = "DPoP"
+
+const dpopHeader = "DPoP"
+
+var (
+	// ErrDPoPProof is returned when the DPoP proof of a request is missing or invalid,
+	// or a token bound to a key is sent without one.
+	ErrDPoPProof = errors.New("invalid DPoP proof")
+
+	// DPoPAlgs are the algorithms accepted for DPoP proofs, asymmetric ones only.
+	DPoPAlgs = []string{RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512, "EdDSA"}
+
+	defaultReplayCache = NewMemoryReplayCache()
+)
+
+// DPoPConfig enables sender-constrained tokens, see RFC 9449. Tokens with a "cnf" claim holding the "jkt"
+// thumbprint of a key are only accepted with the DPoP scheme and a proof signed by that key,
+// so a stolen token is useless without the private key. Tokens without the claim keep working as bearer tokens.
+type DPoPConfig struct {
+	// BaseURL is the scheme, host and optional path prefix the "htu" claim of proofs is compared with,
+	// joined with the path of the request, e.g. the public URL when running behind a proxy.
+	// Optional. Default: the base URL of the request
+	BaseURL string
+
+	// MaxAge is how far the "iat" claim of proofs may be off the current time.
+	// Optional. Default: 1 minute
+	MaxAge time.Duration
+
+	// ReplayCache remembers the "jti" claim of proofs, so every proof is accepted once.
+	// Use a shared cache when several processes verify proofs.
+	// Optional. Default: an in-memory cache
+	ReplayCache ReplayCache
+}
+
+// ReplayCache remembers the ids of DPoP proofs until they expire.
+type ReplayCache interface {
+	// Add records the id until expiresAt and reports whether it was not recorded before.
+	Add(ctx context.Context, id string, expiresAt time.Time) (bool, error)
+}
+
+type dpopClaims struct {
+	jwt.RegisteredClaims
+	Htm string `json:"htm"`
+	Htu string `json:"htu"`
+	Ath string `json:"ath"`
+}
+
+// VerifyDPoPProof checks the DPoP header of the request and returns the thumbprint of the key that signed it,
+// see RFC 7638. Proofs sent with an access token have to carry its hash, at token endpoints accessToken is empty.
+func VerifyDPoPProof(c fiber.Ctx, config DPoPConfig, accessToken string) (string, error) {
+	proofs := c.Request().Header.PeekAll(dpopHeader)
+	if len(proofs) != 1 {
+		return "", fmt.Errorf("%w: exactly one DPoP header is required", ErrDPoPProof)
+	}
+
+	var thumbprint string
+	claims := &dpopClaims{}
+	_, err := jwt.ParseWithClaims(string(proofs[0]), claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["typ"] != "dpop+jwt" {
+			return nil, errors.New("the typ header is not dpop+jwt")
+		}
+		jwk, ok := token.Header["jwk"].(map[string]interface{})
+		if !ok {
+			return nil, errors.New("the jwk header is missing")
+		}
+		key, keyThumbprint, err := parsePublicJWK(jwk)
+		thumbprint = keyThumbprint
+		return key, err
+	}, jwt.WithValidMethods(DPoPAlgs))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrDPoPProof, err)
+	}
+
+	if claims.ID == "" || len(claims.ID) > 256 {
+		return "", fmt.Errorf("%w: the jti claim is missing or too long", ErrDPoPProof)
+	}
+	if claims.Htm != c.Method() {
+		return "", fmt.Errorf("%w: the htm claim does not match the request method", ErrDPoPProof)
+	}
+	if !sameURL(claims.Htu, dpopURL(c, config)) {
+		return "", fmt.Errorf("%w: the htu claim does not match the request url", ErrDPoPProof)
+	}
+	maxAge := config.MaxAge
+	if maxAge <= 0 {
+		maxAge = time.Minute
+	}
+	if claims.IssuedAt == nil || time.Since(claims.IssuedAt.Time).Abs() > maxAge {
+		return "", fmt.Errorf("%w: the iat claim is missing or not recent", ErrDPoPProof)
+	}
+	if accessToken != "" && claims.Ath != tokenHash(accessToken) {
+		return "", fmt.Errorf("%w: the ath claim does not match the access token", ErrDPoPProof)
+	}
+
+	cache := config.ReplayCache
+	if cache == nil {
+		cache = defaultReplayCache
+	}
+	fresh, err := cache.Add(c.Context(), claims.ID, claims.IssuedAt.Add(maxAge))
+	if err != nil {
+		return "", err
+	}
+	if !fresh {
+		return "", fmt.Errorf("%w: the proof was used before", ErrDPoPProof)
+	}
+	return thumbprint, nil
+}
+
+// checkDPoP verifies the proof of tokens bound to a key, which have to be sent with the DPoP scheme.
+func (cfg *Config) checkDPoP(c fiber.Ctx, parser *jwt.Parser, token *jwt.Token, dpop bool) error {
+	claims, err := payloadClaims(parser, token)
+	if err != nil {
+		return err
+	}
+	var confirmation struct {
+		Jkt string `json:"jkt"`
+	}
+	if cnf, ok := claims["cnf"]; ok {
+		if err = json.Unmarshal(cnf, &confirmation); err != nil {
+			return fmt.Errorf("%w: %w", jwt.ErrTokenMalformed, err)
+		}
+	}
+
+	switch {
+	case confirmation.Jkt == "" && !dpop:
+		return nil
+	case confirmation.Jkt == "":
+		return fmt.Errorf("%w: the token is not bound to a key", ErrDPoPProof)
+	case !dpop:
+		return fmt.Errorf("%w: the token is bound to a key and has to be sent with the DPoP scheme", ErrDPoPProof)
+	}
+
+	thumbprint, err := VerifyDPoPProof(c, *cfg.DPoP, token.Raw)
+	if err != nil {
+		return err
+	}
+	if thumbprint != confirmation.Jkt {
+		return fmt.Errorf("%w: the proof is not signed by the key of the token", ErrDPoPProof)
+	}
+	return nil
+}
+
+// dpopToken extracts a token sent with the DPoP scheme.
+func dpopToken(c fiber.Ctx) (string, bool) {
+	auth := c.Get(fiber.HeaderAuthorization)
+	l := len(DPoPScheme)
+	if len(auth) > l+1 && strings.EqualFold(auth[:l], DPoPScheme) && auth[l] == ' ' {
+		return strings.TrimSpace(auth[l:]), true
+	}
+	return "", false
+}
+
+func dpopURL(c fiber.Ctx, config DPoPConfig) string {
+	baseURL := config.BaseURL
+	if baseURL == "" {
+		baseURL = c.BaseURL()
+	}
+	return strings.TrimRight(baseURL, "/") + c.Path()
+}
+
+// sameURL compares the htu claim with the url of the request without query and fragment, see RFC 9449 section 4.3.
+func sameURL(htu string, expected string) bool {
+	actual, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	want, err := url.Parse(expected)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(actual.Scheme, want.Scheme) && strings.EqualFold(actual.Host, want.Host) &&
+		actual.EscapedPath() == want.EscapedPath()
+}
+
+func tokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// parsePublicJWK returns the public key of a JWK and its thumbprint, see RFC 7638.
+func parsePublicJWK(jwk map[string]interface{}) (interface{}, string, error) {
+	member := func(name string) ([]byte, string, error) {
+		value, _ := jwk[name].(string)
+		decoded, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(decoded) == 0 {
+			return nil, "", fmt.Errorf("the %s member of the jwk is invalid", name)
+		}
+		return decoded, value, nil
+	}
+	if _, ok := jwk["d"]; ok {
+		return nil, "", errors.New("the jwk contains a private key")
+	}
+
+	var key interface{}
+	var canonical string
+	switch jwk["kty"] {
+	case "EC":
+		crv, _ := jwk["crv"].(string)
+		var curve elliptic.Curve
+		var ecdhCurve ecdh.Curve
+		switch crv {
+		case P256:
+			curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+		case P384:
+			curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+		case P521:
+			curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, "", fmt.Errorf("unsupported curve %q", crv)
+		}
+		x, xValue, err := member("x")
+		if err != nil {
+			return nil, "", err
+		}
+		y, yValue, err := member("y")
+		if err != nil {
+			return nil, "", err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, "", errors.New("the coordinates of the jwk do not fit the curve")
+		}
+		// crypto/ecdh checks that the point is on the curve
+		if _, err = ecdhCurve.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, "", errors.New("the point of the jwk is not on the curve")
+		}
+		key = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		canonical = `{"crv":"` + crv + `","kty":"EC","x":"` + xValue + `","y":"` + yValue + `"}`
+	case "RSA":
+		n, nValue, err := member("n")
+		if err != nil {
+			return nil, "", err
+		}
+		e, eValue, err := member("e")
+		if err != nil {
+			return nil, "", err
+		}
+		if len(e) > 4 {
+			return nil, "", errors.New("the exponent of the jwk is too large")
+		}
+		publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if publicKey.N.BitLen() < 2048 {
+			return nil, "", errors.New("the rsa key of the jwk is shorter than 2048 bits")
+		}
+		key = publicKey
+		canonical = `{"e":"` + eValue + `","kty":"RSA","n":"` + nValue + `"}`
+	case "OKP":
+		if jwk["crv"] != "Ed25519" {
+			return nil, "", fmt.Errorf("unsupported curve %q", jwk["crv"])
+		}
+		x, xValue, err := member("x")
+		if err != nil {
+			return nil, "", err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, "", errors.New("the ed25519 key of the jwk has the wrong size")
+		}
+		key = ed25519.PublicKey(x)
+		canonical = `{"crv":"Ed25519","kty":"OKP","x":"` + xValue + `"}`
+	default:
+		return nil, "", fmt.Errorf("unsupported key type %q", jwk["kty"])
+	}
+
+	hash := sha256.Sum256([]byte(canonical))
+	return key, base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+type memoryReplayCache struct {
+	mu        sync.Mutex
+	ids       map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryReplayCache returns a ReplayCache kept in memory, for a single process.
+func NewMemoryReplayCache() ReplayCache {
+	return &memoryReplayCache{ids: map[string]time.Time{}, lastSweep: time.Now()}
+}
+
+func (m *memoryReplayCache) Add(_ context.Context, id string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > time.Minute {
+		for key, keyExpiresAt := range m.ids {
+			if keyExpiresAt.Before(now) {
+				delete(m.ids, key)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	if seenExpiresAt, ok := m.ids[id]; ok && seenExpiresAt.After(now) {
+		return false, nil
+	}
+	m.ids[id] = expiresAt
+	return true, nil
+}
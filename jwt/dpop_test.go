@@ -0,0 +1,170 @@
+package jwtware_test
+
+import (
+	"context"
+	ecdsakey "crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	jwtware "jwt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const dpopBaseURL = "https://api.example"
+
+type dpopKey struct {
+	private    *ecdsakey.PrivateKey
+	jwk        map[string]interface{}
+	thumbprint string
+}
+
+func newDPoPKey(t *testing.T) dpopKey {
+	private, err := ecdsakey.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Equal(t, nil, err)
+
+	encode := base64.RawURLEncoding.EncodeToString
+	x, y := encode(private.X.FillBytes(make([]byte, 32))), encode(private.Y.FillBytes(make([]byte, 32)))
+	// RFC 7638: the required members in lexicographic order, without whitespace
+	hash := sha256.Sum256([]byte(`{"crv":"P-256","kty":"EC","x":"` + x + `","y":"` + y + `"}`))
+	return dpopKey{
+		private:    private,
+		jwk:        map[string]interface{}{"kty": "EC", "crv": "P-256", "x": x, "y": y},
+		thumbprint: encode(hash[:]),
+	}
+}
+
+func (k dpopKey) proof(t *testing.T, method string, url string, accessToken string, issuedAt time.Time) string {
+	claims := jwt.MapClaims{
+		"jti": base64.RawURLEncoding.EncodeToString(randomBytes(t)),
+		"htm": method,
+		"htu": url,
+		"iat": issuedAt.Unix(),
+	}
+	if accessToken != "" {
+		hash := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(hash[:])
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = k.jwk
+	proof, err := token.SignedString(k.private)
+	assert.Equal(t, nil, err)
+	return proof
+}
+
+func randomBytes(t *testing.T) []byte {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	assert.Equal(t, nil, err)
+	return b
+}
+
+func TestDPoP(t *testing.T) {
+	t.Parallel()
+
+	key := newDPoPKey(t)
+	other := newDPoPKey(t)
+	bound := signedToken(t, jwt.MapClaims{"sub": "1234567890", "cnf": map[string]string{"jkt": key.thumbprint}})
+	unbound := signedToken(t, jwt.MapClaims{"sub": "1234567890"})
+	now := time.Now()
+	replayed := key.proof(t, "GET", dpopBaseURL+"/ok", bound, now)
+
+	tests := []struct {
+		name          string
+		authorization string
+		proof         string
+		status        int
+	}{
+		{"valid proof", "DPoP " + bound, key.proof(t, "GET", dpopBaseURL+"/ok", bound, now), 200},
+		{"query and fragment are ignored", "DPoP " + bound, key.proof(t, "GET", dpopBaseURL+"/ok?page=2#top", bound, now), 200},
+		{"first use", "DPoP " + bound, replayed, 200},
+		{"replayed proof", "DPoP " + bound, replayed, 401},
+		{"missing proof", "DPoP " + bound, "", 401},
+		{"bound token as bearer token", "Bearer " + bound, key.proof(t, "GET", dpopBaseURL+"/ok", bound, now), 401},
+		{"unbound token as bearer token", "Bearer " + unbound, "", 200},
+		{"unbound token with proof", "DPoP " + unbound, key.proof(t, "GET", dpopBaseURL+"/ok", unbound, now), 401},
+		{"other key", "DPoP " + bound, other.proof(t, "GET", dpopBaseURL+"/ok", bound, now), 401},
+		{"wrong method", "DPoP " + bound, key.proof(t, "POST", dpopBaseURL+"/ok", bound, now), 401},
+		{"wrong url", "DPoP " + bound, key.proof(t, "GET", "https://evil.example/ok", bound, now), 401},
+		{"other access token", "DPoP " + bound, key.proof(t, "GET", dpopBaseURL+"/ok", unbound, now), 401},
+		{"stale proof", "DPoP " + bound, key.proof(t, "GET", dpopBaseURL+"/ok", bound, now.Add(-2*time.Minute)), 401},
+		{"proof from the future", "DPoP " + bound, key.proof(t, "GET", dpopBaseURL+"/ok", bound, now.Add(2*time.Minute)), 401},
+	}
+
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)},
+		DPoP:       &jwtware.DPoPConfig{BaseURL: dpopBaseURL},
+	}))
+	app.Get("/ok", func(c fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", test.authorization)
+		if test.proof != "" {
+			req.Header.Add("DPoP", test.proof)
+		}
+
+		resp, err := app.Test(req)
+
+		assert.Equal(t, nil, err, test.name)
+		assert.Equal(t, test.status, resp.StatusCode, test.name)
+	}
+}
+
+func TestVerifyDPoPProof(t *testing.T) {
+	t.Parallel()
+
+	key := newDPoPKey(t)
+	var thumbprint string
+	var verifyErr error
+
+	app := fiber.New()
+	app.Post("/token", func(c fiber.Ctx) error {
+		thumbprint, verifyErr = jwtware.VerifyDPoPProof(c, jwtware.DPoPConfig{}, "")
+		return nil
+	})
+
+	// without a BaseURL the proof is checked against the url of the request
+	req := httptest.NewRequest("POST", "http://example.com/token", nil)
+	req.Header.Add("DPoP", key.proof(t, "POST", "http://example.com/token", "", time.Now()))
+	_, err := app.Test(req)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, verifyErr)
+	assert.Equal(t, key.thumbprint, thumbprint)
+
+	// a proof carrying the private key is rejected
+	key.jwk["d"] = base64.RawURLEncoding.EncodeToString(key.private.D.Bytes())
+	req = httptest.NewRequest("POST", "http://example.com/token", nil)
+	req.Header.Add("DPoP", key.proof(t, "POST", "http://example.com/token", "", time.Now()))
+	_, err = app.Test(req)
+	assert.Equal(t, nil, err)
+	assert.ErrorIs(t, verifyErr, jwtware.ErrDPoPProof)
+}
+
+func TestMemoryReplayCache(t *testing.T) {
+	t.Parallel()
+
+	cache := jwtware.NewMemoryReplayCache()
+	fresh, err := cache.Add(context.Background(), "id", time.Now().Add(time.Minute))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, fresh)
+
+	fresh, _ = cache.Add(context.Background(), "id", time.Now().Add(time.Minute))
+	assert.Equal(t, false, fresh)
+
+	// expired ids can be used again
+	fresh, _ = cache.Add(context.Background(), "expired", time.Now().Add(-time.Second))
+	assert.Equal(t, true, fresh)
+	fresh, _ = cache.Add(context.Background(), "expired", time.Now().Add(time.Minute))
+	assert.Equal(t, true, fresh)
+}
@@ -6,7 +6,10 @@
 package jwtware
 
 import (
+	"encoding/json"
+	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/golang-jwt/jwt/v5"
@@ -21,6 +24,7 @@ func New(config ...Config) fiber.Handler {
 	cfg := makeCfg(config)
 
 	extractors := cfg.getExtractors()
+	parser := jwt.NewParser(cfg.parserOptions()...)
 
 	// Return middleware handler
 	return func(c fiber.Ctx) error {
@@ -30,26 +34,27 @@ func New(config ...Config) fiber.Handler {
 		}
 		var auth string
 		var err error
+		var dpop bool
 
-		for _, extractor := range extractors {
-			auth, err = extractor(c)
-			if auth != "" && err == nil {
-				break
+		if cfg.DPoP != nil {
+			auth, dpop = dpopToken(c)
+		}
+		if !dpop {
+			for _, extractor := range extractors {
+				auth, err = extractor(c)
+				if auth != "" && err == nil {
+					break
+				}
 			}
 		}
 		if err != nil {
 			return cfg.ErrorHandler(c, err)
 		}
-		var token *jwt.Token
-
-		if _, ok := cfg.Claims.(jwt.MapClaims); ok {
-			token, err = jwt.Parse(auth, cfg.KeyFunc)
-		} else {
-			t := reflect.ValueOf(cfg.Claims).Type().Elem()
-			claims := reflect.New(t).Interface().(jwt.Claims)
-			token, err = jwt.ParseWithClaims(auth, claims, cfg.KeyFunc)
+		token, err := cfg.parse(parser, auth)
+		if err == nil && cfg.DPoP != nil {
+			err = cfg.checkDPoP(c, parser, token, dpop)
 		}
-		if err == nil && token.Valid {
+		if err == nil {
 			// Store user information from token into context.
 			c.Locals(cfg.ContextKey, token)
 			return cfg.SuccessHandler(c)
@@ -57,3 +62,76 @@ func New(config ...Config) fiber.Handler {
 		return cfg.ErrorHandler(c, err)
 	}
 }
+
+// Parse verifies a token with the config like the middleware does, e.g. a token that is not sent in a header.
+func Parse(config Config, tokenString string) (*jwt.Token, error) {
+	keyFunc, err := KeyFunc(config)
+	if err != nil {
+		return nil, err
+	}
+	config.KeyFunc = keyFunc
+	if config.Claims == nil {
+		config.Claims = jwt.MapClaims{}
+	}
+	return config.parse(jwt.NewParser(config.parserOptions()...), tokenString)
+}
+
+func (cfg *Config) parse(parser *jwt.Parser, auth string) (*jwt.Token, error) {
+	var token *jwt.Token
+	var err error
+
+	if _, ok := cfg.Claims.(jwt.MapClaims); ok {
+		token, err = parser.Parse(auth, cfg.KeyFunc)
+	} else {
+		t := reflect.ValueOf(cfg.Claims).Type().Elem()
+		claims := reflect.New(t).Interface().(jwt.Claims)
+		token, err = parser.ParseWithClaims(auth, claims, cfg.KeyFunc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenUnverifiable
+	}
+	if err = cfg.checkRequiredClaims(parser, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// checkRequiredClaims looks the RequiredClaims up in the payload of the token,
+// as typed claims cannot tell a missing claim from a zero value.
+func (cfg *Config) checkRequiredClaims(parser *jwt.Parser, token *jwt.Token) error {
+	if len(cfg.RequiredClaims) == 0 {
+		return nil
+	}
+
+	claims, err := payloadClaims(parser, token)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range cfg.RequiredClaims {
+		if value, ok := claims[name]; !ok || string(value) == "null" {
+			return fmt.Errorf("%w: %s", ErrJWTMissingClaim, name)
+		}
+	}
+	return nil
+}
+
+// payloadClaims decodes the claims of the token without their types.
+func payloadClaims(parser *jwt.Parser, token *jwt.Token) (map[string]json.RawMessage, error) {
+	parts := strings.Split(token.Raw, ".")
+	if len(parts) != 3 {
+		return nil, jwt.ErrTokenMalformed
+	}
+	payload, err := parser.DecodeSegment(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", jwt.ErrTokenMalformed, err)
+	}
+	claims := map[string]json.RawMessage{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", jwt.ErrTokenMalformed, err)
+	}
+	return claims, nil
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/golang-jwt/jwt/v5"
@@ -400,3 +401,139 @@ func customKeyfunc() jwt.Keyfunc {
 		return []byte(defaultSigningKey), nil
 	}
 }
+
+func TestKeyFuncFromServer(t *testing.T) {
+	// Create the HTTP test server serving the JWKs.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(defaultKeySet))
+	}))
+	defer server.Close()
+
+	// Arrange
+	keyFunc, err := jwtware.KeyFunc(jwtware.Config{
+		JWKSetURLs: []string{server.URL},
+	})
+	assert.Equal(t, nil, err)
+
+	for _, test := range append(rsa, ecdsa...) {
+		// Act
+		token, err := jwt.Parse(test.Token, keyFunc)
+
+		// Assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, token.Valid)
+	}
+}
+
+func TestKeyFuncErrors(t *testing.T) {
+	// Nothing to verify with
+	_, err := jwtware.KeyFunc(jwtware.Config{})
+	assert.NotEqual(t, nil, err)
+
+	// The JWK Set cannot be fetched
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	_, err = jwtware.KeyFunc(jwtware.Config{
+		JWKSetURLs: []string{server.URL},
+	})
+	assert.NotEqual(t, nil, err)
+}
+
+func signedToken(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(defaultSigningKey))
+	assert.Equal(t, nil, err)
+	return token
+}
+
+func TestClaimValidation(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	valid := jwt.MapClaims{
+		"sub": "1234567890",
+		"iss": "https://todo.example",
+		"aud": "todo-api",
+		"exp": now.Add(time.Minute).Unix(),
+	}
+	without := func(name string) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		for key, value := range valid {
+			if key != name {
+				claims[key] = value
+			}
+		}
+		return claims
+	}
+	with := func(name string, value any) jwt.MapClaims {
+		claims := without(name)
+		claims[name] = value
+		return claims
+	}
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		status int
+	}{
+		{"valid", valid, 200},
+		{"wrong issuer", with("iss", "https://evil.example"), 401},
+		{"wrong audience", with("aud", []string{"other-api"}), 401},
+		{"expired within leeway", with("exp", now.Add(-10*time.Second).Unix()), 200},
+		{"expired beyond leeway", with("exp", now.Add(-time.Minute).Unix()), 401},
+		{"not yet valid within leeway", with("nbf", now.Add(10*time.Second).Unix()), 200},
+		{"missing subject", without("sub"), 401},
+		{"null subject", with("sub", nil), 401},
+		{"missing expiration", without("exp"), 401},
+	}
+
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey:     jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)},
+		Issuer:         "https://todo.example",
+		Audience:       "todo-api",
+		Leeway:         30 * time.Second,
+		RequiredClaims: []string{"sub", "exp"},
+	}))
+	app.Get("/ok", func(c fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+signedToken(t, test.claims))
+
+		resp, err := app.Test(req)
+
+		assert.Equal(t, nil, err, test.name)
+		assert.Equal(t, test.status, resp.StatusCode, test.name)
+	}
+}
+
+type customClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	config := jwtware.Config{
+		SigningKey:     jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)},
+		Claims:         &customClaims{},
+		RequiredClaims: []string{"email"},
+	}
+
+	token, err := jwtware.Parse(config, signedToken(t, jwt.MapClaims{"sub": "1234567890", "email": "john@example.com"}))
+	assert.Equal(t, nil, err)
+	claims, ok := token.Claims.(*customClaims)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "john@example.com", claims.Email)
+
+	// a typed claim would be the zero value
+	_, err = jwtware.Parse(config, signedToken(t, jwt.MapClaims{"sub": "1234567890"}))
+	assert.ErrorIs(t, err, jwtware.ErrJWTMissingClaim)
+
+	_, err = jwtware.Parse(config, signedToken(t, jwt.MapClaims{"email": 42}))
+	assert.ErrorIs(t, err, jwt.ErrTokenMalformed)
+}
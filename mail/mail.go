@@ -0,0 +1,86 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"github.com/oklog/ulid/v2"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"todo-api/config"
+)
+
+const (
+	MailerLog  = "log"
+	MailerFile = "file"
+	MailerSmtp = "smtp"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+func New(config *config.AppConfig) (Mailer, error) {
+	switch config.Mailer {
+	case MailerLog:
+		return NewLogMailer(config.MailFrom), nil
+	case MailerFile:
+		return NewFileMailer(config.MailFrom, config.MailDir)
+	case MailerSmtp:
+		return NewSmtpMailer(config.MailFrom, config.SmtpAddress(), config.SmtpUsername, config.SmtpPassword), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %s", config.Mailer)
+	}
+}
+
+// LogMailer writes messages to the application log instead of delivering them.
+type LogMailer struct {
+	from string
+}
+
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{from: from}
+}
+
+func (m LogMailer) Send(_ context.Context, message Message) error {
+	log.Printf("mail from:%s to:%s subject:%s\n%s", m.from, message.To, message.Subject, message.Body)
+	return nil
+}
+
+// FileMailer stores every message as a separate .eml file in a directory.
+type FileMailer struct {
+	from string
+	dir  string
+}
+
+func NewFileMailer(from string, dir string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMailer{from: from, dir: dir}, nil
+}
+
+func (m FileMailer) Send(_ context.Context, message Message) error {
+	path := filepath.Join(m.dir, ulid.Make().String()+".eml")
+	return os.WriteFile(path, format(m.from, message), 0o600)
+}
+
+// headerReplacer drops line breaks from header values, so user input cannot add headers.
+var headerReplacer = strings.NewReplacer("\r", "", "\n", "")
+
+func format(from string, message Message) []byte {
+	return []byte(fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		headerReplacer.Replace(from), headerReplacer.Replace(message.To), headerReplacer.Replace(message.Subject),
+		time.Now().Format(time.RFC1123Z), message.Body,
+	))
+}
@@ -0,0 +1,108 @@
+package mail
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// fakeSmtpServer accepts one connection and records the commands and the message it receives.
+type fakeSmtpServer struct {
+	listener net.Listener
+	commands []string
+	data     string
+	done     chan struct{}
+}
+
+func startFakeSmtpServer(t *testing.T) *fakeSmtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeSmtpServer{listener: listener, done: make(chan struct{})}
+	go server.serve()
+	return server
+}
+
+func (s *fakeSmtpServer) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	_ = text.PrintfLine("220 localhost fake smtp")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		s.commands = append(s.commands, line)
+
+		verb, _, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			_ = text.PrintfLine("250-localhost")
+			_ = text.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			_ = text.PrintfLine("235 authenticated")
+		case "DATA":
+			_ = text.PrintfLine("354 send the message")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(data)
+			_ = text.PrintfLine("250 queued")
+		case "QUIT":
+			_ = text.PrintfLine("221 bye")
+			return
+		default:
+			_ = text.PrintfLine("250 ok")
+		}
+	}
+}
+
+func TestSmtpMailer(t *testing.T) {
+	server := startFakeSmtpServer(t)
+	mailer := NewSmtpMailer("todo-api@localhost", server.listener.Addr().String(), "user", "secret")
+
+	err := mailer.Send(context.Background(), Message{
+		To:      "someone@example.com",
+		Subject: "Hello\r\nBcc: evil@example.com",
+		Body:    "the body",
+	})
+	assert.NoError(t, err)
+	<-server.done
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("\x00user\x00secret"))
+	assert.Contains(t, server.commands, "AUTH PLAIN "+credentials)
+	assert.Contains(t, server.commands, "MAIL FROM:<todo-api@localhost>")
+	assert.Contains(t, server.commands, "RCPT TO:<someone@example.com>")
+	assert.Equal(t, "QUIT", server.commands[len(server.commands)-1])
+
+	assert.Contains(t, server.data, "From: todo-api@localhost\n")
+	assert.Contains(t, server.data, "To: someone@example.com\n")
+	// line breaks in header values cannot add headers
+	assert.Contains(t, server.data, "Subject: HelloBcc: evil@example.com\n")
+	assert.Contains(t, server.data, "\n\nthe body\n")
+}
+
+func TestSmtpMailerWithoutCredentials(t *testing.T) {
+	server := startFakeSmtpServer(t)
+	mailer := NewSmtpMailer("todo-api@localhost", server.listener.Addr().String(), "", "")
+
+	err := mailer.Send(context.Background(), Message{To: "someone@example.com", Subject: "Hello", Body: "the body"})
+	assert.NoError(t, err)
+	<-server.done
+
+	for _, command := range server.commands {
+		assert.False(t, strings.HasPrefix(command, "AUTH"), "credentials are only sent when configured")
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/healthcheck"
 	"github.com/gofiber/fiber/v3/middleware/logger"
 	"github.com/gofiber/fiber/v3/middleware/recover"
+	"github.com/gofiber/fiber/v3/middleware/requestid"
 	"github.com/joho/godotenv"
 	_ "github.com/mattn/go-sqlite3"
 	"log"
@@ -13,10 +15,15 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata"
 	"todo-api/config"
+	"todo-api/export"
+	"todo-api/mail"
+	"todo-api/oidc"
 	"todo-api/todo"
 	"todo-api/user"
 	"todo-api/utils"
+	"todo-api/workspace"
 )
 
 const (
@@ -24,6 +31,10 @@ const (
 	idleTimeout     = 5 * time.Second
 	readTimeout     = 5 * time.Second
 	writeTimeout    = 5 * time.Second
+
+	revocationSyncInterval = 5 * time.Second
+	accountPurgeInterval   = time.Minute
+	exportCleanupInterval  = time.Minute
 )
 
 func main() {
@@ -41,7 +52,7 @@ func main() {
 	}
 	log.Printf("config loaded:\n%s\n", appConfig.DebugString())
 
-	db, err := sql.Open("sqlite3", appConfig.SqliteDbPath)
+	db, err := openDb(appConfig.SqliteDbPath)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -50,11 +61,29 @@ func main() {
 		log.Fatal(err)
 	}
 
-	app := setupApp(&appConfig, db)
+	if len(os.Args) > 1 {
+		err = runCommand(db, os.Args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	mailer, err := mail.New(&appConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app := setupApp(&appConfig, db, mailer)
 	startWithGracefulShutdown(app, db, appConfig)
 }
 
-func setupApp(config *config.AppConfig, db *sql.DB) *fiber.App {
+// openDb opens the sqlite database with foreign keys enforced, deleting a user cascades to its data.
+func openDb(path string) (*sql.DB, error) {
+	return sql.Open("sqlite3", path+"?_foreign_keys=on")
+}
+
+func setupApp(config *config.AppConfig, db *sql.DB, mailer mail.Mailer) *fiber.App {
 	app := fiber.New(fiber.Config{
 		IdleTimeout:  idleTimeout,
 		ReadTimeout:  readTimeout,
@@ -63,6 +92,7 @@ func setupApp(config *config.AppConfig, db *sql.DB) *fiber.App {
 	})
 
 	app.Use(recover.New())
+	app.Use(requestid.New())
 	app.Use(logger.New())
 
 	// healthcheck api
@@ -71,14 +101,40 @@ func setupApp(config *config.AppConfig, db *sql.DB) *fiber.App {
 		return true
 	}}))
 
+	utils.SetArgonParams(utils.ArgonParams{Time: config.Argon2Time, Memory: config.Argon2Memory, Threads: config.Argon2Threads})
 	validator := utils.NewValidator()
 	usersStorage := user.NewSqliteUsersStorage(db)
 	todoStorage := todo.NewSqliteStorage(db)
+	workspaceStorage := workspace.NewSqliteStorage(db)
+	exportStorage := export.NewSqliteStorage(db)
 
-	// user register and login api
-	user.SetupRoutes(app, config, usersStorage, validator)
+	keyring, err := user.NewKeyring(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	revocations := user.NewRevocationList(usersStorage, config.AccessTokenTTL, config.JwtLeeway)
+	if err := revocations.Reload(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	go revocations.Run(revocationSyncInterval)
+	go user.RunAccountPurge(usersStorage, config.AccountDeletionGrace, config.AccessTokenTTL, accountPurgeInterval)
+	exporter := export.NewExporter(config, exportStorage, usersStorage, todoStorage, workspaceStorage, mailer)
+	go exporter.RunCleanup(exportCleanupInterval)
+	passwords, err := user.NewPasswordPolicy(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth := user.ValidateAndExtractTokenMiddleware(config, usersStorage, keyring, revocations)
+
+	// user register, login, logout and password api
+	user.SetupRoutes(app, config, usersStorage, keyring, revocations, passwords, oidc.NewProvider(config), auth, mailer, validator)
+	// workspaces, membership and invitations api
+	workspace.SetupRoutes(app, config, auth, workspaceStorage, mailer, validator)
 	//  crud api
-	todo.SetupRoutes(app, config, todoStorage, validator)
+	todo.SetupRoutes(app, config, auth, todoStorage, usersStorage, workspaceStorage, validator)
+
+	// personal data export api
+	export.SetupRoutes(app, config, auth, exportStorage, exporter)
 
 	app.Use(utils.Json404)
 
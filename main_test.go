@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"todo-api/config"
+	"todo-api/mail"
+)
+
+const testPassword = "correct horse battery staple"
+
+// testApp is the whole api on a fresh database, with the mails it sent kept in memory.
+type testApp struct {
+	app    *fiber.App
+	db     *sql.DB
+	mailer *recordingMailer
+	config *config.AppConfig
+}
+
+// newTestApp sets up the api like main does, env holds pairs of environment variables that override the defaults.
+func newTestApp(t *testing.T, env ...string) *testApp {
+	dir := t.TempDir()
+	defaults := []string{
+		"SQLITE_DB_PATH", filepath.Join(dir, "db.sqlite"),
+		"MAIL_DIR", filepath.Join(dir, "mail"),
+		"EXPORT_DIR", filepath.Join(dir, "exports"),
+		"ARGON2_MEMORY", "64",
+		"ARGON2_THREADS", "1",
+		"PASSWORD_MIN_STRENGTH", "0",
+	}
+	env = append(defaults, env...)
+	for i := 0; i+1 < len(env); i += 2 {
+		t.Setenv(env[i], env[i+1])
+	}
+
+	appConfig, err := config.FromEnv()
+	assert.NoError(t, err)
+
+	migrate(t, appConfig.SqliteDbPath)
+	db, err := openDb(appConfig.SqliteDbPath)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	assert.NoError(t, appConfig.Validate())
+
+	mailer := &recordingMailer{}
+	return &testApp{app: setupApp(&appConfig, db, mailer), db: db, mailer: mailer, config: &appConfig}
+}
+
+// migrate applies the up migrations in order, like `migrate up` does, without enforcing foreign keys.
+func migrate(t *testing.T, path string) {
+	db, err := sql.Open("sqlite3", path)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	files, err := filepath.Glob("db_migrations/*.up.sql")
+	assert.NoError(t, err)
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		_, err = db.Exec(string(migration))
+		assert.NoError(t, err, file)
+	}
+}
+
+// do sends a request with a json body and returns the status and the decoded json response.
+// headers holds pairs of header names and values.
+func (a *testApp) do(t *testing.T, method string, path string, body any, headers ...string) (int, map[string]any) {
+	resp := a.send(t, method, path, body, headers...)
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	response := map[string]any{}
+	_ = json.Unmarshal(raw, &response)
+	return resp.StatusCode, response
+}
+
+func (a *testApp) send(t *testing.T, method string, path string, body any, headers ...string) *http.Response {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		assert.NoError(t, err)
+		reader = bytes.NewReader(encoded)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Add(headers[i], headers[i+1])
+	}
+
+	resp, err := a.app.Test(req, 10*time.Second)
+	assert.NoError(t, err)
+	return resp
+}
+
+// register creates a user and returns its access and refresh token.
+func (a *testApp) register(t *testing.T, email string) (string, string) {
+	status, response := a.do(t, "POST", "/register", map[string]string{
+		"email":    email,
+		"password": testPassword,
+		"name":     "Test User",
+	})
+	assert.Equal(t, 200, status, response)
+	token, _ := response["token"].(string)
+	refreshToken, _ := response["refresh_token"].(string)
+	return token, refreshToken
+}
+
+func bearer(token string) []string {
+	return []string{fiber.HeaderAuthorization, "Bearer " + token}
+}
+
+// recordingMailer keeps sent messages for the tests to read links from.
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *recordingMailer) Send(_ context.Context, message mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// last returns the last message sent to the address.
+func (m *recordingMailer) last(to string) (mail.Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i], true
+		}
+	}
+	return mail.Message{}, false
+}
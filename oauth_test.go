@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"todo-api/oidc"
+)
+
+const (
+	oauthRedirectUri  = "https://app.example/callback"
+	oauthCodeVerifier = "a code verifier that is long enough for the challenge"
+)
+
+// registerOauthClient registers a public client with the redirect uris and returns its id.
+func registerOauthClient(t *testing.T, a *testApp, token string, redirectUris ...string) string {
+	status, response := a.do(t, "POST", "/oauth/clients", map[string]any{
+		"name":          "Calendar",
+		"redirect_uris": redirectUris,
+		"scopes":        []string{"todos:read"},
+	}, bearer(token)...)
+	assert.Equal(t, 201, status, response)
+	id, _ := response["client_id"].(string)
+	return id
+}
+
+// authorizationCode approves an authorization request of the client, redirectUri is left out if empty.
+func authorizationCode(t *testing.T, a *testApp, token string, clientId string, redirectUri string) string {
+	request := map[string]any{
+		"response_type":         "code",
+		"client_id":             clientId,
+		"code_challenge":        oidc.CodeChallenge(oauthCodeVerifier),
+		"code_challenge_method": "S256",
+		"approve":               true,
+	}
+	if redirectUri != "" {
+		request["redirect_uri"] = redirectUri
+	}
+	status, response := a.do(t, "POST", "/oauth/consent", request, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+
+	redirectTo, _ := response["redirect_to"].(string)
+	assert.True(t, strings.HasPrefix(redirectTo, oauthRedirectUri+"?"), redirectTo)
+	parsed, err := url.Parse(redirectTo)
+	assert.NoError(t, err)
+	return parsed.Query().Get("code")
+}
+
+func exchangeCode(t *testing.T, a *testApp, form url.Values) (int, map[string]any) {
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	resp, err := a.app.Test(req, 10*time.Second)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	response := map[string]any{}
+	_ = json.Unmarshal(raw, &response)
+	return resp.StatusCode, response
+}
+
+func TestTokenRequestRepeatsTheRedirectUri(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	clientId := registerOauthClient(t, a, token, oauthRedirectUri, "https://app.example/other")
+	form := func(code string) url.Values {
+		return url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {clientId},
+			"code":          {code},
+			"code_verifier": {oauthCodeVerifier},
+		}
+	}
+
+	missing := form(authorizationCode(t, a, token, clientId, oauthRedirectUri))
+	status, response := exchangeCode(t, a, missing)
+	assert.Equal(t, 400, status, "redirect uri left out")
+	assert.Equal(t, "invalid_grant", response["error"])
+
+	other := form(authorizationCode(t, a, token, clientId, oauthRedirectUri))
+	other.Set("redirect_uri", "https://app.example/other")
+	status, response = exchangeCode(t, a, other)
+	assert.Equal(t, 400, status, "another registered redirect uri")
+	assert.Equal(t, "invalid_grant", response["error"])
+
+	same := form(authorizationCode(t, a, token, clientId, oauthRedirectUri))
+	same.Set("redirect_uri", oauthRedirectUri)
+	status, response = exchangeCode(t, a, same)
+	assert.Equal(t, 200, status, response)
+	assert.NotEmpty(t, response["access_token"])
+}
+
+func TestTokenRequestWithoutRedirectUri(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	// a single registered uri can be left out of both requests
+	clientId := registerOauthClient(t, a, token, oauthRedirectUri)
+
+	status, response := exchangeCode(t, a, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {clientId},
+		"code":          {authorizationCode(t, a, token, clientId, "")},
+		"code_verifier": {oauthCodeVerifier},
+	})
+	assert.Equal(t, 200, status, response)
+}
+
+func TestAuthorizationCodeReuseRevokesTheTokens(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	clientId := registerOauthClient(t, a, token, oauthRedirectUri)
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {clientId},
+		"code":          {authorizationCode(t, a, token, clientId, "")},
+		"code_verifier": {oauthCodeVerifier},
+	}
+
+	status, response := exchangeCode(t, a, form)
+	assert.Equal(t, 200, status, response)
+	accessToken, _ := response["access_token"].(string)
+	refreshToken, _ := response["refresh_token"].(string)
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(accessToken)...)
+	assert.Equal(t, 200, status)
+
+	// the code was intercepted and is exchanged again
+	status, response = exchangeCode(t, a, form)
+	assert.Equal(t, 400, status, "reused code")
+	assert.Equal(t, "invalid_grant", response["error"])
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(accessToken)...)
+	assert.Equal(t, 401, status, "access token issued for the code")
+	status, _ = exchangeCode(t, a, url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {clientId},
+		"refresh_token": {refreshToken},
+	})
+	assert.Equal(t, 400, status, "refresh token issued for the code")
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(token)...)
+	assert.Equal(t, 200, status, "the session of the user is not affected")
+}
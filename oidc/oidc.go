@@ -0,0 +1,227 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	jwtware "jwt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"todo-api/config"
+)
+
+const (
+	// httpTimeout bounds every request to the provider.
+	httpTimeout = 10 * time.Second
+	// clockSkew is the difference between our clock and the clock of the provider tolerated in ID tokens.
+	clockSkew = time.Minute
+)
+
+var (
+	// idTokenMethods are the signing algorithms accepted in ID tokens, "none" and the HMAC ones are never accepted.
+	idTokenMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+	InvalidIdToken = errors.New("invalid id token")
+)
+
+// Metadata is the part of the discovery document of the provider we use,
+// see OpenID Connect Discovery 1.0.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// Claims are the claims of a verified ID token.
+type Claims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	AuthorizedBy  string `json:"azp"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// Provider is an OpenID Connect provider users log in with, using the authorization code flow with PKCE.
+// The discovery document and the keys are fetched on the first use, so the provider can be down at startup.
+type Provider struct {
+	issuer       string
+	clientId     string
+	clientSecret string
+	redirectUrl  string
+	scopes       []string
+	client       *http.Client
+
+	mu       sync.Mutex
+	metadata *Metadata
+	keyFunc  jwt.Keyfunc
+}
+
+// NewProvider returns the provider of the config, or nil if OpenID Connect login is not configured.
+func NewProvider(config *config.AppConfig) *Provider {
+	if config.OidcIssuer == "" {
+		return nil
+	}
+	return &Provider{
+		issuer:       config.OidcIssuer,
+		clientId:     config.OidcClientId,
+		clientSecret: config.OidcClientSecret,
+		redirectUrl:  config.PublicUrl + "/auth/oidc/callback",
+		scopes:       strings.Fields(config.OidcScopes),
+		client:       &http.Client{Timeout: httpTimeout},
+	}
+}
+
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+// discover fetches the discovery document and the keys of the provider once.
+// A failure is not cached, the next call tries again.
+func (p *Provider) discover(ctx context.Context) (*Metadata, jwt.Keyfunc, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, p.keyFunc, nil
+	}
+
+	// the issuer is compared as is, a trailing slash is only left out of the discovery url
+	discoveryUrl := strings.TrimSuffix(p.issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryUrl, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var metadata Metadata
+	if err = p.do(req, &metadata); err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if metadata.Issuer != p.issuer {
+		return nil, nil, fmt.Errorf("oidc discovery: issuer %s does not match %s", metadata.Issuer, p.issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JwksUri == "" {
+		return nil, nil, errors.New("oidc discovery: the provider metadata is incomplete")
+	}
+
+	keyFunc, err := jwtware.KeyFunc(jwtware.Config{JWKSetURLs: []string{metadata.JwksUri}})
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc keys: %w", err)
+	}
+
+	p.metadata, p.keyFunc = &metadata, keyFunc
+	return p.metadata, p.keyFunc, nil
+}
+
+// AuthorizationUrl returns where to send the user to log in at the provider.
+func (p *Provider) AuthorizationUrl(ctx context.Context, state string, nonce string, codeVerifier string) (string, error) {
+	metadata, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.clientId)
+	query.Set("redirect_uri", p.redirectUrl)
+	query.Set("scope", strings.Join(p.scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", CodeChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code and returns the claims of the verified ID token.
+func (p *Provider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (Claims, error) {
+	metadata, keyFunc, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectUrl)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", p.clientId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientId), url.QueryEscape(p.clientSecret))
+	}
+
+	var response struct {
+		IdToken string `json:"id_token"`
+	}
+	if err = p.do(req, &response); err != nil {
+		return Claims{}, fmt.Errorf("oidc token: %w", err)
+	}
+
+	return p.verify(response.IdToken, keyFunc, nonce)
+}
+
+// verify checks the ID token as required by OpenID Connect Core 1.0, section 3.1.3.7.
+func (p *Provider) verify(idToken string, keyFunc jwt.Keyfunc, nonce string) (Claims, error) {
+	claims := Claims{}
+	_, err := jwt.ParseWithClaims(idToken, &claims, keyFunc,
+		jwt.WithValidMethods(idTokenMethods),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.clientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", InvalidIdToken, err)
+	}
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: no subject", InvalidIdToken)
+	}
+	if claims.Nonce != nonce {
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", InvalidIdToken)
+	}
+	if (len(claims.Audience) > 1 || claims.AuthorizedBy != "") && claims.AuthorizedBy != p.clientId {
+		return Claims{}, fmt.Errorf("%w: authorized party mismatch", InvalidIdToken)
+	}
+	return claims, nil
+}
+
+func (p *Provider) do(req *http.Request, response any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %d: %s", req.URL.Host, resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, response)
+}
+
+// CodeChallenge derives the S256 PKCE challenge from the code verifier, see RFC 7636.
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
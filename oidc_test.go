@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+	"todo-api/oidc"
+	"todo-api/utils"
+)
+
+const oidcClientId = "todo-api"
+
+// mockProvider is an OpenID Connect provider with discovery, keys and a token endpoint.
+// Logins at it are simulated by authorize, which hands out the code the browser would bring back.
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	logins map[string]mockLogin
+}
+
+type mockLogin struct {
+	challenge   string
+	redirectUri string
+	claims      jwt.MapClaims
+}
+
+func startMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	p := &mockProvider{key: key, logins: map[string]mockLogin{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, 200, map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, 200, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// token redeems a code once, if the verifier matches the challenge and the redirect uri the one of the authorization.
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	login, ok := p.logins[r.FormValue("code")]
+	delete(p.logins, r.FormValue("code"))
+	p.mu.Unlock()
+
+	if !ok || r.FormValue("client_id") != oidcClientId || r.FormValue("redirect_uri") != login.redirectUri ||
+		oidc.CodeChallenge(r.FormValue("code_verifier")) != login.challenge {
+		writeJson(w, 400, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, login.claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		writeJson(w, 500, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJson(w, 200, map[string]string{"access_token": "unused", "token_type": "Bearer", "id_token": idToken})
+}
+
+// authorize logs the user of the claims in at the provider, like the browser would after following authorizationUrl,
+// and returns the query of the callback. The nonce of the authorization is added to the claims unless they have one.
+func (p *mockProvider) authorize(t *testing.T, authorizationUrl string, claims jwt.MapClaims) url.Values {
+	parsed, err := url.Parse(authorizationUrl)
+	assert.NoError(t, err)
+	query := parsed.Query()
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	now := time.Now()
+	defaults := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   oidcClientId,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Minute).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range defaults {
+		if _, ok := claims[name]; !ok {
+			claims[name] = value
+		}
+	}
+
+	code, err := utils.NewRandomToken()
+	assert.NoError(t, err)
+	p.mu.Lock()
+	p.logins[code] = mockLogin{challenge: query.Get("code_challenge"), redirectUri: query.Get("redirect_uri"), claims: claims}
+	p.mu.Unlock()
+
+	return url.Values{"code": {code}, "state": {query.Get("state")}}
+}
+
+func newOidcTestApp(t *testing.T) (*testApp, *mockProvider) {
+	p := startMockProvider(t)
+	return newTestApp(t, "OIDC_ISSUER", p.server.URL, "OIDC_CLIENT_ID", oidcClientId), p
+}
+
+// startLogin opens the login in the browser and returns the url of the provider with the state cookie.
+func startLogin(t *testing.T, a *testApp) (string, string) {
+	resp := a.send(t, "GET", "/auth/oidc/login", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, 302, resp.StatusCode)
+	return resp.Header.Get("Location"), stateCookie(t, resp)
+}
+
+func stateCookie(t *testing.T, resp *http.Response) string {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "oidc_state" {
+			assert.True(t, cookie.HttpOnly)
+			assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+			return cookie.Name + "=" + cookie.Value
+		}
+	}
+	t.Fatal("no oidc_state cookie")
+	return ""
+}
+
+func callback(t *testing.T, a *testApp, query url.Values, cookie string) (int, map[string]any) {
+	var headers []string
+	if cookie != "" {
+		headers = []string{"Cookie", cookie}
+	}
+	return a.do(t, "GET", "/auth/oidc/callback?"+query.Encode(), nil, headers...)
+}
+
+// oidcLogin logs in through the provider as the user of the claims.
+func oidcLogin(t *testing.T, a *testApp, p *mockProvider, claims jwt.MapClaims) (int, map[string]any) {
+	authorizationUrl, cookie := startLogin(t, a)
+	return callback(t, a, p.authorize(t, authorizationUrl, claims), cookie)
+}
+
+func TestOidcProvisioning(t *testing.T) {
+	a, p := newOidcTestApp(t)
+
+	status, response := oidcLogin(t, a, p, jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true, "name": "Alice"})
+	assert.Equal(t, 200, status, response)
+	token, _ := response["token"].(string)
+	status, me := a.do(t, "GET", "/me", nil, bearer(token)...)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "alice@example.com", me["email"])
+
+	status, response = oidcLogin(t, a, p, jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true})
+	assert.Equal(t, 200, status, response)
+	token, _ = response["token"].(string)
+	assert.Equal(t, me["id"], userId(t, a, token), "the second login finds the provisioned user")
+
+	status, _ = oidcLogin(t, a, p, jwt.MapClaims{"sub": "mallory", "email": "bob@example.com", "email_verified": false})
+	assert.Equal(t, 403, status, "unverified email")
+
+	a.register(t, "carol@example.com")
+	status, _ = oidcLogin(t, a, p, jwt.MapClaims{"sub": "carol", "email": "carol@example.com", "email_verified": true})
+	assert.Equal(t, 409, status, "existing account with the email")
+}
+
+func TestOidcStateIsBoundToTheBrowser(t *testing.T) {
+	a, p := newOidcTestApp(t)
+	claims := jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+	authorizationUrl, cookie := startLogin(t, a)
+	query := p.authorize(t, authorizationUrl, claims)
+
+	status, _ := callback(t, a, query, "")
+	assert.Equal(t, 400, status, "callback without the cookie")
+	_, otherCookie := startLogin(t, a)
+	status, _ = callback(t, a, query, otherCookie)
+	assert.Equal(t, 400, status, "cookie of another login")
+
+	status, response := callback(t, a, query, cookie)
+	assert.Equal(t, 200, status, response)
+	status, _ = callback(t, a, query, cookie)
+	assert.Equal(t, 400, status, "replayed state")
+}
+
+func TestOidcPkceAndNonce(t *testing.T) {
+	a, p := newOidcTestApp(t)
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+	}
+
+	// the provider only redeems the code for the verifier of the challenge
+	authorizationUrl, cookie := startLogin(t, a)
+	query := p.authorize(t, authorizationUrl, claims())
+	p.mu.Lock()
+	login := p.logins[query.Get("code")]
+	login.challenge = oidc.CodeChallenge("another verifier")
+	p.logins[query.Get("code")] = login
+	p.mu.Unlock()
+	status, _ := callback(t, a, query, cookie)
+	assert.Equal(t, 502, status, "verifier of another challenge")
+
+	wrongNonce := claims()
+	wrongNonce["nonce"] = "replayed"
+	status, _ = oidcLogin(t, a, p, wrongNonce)
+	assert.Equal(t, 401, status, "id token of another login")
+
+	otherAudience := claims()
+	otherAudience["aud"] = "another-client"
+	status, _ = oidcLogin(t, a, p, otherAudience)
+	assert.Equal(t, 401, status, "id token of another client")
+
+	status, response := oidcLogin(t, a, p, claims())
+	assert.Equal(t, 200, status, response)
+}
+
+func TestOidcIssuerIsComparedExactly(t *testing.T) {
+	p := startMockProvider(t)
+	a := newTestApp(t, "OIDC_ISSUER", p.server.URL+"/", "OIDC_CLIENT_ID", oidcClientId)
+
+	status, _ := a.do(t, "GET", "/auth/oidc/login", nil)
+	assert.Equal(t, 502, status)
+}
+
+func TestOidcLinkIdentity(t *testing.T) {
+	a, p := newOidcTestApp(t)
+	token, _ := a.register(t, "alice@example.com")
+
+	resp := a.send(t, "POST", "/me/identities", nil, bearer(token)...)
+	defer resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	var link struct {
+		AuthorizationUrl string `json:"authorization_url"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&link))
+	cookie := stateCookie(t, resp)
+
+	query := p.authorize(t, link.AuthorizationUrl, jwt.MapClaims{"sub": "alice", "email": "alice@work.example.com", "email_verified": true})
+	status, _ := callback(t, a, query, "")
+	assert.Equal(t, 400, status, "link completed in another browser")
+	status, response := callback(t, a, query, cookie)
+	assert.Equal(t, 200, status, response)
+	assert.Equal(t, "alice", response["subject"])
+
+	status, response = oidcLogin(t, a, p, jwt.MapClaims{"sub": "alice", "email": "alice@work.example.com", "email_verified": true})
+	assert.Equal(t, 200, status, response)
+	linked, _ := response["token"].(string)
+	assert.Equal(t, userId(t, a, token), userId(t, a, linked), "the login finds the linked user")
+}
+
+func TestOidcUserDeletesTheAccount(t *testing.T) {
+	a, p := newOidcTestApp(t)
+	status, response := oidcLogin(t, a, p, jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true})
+	assert.Equal(t, 200, status, response)
+	token, _ := response["token"].(string)
+
+	// the user has no password, the login just now confirms the deletion
+	status, response = a.do(t, "DELETE", "/me", map[string]string{}, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+}
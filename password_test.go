@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const newPassword = "another correct horse battery staple"
+
+// resetToken asks for a password reset and returns the token of the mail, which is sent in the background.
+func resetToken(t *testing.T, a *testApp, email string) string {
+	previous, _ := a.mailer.last(email)
+	status, _ := a.do(t, "POST", "/password/forgot", map[string]string{"email": email})
+	assert.Equal(t, 200, status)
+	assert.Eventually(t, func() bool {
+		message, ok := a.mailer.last(email)
+		return ok && message != previous
+	}, 5*time.Second, 10*time.Millisecond, "reset mail")
+	return mailToken(t, a, email)
+}
+
+func resetPassword(t *testing.T, a *testApp, token string) int {
+	status, _ := a.do(t, "POST", "/password/reset", map[string]string{"token": token, "new_password": newPassword})
+	return status
+}
+
+func TestPasswordReset(t *testing.T) {
+	a := newTestApp(t)
+	token, refreshToken := a.register(t, "user@example.com")
+	other, _ := login(t, a, "user@example.com", "phone")
+	reset := resetToken(t, a, "user@example.com")
+
+	assert.Equal(t, 200, resetPassword(t, a, reset))
+	assert.Equal(t, 400, resetPassword(t, a, reset), "token used twice")
+
+	status, _ := a.do(t, "GET", "/todos", nil, bearer(token)...)
+	assert.Equal(t, 401, status, "access token issued before the reset")
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(other)...)
+	assert.Equal(t, 401, status, "access token of another session")
+	status, _ = refresh(t, a, refreshToken)
+	assert.Equal(t, 401, status, "refresh token issued before the reset")
+
+	status, _ = a.do(t, "POST", "/login", map[string]string{"email": "user@example.com", "password": testPassword})
+	assert.NotEqual(t, 200, status, "login with the old password")
+	status, _ = a.do(t, "POST", "/login", map[string]string{"email": "user@example.com", "password": newPassword})
+	assert.Equal(t, 200, status, "login with the new password")
+}
+
+func TestPasswordResetExpires(t *testing.T) {
+	a := newTestApp(t)
+	a.register(t, "user@example.com")
+	reset := resetToken(t, a, "user@example.com")
+	_, err := a.db.Exec("UPDATE password_resets SET expires_at=?", time.Now().UTC().Add(-time.Minute))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 400, resetPassword(t, a, reset), "expired token")
+	assert.Equal(t, 400, resetPassword(t, a, "unknown"), "unknown token")
+	status, _ := a.do(t, "POST", "/login", map[string]string{"email": "user@example.com", "password": testPassword})
+	assert.Equal(t, 200, status, "the password is unchanged")
+}
+
+func TestForgotPasswordOfUnknownEmail(t *testing.T) {
+	a := newTestApp(t)
+	status, _ := a.do(t, "POST", "/password/forgot", map[string]string{"email": "nobody@example.com"})
+	assert.Equal(t, 200, status, "same answer as for a registered email")
+	_, sent := a.mailer.last("nobody@example.com")
+	assert.False(t, sent)
+}
+
+func TestChangePasswordIsThrottled(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	change := func(current string) int {
+		status, _ := a.do(t, "POST", "/me/password", map[string]string{"current_password": current, "new_password": newPassword}, bearer(token)...)
+		return status
+	}
+
+	for range 3 {
+		assert.Equal(t, 400, change("wrong password"))
+	}
+	assert.Equal(t, 429, change(testPassword), "guesses with a stolen access token are slowed down like logins")
+	status, _ := a.do(t, "POST", "/login", map[string]string{"email": "user@example.com", "password": testPassword})
+	assert.Equal(t, 429, status, "the same account counter")
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestChangeEmailCase(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+
+	status, _ := a.do(t, "PATCH", "/me", map[string]string{"email": "User@Example.com"}, bearer(token)...)
+	assert.Equal(t, 400, status, "email change without the password")
+
+	status, response := a.do(t, "PATCH", "/me", map[string]string{"email": "User@Example.com", "password": testPassword}, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	assert.Equal(t, "User@Example.com", response["email"])
+
+	status, _ = a.do(t, "POST", "/login", map[string]string{"email": "User@Example.com", "password": testPassword})
+	assert.Equal(t, 200, status, "login with the new email")
+}
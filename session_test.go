@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// login signs in from a named device and returns its access and refresh token.
+func login(t *testing.T, a *testApp, email string, device string) (string, string) {
+	status, response := a.do(t, "POST", "/login", map[string]string{"email": email, "password": testPassword, "device_name": device})
+	assert.Equal(t, 200, status, response)
+	token, _ := response["token"].(string)
+	refreshToken, _ := response["refresh_token"].(string)
+	return token, refreshToken
+}
+
+// sessions returns the sessions of the user by device name.
+func sessions(t *testing.T, a *testApp, token string) map[string]map[string]any {
+	status, response := a.do(t, "GET", "/sessions", nil, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	byDevice := map[string]map[string]any{}
+	data, _ := response["data"].([]any)
+	for _, session := range data {
+		session := session.(map[string]any)
+		byDevice[session["device_name"].(string)] = session
+	}
+	return byDevice
+}
+
+func TestListSessions(t *testing.T) {
+	a := newTestApp(t)
+	a.register(t, "user@example.com")
+	laptop, _ := login(t, a, "user@example.com", "laptop")
+	login(t, a, "user@example.com", "phone")
+	other, _ := a.register(t, "other@example.com")
+
+	listed := sessions(t, a, laptop)
+	assert.Len(t, listed, 3, "the registration and both logins")
+	assert.Equal(t, true, listed["laptop"]["current"])
+	assert.Equal(t, false, listed["phone"]["current"])
+	assert.Len(t, sessions(t, a, other), 1, "only the sessions of the user")
+}
+
+func TestRevokeAnotherSession(t *testing.T) {
+	a := newTestApp(t)
+	a.register(t, "user@example.com")
+	laptop, _ := login(t, a, "user@example.com", "laptop")
+	phone, phoneRefresh := login(t, a, "user@example.com", "phone")
+	other, _ := a.register(t, "other@example.com")
+	phoneSession, _ := sessions(t, a, laptop)["phone"]["id"].(string)
+
+	status, _ := a.do(t, "DELETE", "/sessions/"+phoneSession, nil, bearer(other)...)
+	assert.Equal(t, 404, status, "session of another user")
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(phone)...)
+	assert.Equal(t, 200, status)
+
+	status, response := a.do(t, "DELETE", "/sessions/"+phoneSession, nil, bearer(laptop)...)
+	assert.Equal(t, 200, status, response)
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(phone)...)
+	assert.Equal(t, 401, status, "access token of the revoked session")
+	status, _ = refresh(t, a, phoneRefresh)
+	assert.Equal(t, 401, status, "refresh token of the revoked session")
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(laptop)...)
+	assert.Equal(t, 200, status, "the current session is kept")
+	assert.NotContains(t, sessions(t, a, laptop), "phone")
+
+	status, _ = a.do(t, "DELETE", "/sessions/"+phoneSession, nil, bearer(laptop)...)
+	assert.Equal(t, 404, status, "session revoked twice")
+}
+
+// createAccessToken creates a personal access token with the scopes and returns it and its id.
+func createAccessToken(t *testing.T, a *testApp, token string, scopes ...string) (string, string) {
+	status, response := a.do(t, "POST", "/tokens", map[string]any{"name": "script", "scopes": scopes}, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	pat, _ := response["token"].(string)
+	id, _ := response["id"].(string)
+	return pat, id
+}
+
+func TestSessionEndpointsRejectAccessTokens(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	pat, _ := createAccessToken(t, a, token, "todos:read", "todos:write", "workspaces:read", "workspaces:write")
+
+	status, _ := a.do(t, "GET", "/sessions", nil, bearer(pat)...)
+	assert.Equal(t, 403, status, "list sessions")
+	status, _ = a.do(t, "POST", "/tokens", map[string]any{"name": "more", "scopes": []string{"todos:read"}}, bearer(pat)...)
+	assert.Equal(t, 403, status, "create another token")
+	status, _ = a.do(t, "POST", "/me/password", map[string]string{"current_password": testPassword, "new_password": "another long password"}, bearer(pat)...)
+	assert.Equal(t, 403, status, "change the password")
+	status, _ = a.do(t, "GET", "/me", nil, bearer(pat)...)
+	assert.Equal(t, 200, status, "endpoints without RequireSession")
+}
@@ -0,0 +1,186 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/oklog/ulid/v2"
+	"time"
+	"todo-api/user"
+	"todo-api/workspace"
+)
+
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionShare   = "share"
+	ActionUnshare = "unshare"
+	ActionAssign  = "assign"
+	ActionRestore = "restore"
+)
+
+const defaultEventsLimit = 20
+
+// Change holds the value of a single field before and after a mutation.
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+type Event struct {
+	Id        string            `json:"id"`
+	TodoId    Id                `json:"todo_id"`
+	ActorId   user.Id           `json:"actor_id"`
+	Action    string            `json:"action"`
+	Changes   map[string]Change `json:"changes"`
+	RequestId string            `json:"request_id,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// EventMeta describes who is mutating todos and in which request.
+// It is passed to the storage through the context so that events are written in the same transaction as the mutation.
+type EventMeta struct {
+	ActorId   user.Id
+	RequestId string
+}
+
+type eventMetaKey struct{}
+
+func WithEventMeta(ctx context.Context, meta EventMeta) context.Context {
+	return context.WithValue(ctx, eventMetaKey{}, meta)
+}
+
+func eventMetaFromContext(ctx context.Context) EventMeta {
+	meta, _ := ctx.Value(eventMetaKey{}).(EventMeta)
+	return meta
+}
+
+// ActivityOptions selects a page of events. Events are returned newest first,
+// Cursor is the id of the last event of the previous page.
+type ActivityOptions struct {
+	Cursor        string
+	Limit         uint
+	WorkspaceId   workspace.Id
+	WorkspaceWide bool
+}
+
+func (o *ActivityOptions) limit() uint {
+	if o.Limit == 0 {
+		return defaultEventsLimit
+	}
+	return o.Limit
+}
+
+// diffTodos returns the fields that differ between two versions of a todo.
+// A zero todo stands for a todo that does not exist.
+func diffTodos(before, after Todo) map[string]Change {
+	fields := []struct {
+		name          string
+		before, after any
+	}{
+		{TitleName, fieldValue(before, before.Title), fieldValue(after, after.Title)},
+		{DescriptionName, fieldValue(before, before.Description), fieldValue(after, after.Description)},
+		{"assignee_id", nullableUserId(before.AssigneeId), nullableUserId(after.AssigneeId)},
+		{"workspace_id", nullableWorkspaceId(before.WorkspaceId), nullableWorkspaceId(after.WorkspaceId)},
+	}
+
+	changes := map[string]Change{}
+	for _, field := range fields {
+		if field.before != field.after {
+			changes[field.name] = Change{Before: field.before, After: field.after}
+		}
+	}
+	return changes
+}
+
+func fieldValue(todo Todo, value string) any {
+	if todo.Invalid() {
+		return nil
+	}
+	return value
+}
+
+func insertEvent(ctx context.Context, tx *sql.Tx, todoId Id, action string, changes map[string]Change) error {
+	meta := eventMetaFromContext(ctx)
+	if meta.ActorId == "" {
+		return fmt.Errorf("todo %s: missing actor for %s event", todoId, action)
+	}
+
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO todo_events (id, todo_id, actor_id, action, changes, request_id) VALUES (?, ?, ?, ?, ?, ?)",
+		ulid.Make().String(), todoId, meta.ActorId, action, string(encoded), meta.RequestId)
+	return err
+}
+
+func (s SqliteStorage) GetEventsByTodoId(ctx context.Context, id Id, options ActivityOptions) ([]Event, error) {
+	where := "todo_id=?"
+	args := []any{id}
+	return s.queryEvents(ctx, where, args, options)
+}
+
+// GetActivity returns events caused by the user and events on todos the user can currently see.
+func (s SqliteStorage) GetActivity(ctx context.Context, userId user.Id, options ActivityOptions) ([]Event, error) {
+	whereVisible, args := visibilityFilter(userId, FindOptions{
+		Owner:         OwnerAny,
+		WorkspaceId:   options.WorkspaceId,
+		WorkspaceWide: options.WorkspaceWide,
+	})
+
+	where := "todo_id IN (SELECT id FROM todos WHERE " + whereVisible + ")"
+	if options.WorkspaceId == "" {
+		where = "(actor_id=? OR " + where + " OR todo_id IN (" +
+			"SELECT t.id FROM todos t JOIN workspace_members m ON m.workspace_id = t.workspace_id " +
+			"WHERE m.user_id=? AND m.role<>?))"
+		args = append([]any{userId}, append(args, userId, workspace.RoleGuest)...)
+	}
+	return s.queryEvents(ctx, where, args, options)
+}
+
+func (s SqliteStorage) queryEvents(ctx context.Context, where string, args []any, options ActivityOptions) ([]Event, error) {
+	if options.Cursor != "" {
+		where += " AND id<?"
+		args = append(args, options.Cursor)
+	}
+	args = append(args, options.limit())
+
+	stmt, err := s.db.PrepareContext(ctx, `
+		SELECT id, todo_id, actor_id, action, changes, request_id, created_at
+		FROM todo_events WHERE `+where+`
+		ORDER BY id DESC
+		LIMIT ?
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var event Event
+		var changes string
+		var requestId sql.NullString
+		err = rows.Scan(&event.Id, &event.TodoId, &event.ActorId, &event.Action, &changes, &requestId, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(changes), &event.Changes); err != nil {
+			return nil, err
+		}
+		event.RequestId = requestId.String
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
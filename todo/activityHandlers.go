@@ -0,0 +1,84 @@
+package todo
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"todo-api/user"
+	"todo-api/utils"
+	"todo-api/workspace"
+)
+
+type activityRequest struct {
+	Cursor string `query:"cursor" validate:"omitempty,ulid"`
+	Limit  uint   `query:"limit" validate:"gt=0,lte=100"`
+}
+
+type activityResponse struct {
+	Data       []Event `json:"data"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+func newActivityResponse(events []Event, limit uint) activityResponse {
+	response := activityResponse{Data: events}
+	if response.Data == nil {
+		response.Data = []Event{}
+	}
+	if uint(len(events)) == limit {
+		response.NextCursor = events[len(events)-1].Id
+	}
+	return response
+}
+
+func HistoryHandler(storage Storage, permissions *PermissionService, validator *utils.AppValidator) fiber.Handler {
+	return func(ctx fiber.Ctx) error {
+		todoId := Id(ctx.Params("id", ""))
+
+		req := activityRequest{Limit: defaultEventsLimit}
+		err := ctx.Bind().Query(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err = validator.Validate(req); err != nil {
+			return err
+		}
+
+		_, err = permissions.Require(ctx, todoId, RoleViewer)
+		if err != nil {
+			return err
+		}
+
+		events, err := storage.GetEventsByTodoId(ctx.Context(), todoId, ActivityOptions{Cursor: req.Cursor, Limit: req.Limit})
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.JSON(newActivityResponse(events, req.Limit))
+	}
+}
+
+func ActivityHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
+	return func(ctx fiber.Ctx) error {
+		u := user.FromContext(ctx)
+
+		req := activityRequest{Limit: defaultEventsLimit}
+		err := ctx.Bind().Query(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err = validator.Validate(req); err != nil {
+			return err
+		}
+
+		options := ActivityOptions{Cursor: req.Cursor, Limit: req.Limit}
+		if member := workspace.FromContext(ctx); member != nil {
+			options.WorkspaceId = member.WorkspaceId
+			options.WorkspaceWide = member.Role.Includes(workspace.RoleMember)
+		}
+
+		events, err := storage.GetActivity(ctx.Context(), u.Id, options)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.JSON(newActivityResponse(events, req.Limit))
+	}
+}
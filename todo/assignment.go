@@ -0,0 +1,97 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"time"
+	"todo-api/user"
+)
+
+type Assignment struct {
+	Id         string    `json:"id"`
+	TodoId     Id        `json:"todo_id"`
+	AssigneeId user.Id   `json:"assignee_id"`
+	AssignedBy user.Id   `json:"assigned_by"`
+	AssignedAt time.Time `json:"assigned_at"`
+}
+
+// Assign sets the assignee of the todo and records the change in the assignment history.
+// An empty assigneeId removes the current assignee.
+func (s SqliteStorage) Assign(ctx context.Context, id Id, assigneeId user.Id, assignedBy user.Id) (Todo, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Todo{}, err
+	}
+	defer tx.Rollback()
+
+	before, err := scanTodo(tx.QueryRowContext(ctx, "SELECT "+todoColumns+" FROM todos WHERE id=?", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Todo{}, nil
+		}
+		return Todo{}, err
+	}
+
+	todo, err := scanTodo(tx.QueryRowContext(ctx, `
+		UPDATE todos
+		SET assignee_id=?,assigned_at=CASE WHEN ? IS NULL THEN NULL ELSE CURRENT_TIMESTAMP END
+		WHERE id=?
+		RETURNING `+todoColumns, nullableUserId(assigneeId), nullableUserId(assigneeId), id))
+	if err != nil {
+		return Todo{}, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO todo_assignments (id, todo_id, assignee_id, assigned_by) VALUES (?, ?, ?, ?)",
+		ulid.Make().String(), id, nullableUserId(assigneeId), assignedBy)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	err = insertEvent(ctx, tx, id, ActionAssign, diffTodos(before, todo))
+	if err != nil {
+		return Todo{}, err
+	}
+
+	return todo, tx.Commit()
+}
+
+func (s SqliteStorage) GetAssignments(ctx context.Context, id Id) ([]Assignment, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		SELECT id, todo_id, assignee_id, assigned_by, assigned_at
+		FROM todo_assignments WHERE todo_id=?
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assignments []Assignment
+	for rows.Next() {
+		var assignment Assignment
+		var assigneeId sql.NullString
+		err = rows.Scan(&assignment.Id, &assignment.TodoId, &assigneeId, &assignment.AssignedBy, &assignment.AssignedAt)
+		if err != nil {
+			return nil, err
+		}
+		assignment.AssigneeId = user.Id(assigneeId.String)
+		assignments = append(assignments, assignment)
+	}
+	return assignments, rows.Err()
+}
+
+func nullableUserId(id user.Id) any {
+	if id == "" {
+		return nil
+	}
+	return id
+}
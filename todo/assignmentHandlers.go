@@ -0,0 +1,85 @@
+package todo
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"todo-api/user"
+	"todo-api/utils"
+)
+
+func AssignHandler(storage Storage, usersStorage user.Storage, permissions *PermissionService, validator *utils.AppValidator) fiber.Handler {
+	type AssignRequest struct {
+		AssigneeId user.Id `json:"assignee_id" validate:"omitempty,ulid"`
+	}
+
+	type AssignResponse dto
+
+	return func(ctx fiber.Ctx) error {
+		u := user.FromContext(ctx)
+		todoId := Id(ctx.Params("id", ""))
+
+		req := AssignRequest{}
+		err := ctx.Bind().Body(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err = validator.Validate(req); err != nil {
+			return err
+		}
+
+		todo, err := permissions.Require(ctx, todoId, RoleEditor)
+		if err != nil {
+			return err
+		}
+
+		if req.AssigneeId != "" {
+			assignee, err := usersStorage.GetById(ctx.Context(), req.AssigneeId)
+			if err != nil {
+				if errors.Is(err, user.NotFound) {
+					return fiber.NewError(fiber.StatusBadRequest, err.Error())
+				}
+				return fiber.ErrInternalServerError
+			}
+
+			role, err := permissions.Role(ctx.Context(), assignee.Id, todo)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			if !role.Valid() {
+				return fiber.NewError(fiber.StatusBadRequest, "assignee has no access to the todo")
+			}
+		}
+
+		todo, err = storage.Assign(mutationContext(ctx), todoId, req.AssigneeId, u.Id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.JSON(AssignResponse(toDto(todo)))
+	}
+}
+
+func GetAssignmentsHandler(storage Storage, permissions *PermissionService) fiber.Handler {
+	type GetAssignmentsResponse struct {
+		Data []Assignment `json:"data"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		todoId := Id(ctx.Params("id", ""))
+
+		_, err := permissions.Require(ctx, todoId, RoleViewer)
+		if err != nil {
+			return err
+		}
+
+		assignments, err := storage.GetAssignments(ctx.Context(), todoId)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if assignments == nil {
+			assignments = []Assignment{}
+		}
+
+		return ctx.JSON(GetAssignmentsResponse{Data: assignments})
+	}
+}
@@ -0,0 +1,125 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"todo-api/user"
+	"todo-api/workspace"
+)
+
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleOwner  Role = "owner"
+)
+
+var roleLevels = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+func (r Role) Valid() bool {
+	return roleLevels[r] > 0
+}
+
+// Includes reports whether r grants at least the access of required.
+func (r Role) Includes(required Role) bool {
+	return r.Valid() && roleLevels[r] >= roleLevels[required]
+}
+
+// PermissionService resolves the role a user has on a todo,
+// either as its creator, through a share or through the membership in the todo workspace.
+type PermissionService struct {
+	storage    Storage
+	workspaces workspace.Storage
+}
+
+func NewPermissionService(storage Storage, workspaces workspace.Storage) *PermissionService {
+	return &PermissionService{storage: storage, workspaces: workspaces}
+}
+
+// workspaceRoles maps workspace roles to the role they grant on every todo of the workspace.
+// Guests only get access to todos that are explicitly shared with them.
+var workspaceRoles = map[workspace.Role]Role{
+	workspace.RoleOwner:  RoleOwner,
+	workspace.RoleAdmin:  RoleOwner,
+	workspace.RoleMember: RoleEditor,
+}
+
+// Role returns the role of the user on the todo or an empty role if the user has no access.
+func (p *PermissionService) Role(ctx context.Context, userId user.Id, todo Todo) (Role, error) {
+	if todo.UserId == userId {
+		return RoleOwner, nil
+	}
+
+	role, err := p.storage.GetShareRole(ctx, todo.Id, userId)
+	if err != nil {
+		return "", err
+	}
+
+	if todo.WorkspaceId != "" {
+		member, err := p.workspaces.GetMember(ctx, todo.WorkspaceId, userId)
+		if err != nil && !errors.Is(err, workspace.NotMember) {
+			return "", err
+		}
+		if memberRole, ok := workspaceRoles[member.Role]; ok && !role.Includes(memberRole) {
+			role = memberRole
+		}
+	}
+
+	return role, nil
+}
+
+// Require loads the todo and checks that the current user has at least the required role on it.
+func (p *PermissionService) Require(ctx fiber.Ctx, todoId Id, required Role) (Todo, error) {
+	u := user.FromContext(ctx)
+	todo, err := p.storage.GetById(ctx.Context(), todoId)
+	if err != nil {
+		return Todo{}, fiber.ErrInternalServerError
+	}
+	if todo.Invalid() {
+		return Todo{}, fiber.ErrForbidden
+	}
+
+	role, err := p.Role(ctx.Context(), u.Id, todo)
+	if err != nil {
+		return Todo{}, fiber.ErrInternalServerError
+	}
+	if !role.Includes(required) {
+		return Todo{}, fiber.ErrForbidden
+	}
+	return todo, nil
+}
+
+// RequireRevision checks that the current user has at least the required role on the todo of the revision.
+// A deleted todo is checked against its snapshot and the shares it had when it was deleted.
+func (p *PermissionService) RequireRevision(ctx fiber.Ctx, revision Revision, required Role) error {
+	u := user.FromContext(ctx)
+	todo, err := p.storage.GetById(ctx.Context(), revision.TodoId)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	var sharedRole Role
+	if todo.Invalid() {
+		todo = revision.Todo
+		for _, share := range revision.Shares {
+			if share.UserId == u.Id {
+				sharedRole = share.Role
+			}
+		}
+	}
+
+	role, err := p.Role(ctx.Context(), u.Id, todo)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if !role.Includes(required) && !sharedRole.Includes(required) {
+		return fiber.ErrForbidden
+	}
+	return nil
+}
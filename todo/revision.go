@@ -0,0 +1,255 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+	"todo-api/user"
+)
+
+// UndoWindow is how far back POST /undo looks for the caller's last mutation.
+const UndoWindow = 10 * time.Minute
+
+var (
+	RevisionNotFound = errors.New("revision not found")
+	NothingToUndo    = errors.New("nothing to undo")
+	UndoConflict     = errors.New("todo was changed in a way that cannot be undone")
+)
+
+// Revision is a snapshot of a todo taken right before a mutation.
+// Shares are only kept for deletes, since they are removed together with the todo.
+type Revision struct {
+	TodoId    Id         `json:"todo_id"`
+	Revision  uint       `json:"revision"`
+	Action    string     `json:"action"`
+	Todo      Todo       `json:"todo"`
+	Shares    []Share    `json:"shares,omitempty"`
+	ActorId   user.Id    `json:"actor_id"`
+	Undo      bool       `json:"undo"`
+	CreatedAt time.Time  `json:"created_at"`
+	UndoneAt  *time.Time `json:"undone_at,omitempty"`
+}
+
+const revisionColumns = "todo_id, revision, action, snapshot, shares, actor_id, undo, created_at, undone_at"
+
+func scanRevision(row rowScanner) (Revision, error) {
+	var revision Revision
+	var snapshot string
+	var shares sql.NullString
+	var undoneAt sql.NullTime
+	err := row.Scan(&revision.TodoId, &revision.Revision, &revision.Action, &snapshot, &shares,
+		&revision.ActorId, &revision.Undo, &revision.CreatedAt, &undoneAt)
+	if err != nil {
+		return Revision{}, err
+	}
+	if err = json.Unmarshal([]byte(snapshot), &revision.Todo); err != nil {
+		return Revision{}, err
+	}
+	if shares.Valid {
+		if err = json.Unmarshal([]byte(shares.String), &revision.Shares); err != nil {
+			return Revision{}, err
+		}
+	}
+	if undoneAt.Valid {
+		revision.UndoneAt = &undoneAt.Time
+	}
+	return revision, nil
+}
+
+// insertRevision stores the snapshot as the next revision of the todo.
+// Revisions written while undoing are marked so that undo does not undo itself.
+func insertRevision(ctx context.Context, tx *sql.Tx, action string, snapshot Todo, shares []Share, undo bool) error {
+	meta := eventMetaFromContext(ctx)
+
+	encoded, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	var encodedShares any
+	if shares != nil {
+		raw, err := json.Marshal(shares)
+		if err != nil {
+			return err
+		}
+		encodedShares = string(raw)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO todo_revisions (todo_id, revision, action, snapshot, shares, actor_id, undo)
+		VALUES (?, (SELECT COALESCE(MAX(revision), 0) + 1 FROM todo_revisions WHERE todo_id=?), ?, ?, ?, ?, ?)
+	`, snapshot.Id, snapshot.Id, action, string(encoded), encodedShares, meta.ActorId, undo)
+	return err
+}
+
+func (s SqliteStorage) GetRevisions(ctx context.Context, id Id) ([]Revision, error) {
+	stmt, err := s.db.PrepareContext(ctx, "SELECT "+revisionColumns+" FROM todo_revisions WHERE todo_id=? ORDER BY revision DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+// Revert sets the title and description of the todo back to the given revision.
+// The revert itself is recorded as a new revision, so it can be reverted too.
+func (s SqliteStorage) Revert(ctx context.Context, id Id, revision uint) (Todo, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Todo{}, err
+	}
+	defer tx.Rollback()
+
+	target, err := scanRevision(tx.QueryRowContext(ctx,
+		"SELECT "+revisionColumns+" FROM todo_revisions WHERE todo_id=? AND revision=?", id, revision))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Todo{}, RevisionNotFound
+		}
+		return Todo{}, err
+	}
+
+	before, err := getTodoTx(ctx, tx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Todo{}, nil
+		}
+		return Todo{}, err
+	}
+
+	todo, err := updateTx(ctx, tx, before, target.Todo.Title, target.Todo.Description, false)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	return todo, tx.Commit()
+}
+
+// GetUndoable returns the last mutation the actor made after since that can be undone.
+func (s SqliteStorage) GetUndoable(ctx context.Context, actorId user.Id, since time.Time) (Revision, error) {
+	revision, err := scanRevision(s.db.QueryRowContext(ctx, `
+		SELECT `+revisionColumns+` FROM todo_revisions
+		WHERE actor_id=? AND undo=FALSE AND undone_at IS NULL AND created_at>=datetime(?)
+		ORDER BY rowid DESC
+		LIMIT 1
+	`, actorId, since.UTC()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Revision{}, NothingToUndo
+		}
+		return Revision{}, err
+	}
+	return revision, nil
+}
+
+// Undo reverses the mutation of the revision and returns it together with the resulting todo.
+// The todo is zero when undoing a create.
+func (s SqliteStorage) Undo(ctx context.Context, target Revision) (Revision, Todo, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Revision{}, Todo{}, err
+	}
+	defer tx.Rollback()
+
+	// the revision may have been undone since it was looked up
+	revision, err := scanRevision(tx.QueryRowContext(ctx,
+		"SELECT "+revisionColumns+" FROM todo_revisions WHERE todo_id=? AND revision=? AND undone_at IS NULL",
+		target.TodoId, target.Revision))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Revision{}, Todo{}, NothingToUndo
+		}
+		return Revision{}, Todo{}, err
+	}
+
+	current, err := getTodoTx(ctx, tx, revision.TodoId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Revision{}, Todo{}, err
+	}
+
+	var todo Todo
+	switch revision.Action {
+	case ActionCreate:
+		if current.Invalid() {
+			return Revision{}, Todo{}, UndoConflict
+		}
+		err = deleteTx(ctx, tx, current, true)
+	case ActionUpdate:
+		if current.Invalid() {
+			return Revision{}, Todo{}, UndoConflict
+		}
+		todo, err = updateTx(ctx, tx, current, revision.Todo.Title, revision.Todo.Description, true)
+	case ActionDelete:
+		if !current.Invalid() {
+			return Revision{}, Todo{}, UndoConflict
+		}
+		todo, err = restoreTx(ctx, tx, revision.Todo, revision.Shares)
+	default:
+		return Revision{}, Todo{}, UndoConflict
+	}
+	if err != nil {
+		return Revision{}, Todo{}, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE todo_revisions SET undone_at=CURRENT_TIMESTAMP WHERE todo_id=? AND revision=?",
+		revision.TodoId, revision.Revision)
+	if err != nil {
+		return Revision{}, Todo{}, err
+	}
+
+	return revision, todo, tx.Commit()
+}
+
+// restoreTx inserts a deleted todo back with its original id and shares.
+// The assignment and the shares of users deleted in the meantime are left out.
+func restoreTx(ctx context.Context, tx *sql.Tx, snapshot Todo, shares []Share) (Todo, error) {
+	todo, err := scanTodo(tx.QueryRowContext(ctx, `
+		INSERT INTO todos (id, user_id, title, description, created_at, assignee_id, assigned_at, workspace_id)
+		VALUES (?1, ?2, ?3, ?4, datetime(?5), (SELECT id FROM users WHERE id=?6),
+		        CASE WHEN EXISTS (SELECT 1 FROM users WHERE id=?6) THEN ?7 END, ?8)
+		RETURNING `+todoColumns,
+		snapshot.Id, snapshot.UserId, snapshot.Title, snapshot.Description, snapshot.CreatedAt,
+		nullableUserId(snapshot.AssigneeId), snapshot.AssignedAt, nullableWorkspaceId(snapshot.WorkspaceId)))
+	if err != nil {
+		return Todo{}, err
+	}
+
+	for _, share := range shares {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO todo_shares (todo_id, user_id, role, created_at) SELECT ?, id, ?, datetime(?) FROM users WHERE id=?",
+			todo.Id, share.Role, share.CreatedAt, share.UserId)
+		if err != nil {
+			return Todo{}, err
+		}
+	}
+
+	err = insertRevision(ctx, tx, ActionRestore, todo, nil, true)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	err = insertEvent(ctx, tx, todo.Id, ActionRestore, diffTodos(Todo{}, todo))
+	if err != nil {
+		return Todo{}, err
+	}
+
+	return todo, nil
+}
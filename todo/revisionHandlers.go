@@ -0,0 +1,141 @@
+package todo
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"time"
+	"todo-api/user"
+	"todo-api/utils"
+)
+
+// revisionDto leaves out the shares of deleted todos, they are only visible to owners.
+type revisionDto struct {
+	Revision  uint       `json:"revision"`
+	Action    string     `json:"action"`
+	Todo      dto        `json:"todo"`
+	ActorId   user.Id    `json:"actor_id"`
+	Undo      bool       `json:"undo"`
+	CreatedAt time.Time  `json:"created_at"`
+	UndoneAt  *time.Time `json:"undone_at,omitempty"`
+}
+
+func toRevisionDto(revision Revision) revisionDto {
+	return revisionDto{
+		Revision:  revision.Revision,
+		Action:    revision.Action,
+		Todo:      toDto(revision.Todo),
+		ActorId:   revision.ActorId,
+		Undo:      revision.Undo,
+		CreatedAt: revision.CreatedAt,
+		UndoneAt:  revision.UndoneAt,
+	}
+}
+
+func GetRevisionsHandler(storage Storage, permissions *PermissionService) fiber.Handler {
+	type GetRevisionsResponse struct {
+		Data []revisionDto `json:"data"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		todoId := Id(ctx.Params("id", ""))
+
+		_, err := permissions.Require(ctx, todoId, RoleViewer)
+		if err != nil {
+			return err
+		}
+
+		revisions, err := storage.GetRevisions(ctx.Context(), todoId)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		response := GetRevisionsResponse{Data: make([]revisionDto, 0, len(revisions))}
+		for _, revision := range revisions {
+			response.Data = append(response.Data, toRevisionDto(revision))
+		}
+		return ctx.JSON(response)
+	}
+}
+
+func RevertHandler(storage Storage, permissions *PermissionService, validator *utils.AppValidator) fiber.Handler {
+	type RevertRequest struct {
+		Revision uint `query:"revision" validate:"gt=0"`
+	}
+
+	type RevertResponse dto
+
+	return func(ctx fiber.Ctx) error {
+		todoId := Id(ctx.Params("id", ""))
+
+		req := RevertRequest{}
+		err := ctx.Bind().Query(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err = validator.Validate(req); err != nil {
+			return err
+		}
+
+		_, err = permissions.Require(ctx, todoId, RoleEditor)
+		if err != nil {
+			return err
+		}
+
+		todo, err := storage.Revert(mutationContext(ctx), todoId, req.Revision)
+		if err != nil {
+			if errors.Is(err, RevisionNotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return fiber.ErrInternalServerError
+		}
+		if todo.Invalid() {
+			return fiber.ErrForbidden
+		}
+
+		return ctx.JSON(RevertResponse(toDto(todo)))
+	}
+}
+
+// UndoHandler reverses the last create, update or delete the user made within UndoWindow.
+// The user still needs editor access to the todo, access may have been revoked since.
+func UndoHandler(storage Storage, permissions *PermissionService) fiber.Handler {
+	type UndoResponse struct {
+		Undone revisionDto `json:"undone"`
+		Todo   *dto        `json:"todo,omitempty"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		u := user.FromContext(ctx)
+
+		revision, err := storage.GetUndoable(ctx.Context(), u.Id, time.Now().Add(-UndoWindow))
+		if err != nil {
+			if errors.Is(err, NothingToUndo) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return fiber.ErrInternalServerError
+		}
+
+		err = permissions.RequireRevision(ctx, revision, RoleEditor)
+		if err != nil {
+			return err
+		}
+
+		revision, todo, err := storage.Undo(mutationContext(ctx), revision)
+		if err != nil {
+			if errors.Is(err, NothingToUndo) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			if errors.Is(err, UndoConflict) {
+				return fiber.NewError(fiber.StatusConflict, err.Error())
+			}
+			return fiber.ErrInternalServerError
+		}
+
+		response := UndoResponse{Undone: toRevisionDto(revision)}
+		if !todo.Invalid() {
+			todoDto := toDto(todo)
+			response.Todo = &todoDto
+		}
+		return ctx.JSON(response)
+	}
+}
@@ -1,24 +1,78 @@
 package todo
 
 import (
+	"context"
 	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/requestid"
+	"time"
 	"todo-api/config"
 	"todo-api/user"
 	"todo-api/utils"
+	"todo-api/workspace"
 )
 
-func SetupRoutes(app *fiber.App, config *config.AppConfig, storage Storage, validator *utils.AppValidator) {
-	todoGroup := app.Group("/todos", user.ValidateAndExtractTokenMiddleware(config.JwtSecret))
-	todoGroup.Post("/", CreateHandler(storage, validator))
-	todoGroup.Get("/", ReadHandler(storage, validator))
-	todoGroup.Put("/:id", UpdateHandler(storage, validator))
-	todoGroup.Delete("/:id", DeleteHandler(storage))
+func SetupRoutes(app *fiber.App, config *config.AppConfig, auth fiber.Handler, storage Storage, usersStorage user.Storage, workspaces workspace.Storage, validator *utils.AppValidator) {
+	permissions := NewPermissionService(storage, workspaces)
+	workspaceContext := workspace.ContextMiddleware(workspaces)
+	read := user.RequireScope(user.ScopeTodosRead)
+	write := user.RequireScope(user.ScopeTodosWrite)
+	verified := user.RequireVerifiedEmail(config.RequireVerifiedEmail, usersStorage)
+
+	// the workspace is selected by the X-Workspace-Id header or by the path prefix
+	for _, todoGroup := range []fiber.Router{
+		app.Group("/todos", auth, workspaceContext),
+		app.Group("/workspaces/:workspaceId/todos", auth, workspaceContext),
+	} {
+		todoGroup.Post("/", CreateHandler(storage, validator), write, verified)
+		todoGroup.Get("/", ReadHandler(storage, usersStorage, validator), read)
+		todoGroup.Put("/:id", UpdateHandler(storage, permissions, validator), write, verified)
+		todoGroup.Delete("/:id", DeleteHandler(storage, permissions), write, verified)
+
+		todoGroup.Get("/:id/shares", GetSharesHandler(storage, permissions), read)
+		todoGroup.Put("/:id/shares", ShareHandler(storage, usersStorage, permissions, validator), write, verified)
+		todoGroup.Delete("/:id/shares/:userId", UnshareHandler(storage, permissions), write, verified)
+
+		todoGroup.Put("/:id/assignee", AssignHandler(storage, usersStorage, permissions, validator), write, verified)
+		todoGroup.Get("/:id/assignments", GetAssignmentsHandler(storage, permissions), read)
+		todoGroup.Get("/:id/history", HistoryHandler(storage, permissions, validator), read)
+
+		todoGroup.Get("/:id/revisions", GetRevisionsHandler(storage, permissions), read)
+		todoGroup.Post("/:id/revert", RevertHandler(storage, permissions, validator), write, verified)
+	}
+
+	app.Post("/undo", UndoHandler(storage, permissions), auth, write, verified)
+
+	app.Get("/activity", ActivityHandler(storage, validator), auth, read, workspaceContext)
+}
+
+// mutationContext passes the current user and request id to the storage for the activity log.
+func mutationContext(ctx fiber.Ctx) context.Context {
+	return WithEventMeta(ctx.Context(), EventMeta{
+		ActorId:   user.FromContext(ctx).Id,
+		RequestId: requestid.FromContext(ctx),
+	})
 }
 
 type dto struct {
-	Id          Id     `json:"id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	Id          Id           `json:"id"`
+	UserId      user.Id      `json:"user_id"`
+	WorkspaceId workspace.Id `json:"workspace_id,omitempty"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	AssigneeId  user.Id      `json:"assignee_id,omitempty"`
+	AssignedAt  *time.Time   `json:"assigned_at,omitempty"`
+}
+
+func toDto(todo Todo) dto {
+	return dto{
+		Id:          todo.Id,
+		UserId:      todo.UserId,
+		WorkspaceId: todo.WorkspaceId,
+		Title:       todo.Title,
+		Description: todo.Description,
+		AssigneeId:  todo.AssigneeId,
+		AssignedAt:  todo.AssignedAt,
+	}
 }
 
 func CreateHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
@@ -41,20 +95,24 @@ func CreateHandler(storage Storage, validator *utils.AppValidator) fiber.Handler
 			return err
 		}
 
-		todo, err := storage.Create(ctx.Context(), u.Id, req.Title, req.Description)
+		var workspaceId workspace.Id
+		if member := workspace.FromContext(ctx); member != nil {
+			if !member.Role.Includes(workspace.RoleMember) {
+				return fiber.ErrForbidden
+			}
+			workspaceId = member.WorkspaceId
+		}
+
+		todo, err := storage.Create(mutationContext(ctx), u.Id, workspaceId, req.Title, req.Description)
 		if err != nil {
 			return fiber.ErrInternalServerError
 		}
 
-		return ctx.JSON(CreateResponse{
-			Id:          todo.Id,
-			Title:       todo.Title,
-			Description: todo.Description,
-		})
+		return ctx.JSON(CreateResponse(toDto(todo)))
 	}
 }
 
-func ReadHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
+func ReadHandler(storage Storage, usersStorage user.Storage, validator *utils.AppValidator) fiber.Handler {
 	type ReadRequest struct {
 		Page        uint   `query:"page" validate:"gt=0"`
 		Limit       uint   `query:"limit" validate:"gt=0"`
@@ -62,24 +120,32 @@ func ReadHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
 		SortOrder   string `query:"sort_order" validate:"oneof=asc desc"`
 		Title       string `query:"title"`
 		Description string `query:"description"`
+		Owner       string `query:"owner" validate:"oneof=me others any"`
+		Assignee    string `query:"assignee" validate:"omitempty,oneof=me"`
 	}
 
 	type ReadResponse struct {
-		Data  []dto
-		Page  uint `json:"page"`
-		Limit uint `json:"limit"`
-		Total uint `json:"total"`
+		Data         []dto
+		Page         uint `json:"page"`
+		Limit        uint `json:"limit"`
+		Total        uint `json:"total"`
+		AssignedToMe uint `json:"assigned_to_me"`
 	}
 
 	return func(ctx fiber.Ctx) error {
-		u := user.FromContext(ctx)
+		// the sort preferences of the user apply when the query has none
+		u, err := usersStorage.GetById(ctx.Context(), user.FromContext(ctx).Id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
 		req := ReadRequest{
 			Page:      1,
 			Limit:     10,
-			SortBy:    "id",
-			SortOrder: "desc",
+			SortBy:    u.Preferences.SortBy,
+			SortOrder: u.Preferences.SortOrder,
+			Owner:     OwnerAny,
 		}
-		err := ctx.Bind().Query(&req)
+		err = ctx.Bind().Query(&req)
 		if err != nil {
 			return fiber.ErrBadRequest
 		}
@@ -94,37 +160,51 @@ func ReadHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
 			SortOrder:   req.SortOrder,
 			Title:       req.Title,
 			Description: req.Description,
+			Owner:       req.Owner,
+			Assignee:    req.Assignee,
+		}
+		if member := workspace.FromContext(ctx); member != nil {
+			options.WorkspaceId = member.WorkspaceId
+			options.WorkspaceWide = member.Role.Includes(workspace.RoleMember)
 		}
 		todos, err := storage.GetByUserId(ctx.Context(), u.Id, options)
 		if err != nil {
 			return fiber.ErrInternalServerError
 		}
 
-		total, err := storage.Count(ctx.Context(), u.Id)
+		total, err := storage.Count(ctx.Context(), u.Id, options)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		// counted in the same workspace as the page
+		assignedToMe, err := storage.Count(ctx.Context(), u.Id, FindOptions{
+			Owner:         OwnerAny,
+			Assignee:      AssigneeMe,
+			WorkspaceId:   options.WorkspaceId,
+			WorkspaceWide: options.WorkspaceWide,
+		})
 		if err != nil {
 			return fiber.ErrInternalServerError
 		}
 
 		response := ReadResponse{
-			Data:  make([]dto, len(todos)),
-			Page:  req.Page,
-			Limit: req.Limit,
-			Total: total,
+			Data:         make([]dto, len(todos)),
+			Page:         req.Page,
+			Limit:        req.Limit,
+			Total:        total,
+			AssignedToMe: assignedToMe,
 		}
 
 		for i, todo := range todos {
-			response.Data[i] = dto{
-				Id:          todo.Id,
-				Title:       todo.Title,
-				Description: todo.Description,
-			}
+			response.Data[i] = toDto(todo)
 		}
 
 		return ctx.JSON(response)
 	}
 }
 
-func UpdateHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
+func UpdateHandler(storage Storage, permissions *PermissionService, validator *utils.AppValidator) fiber.Handler {
 	type UpdateRequest struct {
 		Id          Id     `json:"id" validate:"required"`
 		Title       string `json:"title" validate:"required,gte=0,lte=255"`
@@ -144,12 +224,12 @@ func UpdateHandler(storage Storage, validator *utils.AppValidator) fiber.Handler
 			return err
 		}
 
-		err = validatePermission(ctx, storage, req.Id)
+		_, err = permissions.Require(ctx, req.Id, RoleEditor)
 		if err != nil {
 			return err
 		}
 
-		todo, err := storage.Update(ctx.Context(), req.Id, req.Title, req.Description)
+		todo, err := storage.Update(mutationContext(ctx), req.Id, req.Title, req.Description)
 		if err != nil {
 			return fiber.ErrInternalServerError
 		}
@@ -157,15 +237,11 @@ func UpdateHandler(storage Storage, validator *utils.AppValidator) fiber.Handler
 			return fiber.ErrForbidden
 		}
 
-		return ctx.JSON(UpdateResponse{
-			Id:          todo.Id,
-			Title:       todo.Title,
-			Description: todo.Description,
-		})
+		return ctx.JSON(UpdateResponse(toDto(todo)))
 	}
 }
 
-func DeleteHandler(storage Storage) fiber.Handler {
+func DeleteHandler(storage Storage, permissions *PermissionService) fiber.Handler {
 	type DeleteRequest struct {
 	}
 
@@ -175,12 +251,12 @@ func DeleteHandler(storage Storage) fiber.Handler {
 	return func(ctx fiber.Ctx) error {
 		todoId := Id(ctx.Params("id", ""))
 
-		err := validatePermission(ctx, storage, todoId)
+		_, err := permissions.Require(ctx, todoId, RoleOwner)
 		if err != nil {
 			return err
 		}
 
-		err = storage.Delete(ctx.Context(), todoId)
+		err = storage.Delete(mutationContext(ctx), todoId)
 		if err != nil {
 			return fiber.ErrInternalServerError
 		}
@@ -188,15 +264,3 @@ func DeleteHandler(storage Storage) fiber.Handler {
 		return ctx.JSON(DeleteResponse{})
 	}
 }
-
-func validatePermission(ctx fiber.Ctx, storage Storage, todoId Id) error {
-	u := user.FromContext(ctx)
-	todo, err := storage.GetById(ctx.Context(), todoId)
-	if err != nil {
-		return fiber.ErrInternalServerError
-	}
-	if todo.UserId != u.Id {
-		return fiber.ErrForbidden
-	}
-	return nil
-}
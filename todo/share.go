@@ -0,0 +1,166 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"todo-api/user"
+)
+
+type Share struct {
+	TodoId    Id        `json:"todo_id"`
+	UserId    user.Id   `json:"user_id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s SqliteStorage) GetShares(ctx context.Context, todoId Id) ([]Share, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		SELECT s.todo_id, s.user_id, u.email, u.name, s.role, s.created_at
+		FROM todo_shares s JOIN users u ON u.id = s.user_id
+		WHERE s.todo_id=?
+		ORDER BY s.created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, todoId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []Share
+	for rows.Next() {
+		var share Share
+		err = rows.Scan(&share.TodoId, &share.UserId, &share.Email, &share.Name, &share.Role, &share.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+func (s SqliteStorage) GetShareRole(ctx context.Context, todoId Id, userId user.Id) (Role, error) {
+	stmt, err := s.db.PrepareContext(ctx, "SELECT role FROM todo_shares WHERE todo_id=? AND user_id=?")
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+
+	var role Role
+	err = stmt.QueryRowContext(ctx, todoId, userId).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+func (s SqliteStorage) Share(ctx context.Context, todoId Id, userId user.Id, role Role) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getShareRole(ctx, tx, todoId, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO todo_shares (todo_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT (todo_id, user_id) DO UPDATE SET role=excluded.role
+	`, todoId, userId, role)
+	if err != nil {
+		return err
+	}
+
+	err = insertEvent(ctx, tx, todoId, ActionShare, shareChanges(userId, before, role))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s SqliteStorage) Unshare(ctx context.Context, todoId Id, userId user.Id) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getShareRole(ctx, tx, todoId, userId)
+	if err != nil {
+		return err
+	}
+	if before == "" {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM todo_shares WHERE todo_id=? AND user_id=?", todoId, userId)
+	if err != nil {
+		return err
+	}
+
+	err = insertEvent(ctx, tx, todoId, ActionUnshare, shareChanges(userId, before, ""))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func getShareRole(ctx context.Context, tx *sql.Tx, todoId Id, userId user.Id) (Role, error) {
+	var role Role
+	err := tx.QueryRowContext(ctx, "SELECT role FROM todo_shares WHERE todo_id=? AND user_id=?", todoId, userId).Scan(&role)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	return role, nil
+}
+
+func shareChanges(userId user.Id, before, after Role) map[string]Change {
+	nullable := func(role Role) any {
+		if role == "" {
+			return nil
+		}
+		return role
+	}
+	return map[string]Change{
+		"shares." + string(userId): {Before: nullable(before), After: nullable(after)},
+	}
+}
+
+func getSharesTx(ctx context.Context, tx *sql.Tx, todoId Id) ([]Share, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT s.todo_id, s.user_id, u.email, u.name, s.role, s.created_at
+		FROM todo_shares s JOIN users u ON u.id = s.user_id
+		WHERE s.todo_id=?
+	`, todoId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []Share
+	for rows.Next() {
+		var share Share
+		err = rows.Scan(&share.TodoId, &share.UserId, &share.Email, &share.Name, &share.Role, &share.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
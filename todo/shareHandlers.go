@@ -0,0 +1,122 @@
+package todo
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"time"
+	"todo-api/user"
+	"todo-api/utils"
+)
+
+func GetSharesHandler(storage Storage, permissions *PermissionService) fiber.Handler {
+	type GetSharesResponse struct {
+		Data []Share `json:"data"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		todoId := Id(ctx.Params("id", ""))
+
+		_, err := permissions.Require(ctx, todoId, RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		shares, err := storage.GetShares(ctx.Context(), todoId)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if shares == nil {
+			shares = []Share{}
+		}
+
+		return ctx.JSON(GetSharesResponse{Data: shares})
+	}
+}
+
+func ShareHandler(storage Storage, usersStorage user.Storage, permissions *PermissionService, validator *utils.AppValidator) fiber.Handler {
+	type ShareRequest struct {
+		Email string `json:"email" validate:"required,email"`
+		Role  Role   `json:"role" validate:"required,oneof=viewer editor owner"`
+	}
+
+	type ShareResponse Share
+
+	return func(ctx fiber.Ctx) error {
+		todoId := Id(ctx.Params("id", ""))
+
+		req := ShareRequest{}
+		err := ctx.Bind().Body(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err = validator.Validate(req); err != nil {
+			return err
+		}
+
+		todo, err := permissions.Require(ctx, todoId, RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		recipient, err := usersStorage.GetUserByEmail(ctx.Context(), req.Email)
+		if err != nil {
+			if errors.Is(err, user.NotFound) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			return fiber.ErrInternalServerError
+		}
+		if recipient.Id == todo.UserId {
+			return fiber.NewError(fiber.StatusBadRequest, "todo is already owned by this user")
+		}
+
+		err = storage.Share(mutationContext(ctx), todoId, recipient.Id, req.Role)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.JSON(ShareResponse{
+			TodoId:    todoId,
+			UserId:    recipient.Id,
+			Email:     recipient.Email,
+			Name:      recipient.Name,
+			Role:      req.Role,
+			CreatedAt: time.Now(),
+		})
+	}
+}
+
+func UnshareHandler(storage Storage, permissions *PermissionService) fiber.Handler {
+	type UnshareResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		u := user.FromContext(ctx)
+		todoId := Id(ctx.Params("id", ""))
+		userId := user.Id(ctx.Params("userId", ""))
+
+		// recipients may always leave a share, everyone else needs to own the todo
+		required := RoleOwner
+		if userId == u.Id {
+			required = RoleViewer
+		}
+		todo, err := permissions.Require(ctx, todoId, required)
+		if err != nil {
+			return err
+		}
+
+		// assignees must keep access to the todo
+		if todo.AssigneeId == userId {
+			_, err = storage.Assign(mutationContext(ctx), todoId, "", u.Id)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+		}
+
+		err = storage.Unshare(mutationContext(ctx), todoId, userId)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.JSON(UnshareResponse{})
+	}
+}
@@ -8,17 +8,21 @@ import (
 	"github.com/oklog/ulid/v2"
 	"time"
 	"todo-api/user"
+	"todo-api/workspace"
 )
 
 type Id string
 
 type Todo struct {
-	Id          Id        `json:"id"`
-	UserId      user.Id   `json:"user_id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	Id          Id           `json:"id"`
+	UserId      user.Id      `json:"user_id"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	AssigneeId  user.Id      `json:"assignee_id,omitempty"`
+	AssignedAt  *time.Time   `json:"assigned_at,omitempty"`
+	WorkspaceId workspace.Id `json:"workspace_id,omitempty"`
 }
 
 func (t *Todo) Invalid() bool {
@@ -26,12 +30,28 @@ func (t *Todo) Invalid() bool {
 }
 
 type Storage interface {
-	Create(ctx context.Context, userId user.Id, title, description string) (Todo, error)
+	Create(ctx context.Context, userId user.Id, workspaceId workspace.Id, title, description string) (Todo, error)
 	GetById(ctx context.Context, id Id) (Todo, error)
 	GetByUserId(ctx context.Context, userId user.Id, options FindOptions) ([]Todo, error)
 	Update(ctx context.Context, id Id, title, description string) (Todo, error)
 	Delete(ctx context.Context, id Id) error
-	Count(ctx context.Context, userId user.Id) (uint, error)
+	Count(ctx context.Context, userId user.Id, options FindOptions) (uint, error)
+	Assign(ctx context.Context, id Id, assigneeId user.Id, assignedBy user.Id) (Todo, error)
+
+	GetRevisions(ctx context.Context, id Id) ([]Revision, error)
+	Revert(ctx context.Context, id Id, revision uint) (Todo, error)
+	GetUndoable(ctx context.Context, actorId user.Id, since time.Time) (Revision, error)
+	Undo(ctx context.Context, revision Revision) (Revision, Todo, error)
+
+	GetAssignments(ctx context.Context, id Id) ([]Assignment, error)
+
+	GetEventsByTodoId(ctx context.Context, id Id, options ActivityOptions) ([]Event, error)
+	GetActivity(ctx context.Context, userId user.Id, options ActivityOptions) ([]Event, error)
+
+	GetShares(ctx context.Context, todoId Id) ([]Share, error)
+	GetShareRole(ctx context.Context, todoId Id, userId user.Id) (Role, error)
+	Share(ctx context.Context, todoId Id, userId user.Id, role Role) error
+	Unshare(ctx context.Context, todoId Id, userId user.Id) error
 }
 
 const (
@@ -41,12 +61,48 @@ const (
 
 	SortAscending  = "asc"
 	SortDescending = "desc"
+
+	OwnerMe     = "me"
+	OwnerOthers = "others"
+	OwnerAny    = "any"
+
+	AssigneeMe = "me"
 )
 
+const todoColumns = "id, user_id, title, description, created_at, updated_at, assignee_id, assigned_at, workspace_id"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTodo(row rowScanner) (Todo, error) {
+	var todo Todo
+	var assigneeId sql.NullString
+	var assignedAt sql.NullTime
+	var workspaceId sql.NullString
+	err := row.Scan(&todo.Id, &todo.UserId, &todo.Title, &todo.Description, &todo.CreatedAt, &todo.UpdatedAt,
+		&assigneeId, &assignedAt, &workspaceId)
+	if err != nil {
+		return Todo{}, err
+	}
+	todo.AssigneeId = user.Id(assigneeId.String)
+	todo.WorkspaceId = workspace.Id(workspaceId.String)
+	if assignedAt.Valid {
+		todo.AssignedAt = &assignedAt.Time
+	}
+	return todo, nil
+}
+
 type FindOptions struct {
 	Limit, Offset      uint
 	SortBy, SortOrder  string
 	Title, Description string
+	Owner              string
+	Assignee           string
+	// WorkspaceId limits the result to todos of a workspace.
+	// With WorkspaceWide all todos of the workspace are visible, not only own and shared ones.
+	WorkspaceId   workspace.Id
+	WorkspaceWide bool
 }
 
 func (f *FindOptions) Validate() error {
@@ -62,9 +118,50 @@ func (f *FindOptions) Validate() error {
 	if f.SortBy != IdName && f.SortBy != TitleName && f.SortBy != DescriptionName {
 		return errors.New("invalid sort field")
 	}
+	if f.Owner != OwnerMe && f.Owner != OwnerOthers && f.Owner != OwnerAny {
+		return errors.New("invalid owner filter")
+	}
+	if f.Assignee != "" && f.Assignee != AssigneeMe {
+		return errors.New("invalid assignee filter")
+	}
 	return nil
 }
 
+// visibilityFilter returns the where clause selecting todos visible to the user for the owner and assignee filters.
+func visibilityFilter(userId user.Id, options FindOptions) (string, []any) {
+	var where string
+	var args []any
+	if options.WorkspaceId != "" && options.WorkspaceWide {
+		where, args = "workspace_id=?", []any{options.WorkspaceId}
+		switch options.Owner {
+		case OwnerMe:
+			where += " AND user_id=?"
+			args = append(args, userId)
+		case OwnerOthers:
+			where += " AND user_id<>?"
+			args = append(args, userId)
+		}
+	} else {
+		switch options.Owner {
+		case OwnerMe:
+			where, args = "user_id=?", []any{userId}
+		case OwnerOthers:
+			where, args = "id IN (SELECT todo_id FROM todo_shares WHERE user_id=?)", []any{userId}
+		default:
+			where, args = "(user_id=? OR id IN (SELECT todo_id FROM todo_shares WHERE user_id=?))", []any{userId, userId}
+		}
+		if options.WorkspaceId != "" {
+			where += " AND workspace_id=?"
+			args = append(args, options.WorkspaceId)
+		}
+	}
+	if options.Assignee == AssigneeMe {
+		where += " AND assignee_id=?"
+		args = append(args, userId)
+	}
+	return where, args
+}
+
 type SqliteStorage struct {
 	db *sql.DB
 }
@@ -73,47 +170,65 @@ func NewSqliteStorage(db *sql.DB) *SqliteStorage {
 	return &SqliteStorage{db: db}
 }
 
-func (s SqliteStorage) Create(ctx context.Context, userId user.Id, title, description string) (Todo, error) {
+func (s SqliteStorage) Create(ctx context.Context, userId user.Id, workspaceId workspace.Id, title, description string) (Todo, error) {
 	todoId := ulid.Make().String()
 
-	stmt, err := s.db.PrepareContext(ctx, "INSERT INTO todos (id, user_id, title, description) VALUES (?, ?, ?, ?)")
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return Todo{}, err
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
-	exec, err := stmt.ExecContext(ctx, todoId, userId, title, description)
+	exec, err := tx.ExecContext(ctx,
+		"INSERT INTO todos (id, user_id, workspace_id, title, description) VALUES (?, ?, ?, ?, ?)",
+		todoId, userId, nullableWorkspaceId(workspaceId), title, description)
 	if err != nil {
 		return Todo{}, err
 	}
 
 	affected, err := exec.RowsAffected()
 	if affected == 0 || err != nil {
-		return Todo{}, errors.New("user creation error")
+		return Todo{}, errors.New("todo creation error")
 	}
 
-	return Todo{
+	todo := Todo{
 		Id:          Id(todoId),
 		UserId:      userId,
 		Title:       title,
 		Description: description,
+		WorkspaceId: workspaceId,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
-	}, nil
+	}
+
+	err = insertRevision(ctx, tx, ActionCreate, todo, nil, false)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	err = insertEvent(ctx, tx, todo.Id, ActionCreate, diffTodos(Todo{}, todo))
+	if err != nil {
+		return Todo{}, err
+	}
+
+	return todo, tx.Commit()
+}
+
+func nullableWorkspaceId(id workspace.Id) any {
+	if id == "" {
+		return nil
+	}
+	return id
 }
 
 func (s SqliteStorage) GetById(ctx context.Context, id Id) (Todo, error) {
-	stmt, err := s.db.PrepareContext(ctx, `
-		SELECT id, user_id, title, description, created_at, updated_at
-		FROM todos WHERE id=?
-	`)
+	stmt, err := s.db.PrepareContext(ctx, "SELECT "+todoColumns+" FROM todos WHERE id=?")
 	if err != nil {
 		return Todo{}, err
 	}
 	defer stmt.Close()
-	todo := Todo{}
 
-	err = stmt.QueryRowContext(ctx, id).Scan(&todo.Id, &todo.UserId, &todo.Title, &todo.Description, &todo.CreatedAt, &todo.UpdatedAt)
+	todo, err := scanTodo(stmt.QueryRowContext(ctx, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Todo{}, nil
@@ -137,19 +252,20 @@ func (s SqliteStorage) GetByUserId(ctx context.Context, userId user.Id, options
 		whereDescription += " AND description LIKE ?"
 	}
 
+	whereVisible, args := visibilityFilter(userId, options)
+
 	stmt, err := s.db.PrepareContext(ctx, fmt.Sprintf(`
-		SELECT id, user_id, title, description, created_at, updated_at
-		FROM todos WHERE user_id=? %s %s
+		SELECT %s
+		FROM todos WHERE %s %s %s
 		ORDER BY %s %s
 		LIMIT ?
 		OFFSET ?
-	`, whereTitle, whereDescription, options.SortBy, options.SortOrder))
+	`, todoColumns, whereVisible, whereTitle, whereDescription, options.SortBy, options.SortOrder))
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	args := []any{userId}
 	if options.Title != "" {
 		args = append(args, options.Title)
 	}
@@ -162,11 +278,11 @@ func (s SqliteStorage) GetByUserId(ctx context.Context, userId user.Id, options
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var todos []Todo
 	for rows.Next() {
-		var todo Todo
-		err = rows.Scan(&todo.Id, &todo.UserId, &todo.Title, &todo.Description, &todo.CreatedAt, &todo.UpdatedAt)
+		todo, err := scanTodo(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -176,20 +292,13 @@ func (s SqliteStorage) GetByUserId(ctx context.Context, userId user.Id, options
 }
 
 func (s SqliteStorage) Update(ctx context.Context, id Id, title, description string) (Todo, error) {
-	stmt, err := s.db.PrepareContext(ctx, `
-		UPDATE todos
-		SET title=?,description=?,updated_at=CURRENT_TIMESTAMP
-		WHERE id=?
-		RETURNING id, user_id, title, description, created_at, updated_at
-	`)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return Todo{}, err
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
-	todo := Todo{}
-	err = stmt.QueryRowContext(ctx, title, description, id).
-		Scan(&todo.Id, &todo.UserId, &todo.Title, &todo.Description, &todo.CreatedAt, &todo.UpdatedAt)
+	before, err := getTodoTx(ctx, tx, id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Todo{}, nil
@@ -197,33 +306,106 @@ func (s SqliteStorage) Update(ctx context.Context, id Id, title, description str
 		return Todo{}, err
 	}
 
-	return todo, nil
+	todo, err := updateTx(ctx, tx, before, title, description, false)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	return todo, tx.Commit()
 }
 
 func (s SqliteStorage) Delete(ctx context.Context, id Id) error {
-	stmt, err := s.db.PrepareContext(ctx, "DELETE FROM todos WHERE id=?")
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
-	_, err = stmt.ExecContext(ctx, id)
+	before, err := getTodoTx(ctx, tx, id)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
 		return err
 	}
 
-	return nil
+	err = deleteTx(ctx, tx, before, false)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func getTodoTx(ctx context.Context, tx *sql.Tx, id Id) (Todo, error) {
+	return scanTodo(tx.QueryRowContext(ctx, "SELECT "+todoColumns+" FROM todos WHERE id=?", id))
+}
+
+// updateTx changes the content of the todo, keeping its previous version as a revision.
+func updateTx(ctx context.Context, tx *sql.Tx, before Todo, title, description string, undo bool) (Todo, error) {
+	todo, err := scanTodo(tx.QueryRowContext(ctx, `
+		UPDATE todos
+		SET title=?,description=?,updated_at=CURRENT_TIMESTAMP
+		WHERE id=?
+		RETURNING `+todoColumns, title, description, before.Id))
+	if err != nil {
+		return Todo{}, err
+	}
+
+	err = insertRevision(ctx, tx, ActionUpdate, before, nil, undo)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	err = insertEvent(ctx, tx, todo.Id, ActionUpdate, diffTodos(before, todo))
+	if err != nil {
+		return Todo{}, err
+	}
+
+	return todo, nil
 }
 
-func (s SqliteStorage) Count(ctx context.Context, userId user.Id) (uint, error) {
-	stmt, err := s.db.PrepareContext(ctx, "SELECT COUNT() FROM todos WHERE user_id=?")
+// deleteTx removes the todo with its shares and assignments, keeping the todo and its shares as a revision.
+func deleteTx(ctx context.Context, tx *sql.Tx, before Todo, undo bool) error {
+	shares, err := getSharesTx(ctx, tx, before.Id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM todo_shares WHERE todo_id=?", before.Id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM todo_assignments WHERE todo_id=?", before.Id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM todos WHERE id=?", before.Id)
+	if err != nil {
+		return err
+	}
+
+	err = insertRevision(ctx, tx, ActionDelete, before, shares, undo)
+	if err != nil {
+		return err
+	}
+
+	return insertEvent(ctx, tx, before.Id, ActionDelete, diffTodos(before, Todo{}))
+}
+
+func (s SqliteStorage) Count(ctx context.Context, userId user.Id, options FindOptions) (uint, error) {
+	whereVisible, args := visibilityFilter(userId, options)
+
+	stmt, err := s.db.PrepareContext(ctx, "SELECT COUNT() FROM todos WHERE "+whereVisible)
 	if err != nil {
 		return 0, err
 	}
 	defer stmt.Close()
 
 	var count uint
-	err = stmt.QueryRowContext(ctx, userId).Scan(&count)
+	err = stmt.QueryRowContext(ctx, args...).Scan(&count)
 	if err != nil {
 		return 0, err
 	}
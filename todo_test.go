@@ -0,0 +1,204 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func createTodo(t *testing.T, a *testApp, token string, title string) string {
+	status, response := a.do(t, "POST", "/todos", map[string]string{"title": title}, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	id, _ := response["id"].(string)
+	return id
+}
+
+func TestTodoSharing(t *testing.T) {
+	a := newTestApp(t)
+	owner, _ := a.register(t, "owner@example.com")
+	other, _ := a.register(t, "other@example.com")
+	id := createTodo(t, a, owner, "shared")
+	update := map[string]string{"title": "changed"}
+
+	status, _ := a.do(t, "PUT", "/todos/"+id, update, bearer(other)...)
+	assert.Equal(t, 403, status, "update without a share")
+	status, _ = a.do(t, "GET", "/todos/"+id+"/revisions", nil, bearer(other)...)
+	assert.Equal(t, 403, status, "read without a share")
+
+	status, _ = a.do(t, "PUT", "/todos/"+id+"/shares", map[string]string{"email": "other@example.com", "role": "viewer"}, bearer(owner)...)
+	assert.Equal(t, 200, status)
+	status, _ = a.do(t, "GET", "/todos/"+id+"/revisions", nil, bearer(other)...)
+	assert.Equal(t, 200, status, "viewer reads")
+	status, _ = a.do(t, "PUT", "/todos/"+id, update, bearer(other)...)
+	assert.Equal(t, 403, status, "viewer updates")
+
+	status, _ = a.do(t, "PUT", "/todos/"+id+"/shares", map[string]string{"email": "other@example.com", "role": "editor"}, bearer(owner)...)
+	assert.Equal(t, 200, status)
+	status, _ = a.do(t, "PUT", "/todos/"+id, update, bearer(other)...)
+	assert.Equal(t, 200, status, "editor updates")
+	status, _ = a.do(t, "DELETE", "/todos/"+id, nil, bearer(other)...)
+	assert.Equal(t, 403, status, "editor deletes")
+	status, _ = a.do(t, "PUT", "/todos/"+id+"/shares", map[string]string{"email": "owner@example.com", "role": "viewer"}, bearer(other)...)
+	assert.Equal(t, 403, status, "editor shares")
+
+	status, _ = a.do(t, "DELETE", "/todos/"+id+"/shares/"+userId(t, a, other), nil, bearer(owner)...)
+	assert.Equal(t, 200, status)
+	status, _ = a.do(t, "PUT", "/todos/"+id, update, bearer(other)...)
+	assert.Equal(t, 403, status, "update after the share was removed")
+}
+
+func userId(t *testing.T, a *testApp, token string) string {
+	status, response := a.do(t, "GET", "/me", nil, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	id, _ := response["id"].(string)
+	return id
+}
+
+func TestUndoNeedsCurrentAccess(t *testing.T) {
+	a := newTestApp(t)
+	owner, _ := a.register(t, "owner@example.com")
+	editor, _ := a.register(t, "editor@example.com")
+	id := createTodo(t, a, owner, "original")
+
+	status, _ := a.do(t, "PUT", "/todos/"+id+"/shares", map[string]string{"email": "editor@example.com", "role": "editor"}, bearer(owner)...)
+	assert.Equal(t, 200, status)
+	status, _ = a.do(t, "PUT", "/todos/"+id, map[string]string{"title": "edited"}, bearer(editor)...)
+	assert.Equal(t, 200, status)
+
+	status, _ = a.do(t, "DELETE", "/todos/"+id+"/shares/"+userId(t, a, editor), nil, bearer(owner)...)
+	assert.Equal(t, 200, status)
+	status, _ = a.do(t, "POST", "/undo", nil, bearer(editor)...)
+	assert.Equal(t, 403, status, "undo after the share was removed")
+
+	status, response := a.do(t, "POST", "/undo", nil, bearer(owner)...)
+	assert.Equal(t, 200, status, response)
+	status, _ = a.do(t, "POST", "/undo", nil, bearer(owner)...)
+	assert.Equal(t, 404, status, "the owner's only mutation was undone")
+}
+
+func TestUndoDelete(t *testing.T) {
+	a := newTestApp(t)
+	owner, _ := a.register(t, "owner@example.com")
+	id := createTodo(t, a, owner, "deleted")
+
+	status, _ := a.do(t, "DELETE", "/todos/"+id, nil, bearer(owner)...)
+	assert.Equal(t, 200, status)
+	status, response := a.do(t, "POST", "/undo", nil, bearer(owner)...)
+	assert.Equal(t, 200, status, response)
+	todo, _ := response["todo"].(map[string]any)
+	assert.Equal(t, id, todo["id"])
+}
+
+func assign(t *testing.T, a *testApp, token string, todoId string, assigneeId string) (int, map[string]any) {
+	return a.do(t, "PUT", "/todos/"+todoId+"/assignee", map[string]string{"assignee_id": assigneeId}, bearer(token)...)
+}
+
+// listTodos returns the ids of the todos on the page and the whole response.
+func listTodos(t *testing.T, a *testApp, token string, path string, headers ...string) ([]string, map[string]any) {
+	status, response := a.do(t, "GET", path, nil, append(bearer(token), headers...)...)
+	assert.Equal(t, 200, status, response)
+	var ids []string
+	data, _ := response["Data"].([]any)
+	for _, todo := range data {
+		id, _ := todo.(map[string]any)["id"].(string)
+		ids = append(ids, id)
+	}
+	return ids, response
+}
+
+func TestAssignment(t *testing.T) {
+	a := newTestApp(t)
+	owner, _ := a.register(t, "owner@example.com")
+	other, _ := a.register(t, "other@example.com")
+	stranger, _ := a.register(t, "stranger@example.com")
+	otherId := userId(t, a, other)
+	id := createTodo(t, a, owner, "assigned")
+
+	status, _ := assign(t, a, owner, id, userId(t, a, stranger))
+	assert.Equal(t, 400, status, "assignee without access to the todo")
+	status, _ = assign(t, a, owner, id, "01J0000000000000000000000")
+	assert.Equal(t, 400, status, "unknown assignee")
+	status, _ = assign(t, a, other, id, otherId)
+	assert.Equal(t, 403, status, "assigning without access")
+
+	status, _ = a.do(t, "PUT", "/todos/"+id+"/shares", map[string]string{"email": "other@example.com", "role": "viewer"}, bearer(owner)...)
+	assert.Equal(t, 200, status)
+	status, response := assign(t, a, owner, id, otherId)
+	assert.Equal(t, 200, status, response)
+	assert.Equal(t, otherId, response["assignee_id"])
+	assert.NotEmpty(t, response["assigned_at"])
+
+	ids, response := listTodos(t, a, other, "/todos?assignee=me")
+	assert.Equal(t, []string{id}, ids)
+	assert.Equal(t, 1.0, response["assigned_to_me"])
+	ids, response = listTodos(t, a, owner, "/todos?assignee=me")
+	assert.Empty(t, ids)
+	assert.Equal(t, 0.0, response["assigned_to_me"])
+	status, _ = a.do(t, "GET", "/todos?assignee=someone", nil, bearer(owner)...)
+	assert.Equal(t, 400, status, "unknown assignee filter")
+}
+
+func TestReassignment(t *testing.T) {
+	a := newTestApp(t)
+	owner, _ := a.register(t, "owner@example.com")
+	other, _ := a.register(t, "other@example.com")
+	ownerId, otherId := userId(t, a, owner), userId(t, a, other)
+	id := createTodo(t, a, owner, "reassigned")
+	status, _ := a.do(t, "PUT", "/todos/"+id+"/shares", map[string]string{"email": "other@example.com", "role": "editor"}, bearer(owner)...)
+	assert.Equal(t, 200, status)
+
+	status, response := assign(t, a, owner, id, otherId)
+	assert.Equal(t, 200, status, response)
+	assignedAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	_, err := a.db.Exec("UPDATE todos SET assigned_at=? WHERE id=?", assignedAt, id)
+	assert.NoError(t, err)
+
+	// the editor hands the todo back
+	status, response = assign(t, a, other, id, ownerId)
+	assert.Equal(t, 200, status, response)
+	assert.Equal(t, ownerId, response["assignee_id"])
+	reassignedAt, err := time.Parse(time.RFC3339, response["assigned_at"].(string))
+	assert.NoError(t, err)
+	assert.True(t, reassignedAt.After(assignedAt), "the reassignment restarts the timestamp")
+
+	status, response = assign(t, a, owner, id, "")
+	assert.Equal(t, 200, status, response)
+	assert.Nil(t, response["assignee_id"])
+	assert.Nil(t, response["assigned_at"], "unassigned")
+
+	status, response = a.do(t, "GET", "/todos/"+id+"/assignments", nil, bearer(owner)...)
+	assert.Equal(t, 200, status, response)
+	assignments, _ := response["data"].([]any)
+	if assert.Len(t, assignments, 3) {
+		for i, expected := range []struct{ assignee, by any }{{otherId, ownerId}, {ownerId, otherId}, {"", ownerId}} {
+			assignment := assignments[i].(map[string]any)
+			assert.Equal(t, expected.assignee, assignment["assignee_id"], i)
+			assert.Equal(t, expected.by, assignment["assigned_by"], i)
+		}
+	}
+}
+
+func TestAssignedToMeCountsTheWorkspace(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	id := userId(t, a, token)
+	status, response := a.do(t, "POST", "/workspaces", map[string]string{"name": "team"}, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	workspaceId, _ := response["id"].(string)
+
+	personal := createTodo(t, a, token, "personal")
+	status, response = a.do(t, "POST", "/workspaces/"+workspaceId+"/todos", map[string]string{"title": "team"}, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	team, _ := response["id"].(string)
+	for _, todo := range []string{personal, team} {
+		status, response = assign(t, a, token, todo, id)
+		assert.Equal(t, 200, status, response)
+	}
+
+	_, response = listTodos(t, a, token, "/todos")
+	assert.Equal(t, 2.0, response["assigned_to_me"])
+	ids, response := listTodos(t, a, token, "/workspaces/"+workspaceId+"/todos")
+	assert.Equal(t, []string{team}, ids)
+	assert.Equal(t, 1.0, response["total"])
+	assert.Equal(t, 1.0, response["assigned_to_me"], "only the todos of the workspace")
+}
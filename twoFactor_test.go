@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+	"todo-api/utils"
+)
+
+// enableTwoFactor enrolls the user in TOTP and returns the secret and the recovery codes.
+func enableTwoFactor(t *testing.T, a *testApp, token string) (string, []string) {
+	status, response := a.do(t, "POST", "/me/2fa/totp", nil, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	secret, _ := response["secret"].(string)
+
+	code, err := utils.TotpCode(secret, utils.TotpStep(time.Now()))
+	assert.NoError(t, err)
+	status, response = a.do(t, "POST", "/me/2fa/totp/confirm", map[string]string{"code": code}, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+
+	var recoveryCodes []string
+	codes, _ := response["recovery_codes"].([]any)
+	for _, code := range codes {
+		recoveryCodes = append(recoveryCodes, code.(string))
+	}
+	return secret, recoveryCodes
+}
+
+func mfaToken(t *testing.T, a *testApp, email string) string {
+	status, response := a.do(t, "POST", "/login", map[string]string{"email": email, "password": testPassword})
+	assert.Equal(t, 200, status, response)
+	assert.Equal(t, true, response["mfa_required"])
+	token, _ := response["mfa_token"].(string)
+	return token
+}
+
+func TestLoginWithRecoveryCode(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	_, recoveryCodes := enableTwoFactor(t, a, token)
+	assert.Len(t, recoveryCodes, 10)
+
+	status, _ := a.do(t, "POST", "/login/mfa", map[string]string{"mfa_token": mfaToken(t, a, "user@example.com"), "code": "aaaaa-aaaaa"})
+	assert.Equal(t, 400, status, "wrong recovery code")
+
+	// codes are accepted in upper case and without the dash
+	code := strings.ToUpper(strings.ReplaceAll(recoveryCodes[0], "-", ""))
+	status, response := a.do(t, "POST", "/login/mfa", map[string]string{"mfa_token": mfaToken(t, a, "user@example.com"), "code": code})
+	assert.Equal(t, 200, status, response)
+	assert.NotEmpty(t, response["token"])
+
+	status, _ = a.do(t, "POST", "/login/mfa", map[string]string{"mfa_token": mfaToken(t, a, "user@example.com"), "code": recoveryCodes[0]})
+	assert.Equal(t, 400, status, "used recovery code")
+}
+
+func TestLoginWithTotpCode(t *testing.T) {
+	a := newTestApp(t)
+	token, _ := a.register(t, "user@example.com")
+	secret, _ := enableTwoFactor(t, a, token)
+
+	// the code of the enrollment step was used up, the next one is still within the skew
+	code, err := utils.TotpCode(secret, utils.TotpStep(time.Now())+1)
+	assert.NoError(t, err)
+	status, response := a.do(t, "POST", "/login/mfa", map[string]string{"mfa_token": mfaToken(t, a, "user@example.com"), "code": code})
+	assert.Equal(t, 200, status, response)
+
+	status, _ = a.do(t, "POST", "/login/mfa", map[string]string{"mfa_token": mfaToken(t, a, "user@example.com"), "code": code})
+	assert.Equal(t, 400, status, "replayed totp code")
+}
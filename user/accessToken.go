@@ -0,0 +1,221 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/oklog/ulid/v2"
+	"slices"
+	"strings"
+	"time"
+	"todo-api/utils"
+)
+
+// AccessTokenPrefix marks personal access tokens, so they can be told apart from JWTs and found by secret scanners.
+const AccessTokenPrefix = "tdp_"
+
+// accessTokenUsageInterval limits how often last_used_at is written for a busy token.
+const accessTokenUsageInterval = time.Minute
+
+const (
+	ScopeTodosRead       = "todos:read"
+	ScopeTodosWrite      = "todos:write"
+	ScopeWorkspacesRead  = "workspaces:read"
+	ScopeWorkspacesWrite = "workspaces:write"
+)
+
+const scopesContextKey = "scopes"
+
+var AccessTokenNotFound = errors.New("access token not found")
+
+// AccessToken is a long-lived personal access token for scripts and CI jobs.
+// Only the hash of the token is stored, Hint keeps its first characters to recognise it in the list.
+type AccessToken struct {
+	Id         string     `json:"id"`
+	UserId     Id         `json:"-"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func (t AccessToken) Expired() bool {
+	return t.ExpiresAt != nil && t.ExpiresAt.Before(time.Now())
+}
+
+const accessTokenColumns = "id, user_id, name, hint, scopes, created_at, expires_at, last_used_at"
+
+func scanAccessToken(row rowScanner) (AccessToken, error) {
+	var token AccessToken
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&token.Id, &token.UserId, &token.Name, &token.Hint, &scopes, &token.CreatedAt, &expiresAt, &lastUsedAt)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	token.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return token, nil
+}
+
+func (s SqliteUsersStorage) CreateAccessToken(ctx context.Context, userId Id, name string, hint string, tokenHash string, scopes []string, expiresAt *time.Time) (AccessToken, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		INSERT INTO personal_access_tokens (id, user_id, name, hint, token_hash, scopes, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING `+accessTokenColumns)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	defer stmt.Close()
+
+	var expires any
+	if expiresAt != nil {
+		expires = expiresAt.UTC()
+	}
+	return scanAccessToken(stmt.QueryRowContext(ctx,
+		ulid.Make().String(), userId, name, hint, tokenHash, strings.Join(scopes, " "), expires))
+}
+
+func (s SqliteUsersStorage) GetAccessTokens(ctx context.Context, userId Id) ([]AccessToken, error) {
+	stmt, err := s.db.PrepareContext(ctx, "SELECT "+accessTokenColumns+" FROM personal_access_tokens WHERE user_id=? ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []AccessToken{}
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// UseAccessToken finds the token by its hash and records that it was used.
+func (s SqliteUsersStorage) UseAccessToken(ctx context.Context, tokenHash string) (AccessToken, error) {
+	token, err := scanAccessToken(s.db.QueryRowContext(ctx,
+		"SELECT "+accessTokenColumns+" FROM personal_access_tokens WHERE token_hash=?", tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return AccessToken{}, AccessTokenNotFound
+		}
+		return AccessToken{}, err
+	}
+
+	now := time.Now()
+	if token.LastUsedAt == nil || token.LastUsedAt.Before(now.Add(-accessTokenUsageInterval)) {
+		_, err = s.db.ExecContext(ctx, "UPDATE personal_access_tokens SET last_used_at=? WHERE id=?", now.UTC(), token.Id)
+		if err != nil {
+			return AccessToken{}, err
+		}
+		token.LastUsedAt = &now
+	}
+	return token, nil
+}
+
+func (s SqliteUsersStorage) DeleteAccessToken(ctx context.Context, userId Id, id string) error {
+	stmt, err := s.db.PrepareContext(ctx, "DELETE FROM personal_access_tokens WHERE id=? AND user_id=?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx, id, userId)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return AccessTokenNotFound
+	}
+	return nil
+}
+
+// accessTokenMiddleware authenticates requests made with a personal access token
+// and limits them to the scopes of the token.
+func accessTokenMiddleware(storage Storage) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		token, err := storage.UseAccessToken(c.Context(), utils.HashToken(bearerToken(c)))
+		if err != nil {
+			if errors.Is(err, AccessTokenNotFound) {
+				return authErrorHandler(c, err)
+			}
+			return err
+		}
+		if token.Expired() {
+			return authErrorHandler(c, jwt.ErrTokenExpired)
+		}
+
+		user, err := storage.GetById(c.Context(), token.UserId)
+		if err != nil {
+			if errors.Is(err, NotFound) {
+				return authErrorHandler(c, err)
+			}
+			return err
+		}
+		if user.disabledAt != nil {
+			return authErrorHandler(c, AccountDisabled)
+		}
+
+		c.Locals(userContextKey, &user)
+		c.Locals(scopesContextKey, token.Scopes)
+
+		return c.Next()
+	}
+}
+
+func bearerToken(c fiber.Ctx) string {
+	const scheme = "Bearer "
+	auth := c.Get(fiber.HeaderAuthorization)
+	if len(auth) > len(scheme) && strings.EqualFold(auth[:len(scheme)], scheme) {
+		return strings.TrimSpace(auth[len(scheme):])
+	}
+	return ""
+}
+
+// isAccessTokenRequest reports whether the request is authenticated by a personal access token instead of a JWT.
+func isAccessTokenRequest(c fiber.Ctx) bool {
+	return strings.HasPrefix(bearerToken(c), AccessTokenPrefix)
+}
+
+// RequireScope rejects requests made with a personal access token or a token of an OAuth client that lacks the scope.
+// Requests authenticated by a login session have every scope.
+func RequireScope(scope string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		scopes, ok := c.Locals(scopesContextKey).([]string)
+		if ok && !slices.Contains(scopes, scope) {
+			return fiber.NewError(fiber.StatusForbidden, "access token is missing the "+scope+" scope")
+		}
+		return c.Next()
+	}
+}
+
+// RequireSession rejects requests made with a personal access token or a token of an OAuth client,
+// e.g. so that a leaked token cannot be used to create new ones.
+func RequireSession(c fiber.Ctx) error {
+	if _, ok := c.Locals(tokenContextKey).(*jwt.Token); !ok || c.Locals(scopesContextKey) != nil {
+		return fiber.NewError(fiber.StatusForbidden, "this endpoint requires a login session")
+	}
+	return c.Next()
+}
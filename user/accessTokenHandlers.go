@@ -0,0 +1,86 @@
+package user
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"time"
+	"todo-api/utils"
+)
+
+func CreateAccessTokenHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
+	type CreateAccessTokenRequest struct {
+		Name      string     `json:"name" validate:"required,lte=255"`
+		Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=todos:read todos:write workspaces:read workspaces:write"`
+		ExpiresAt *time.Time `json:"expires_at" validate:"omitempty,gt"`
+	}
+
+	// the token itself is only returned once, on creation
+	type CreateAccessTokenResponse struct {
+		AccessToken
+		Token string `json:"token"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user := FromContext(ctx)
+
+		data := CreateAccessTokenRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		secret, err := utils.NewRandomToken()
+		if err != nil {
+			return err
+		}
+		token := AccessTokenPrefix + secret
+
+		accessToken, err := storage.CreateAccessToken(ctx.Context(), user.Id, data.Name, token[:len(AccessTokenPrefix)+6],
+			utils.HashToken(token), data.Scopes, data.ExpiresAt)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(CreateAccessTokenResponse{AccessToken: accessToken, Token: token})
+	}
+}
+
+func GetAccessTokensHandler(storage Storage) fiber.Handler {
+	type GetAccessTokensResponse struct {
+		Data []AccessToken `json:"data"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user := FromContext(ctx)
+
+		tokens, err := storage.GetAccessTokens(ctx.Context(), user.Id)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(GetAccessTokensResponse{Data: tokens})
+	}
+}
+
+func DeleteAccessTokenHandler(storage Storage) fiber.Handler {
+	type DeleteAccessTokenResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user := FromContext(ctx)
+
+		err := storage.DeleteAccessToken(ctx.Context(), user.Id, ctx.Params("id", ""))
+		if err != nil {
+			if errors.Is(err, AccessTokenNotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return err
+		}
+
+		return ctx.JSON(DeleteAccessTokenResponse{})
+	}
+}
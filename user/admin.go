@@ -0,0 +1,122 @@
+package user
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// UserFilter selects a page of users for the admin api.
+type UserFilter struct {
+	// Search matches a part of the email or the name
+	Search string
+	Role   Role
+	Limit  uint
+	Offset uint
+}
+
+// Stats are the numbers of the instance shown to admins.
+type Stats struct {
+	Users             uint `json:"users"`
+	VerifiedUsers     uint `json:"verified_users"`
+	TwoFactorUsers    uint `json:"two_factor_users"`
+	Admins            uint `json:"admins"`
+	DisabledUsers     uint `json:"disabled_users"`
+	DeletionScheduled uint `json:"deletion_scheduled"`
+	ActiveSessions    uint `json:"active_sessions"`
+	AccessTokens      uint `json:"access_tokens"`
+	Todos             uint `json:"todos"`
+	Workspaces        uint `json:"workspaces"`
+}
+
+func (f UserFilter) where() (string, []any) {
+	where := "1=1"
+	var args []any
+	if f.Search != "" {
+		// the search is taken literally, not as a LIKE pattern
+		replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+		pattern := "%" + replacer.Replace(strings.ToLower(f.Search)) + "%"
+		where += ` AND (lower(email) LIKE ? ESCAPE '\' OR lower(name) LIKE ? ESCAPE '\')`
+		args = append(args, pattern, pattern)
+	}
+	if f.Role != "" {
+		where += " AND role=?"
+		args = append(args, f.Role)
+	}
+	return where, args
+}
+
+func (s SqliteUsersStorage) FindUsers(ctx context.Context, filter UserFilter) ([]User, error) {
+	where, args := filter.where()
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE "+where+" ORDER BY id LIMIT ? OFFSET ?",
+		append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s SqliteUsersStorage) CountUsers(ctx context.Context, filter UserFilter) (uint, error) {
+	where, args := filter.where()
+	var count uint
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE "+where, args...).Scan(&count)
+	return count, err
+}
+
+func (s SqliteUsersStorage) SetRole(ctx context.Context, id Id, role Role) error {
+	return s.updateUser(ctx, "UPDATE users SET role=? WHERE id=?", role, id)
+}
+
+// SetDisabled blocks or unblocks the account. Tokens issued before have to be revoked separately.
+func (s SqliteUsersStorage) SetDisabled(ctx context.Context, id Id, disabled bool) error {
+	if disabled {
+		return s.updateUser(ctx, "UPDATE users SET disabled_at=COALESCE(disabled_at, ?) WHERE id=?", time.Now().UTC(), id)
+	}
+	return s.updateUser(ctx, "UPDATE users SET disabled_at=NULL WHERE id=?", id)
+}
+
+func (s SqliteUsersStorage) updateUser(ctx context.Context, query string, args ...any) error {
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return NotFound
+	}
+	return nil
+}
+
+func (s SqliteUsersStorage) GetStats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	now := time.Now().UTC()
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM users WHERE email_verified_at IS NOT NULL),
+			(SELECT COUNT(*) FROM users WHERE totp_enabled_at IS NOT NULL),
+			(SELECT COUNT(*) FROM users WHERE role=?1),
+			(SELECT COUNT(*) FROM users WHERE disabled_at IS NOT NULL),
+			(SELECT COUNT(*) FROM users WHERE deleted_at IS NOT NULL),
+			(SELECT COUNT(*) FROM sessions WHERE revoked_at IS NULL AND expires_at>?2),
+			(SELECT COUNT(*) FROM personal_access_tokens WHERE expires_at IS NULL OR expires_at>?2),
+			(SELECT COUNT(*) FROM todos),
+			(SELECT COUNT(*) FROM workspaces)
+	`, RoleAdmin, now).Scan(&stats.Users, &stats.VerifiedUsers, &stats.TwoFactorUsers, &stats.Admins, &stats.DisabledUsers,
+		&stats.DeletionScheduled, &stats.ActiveSessions, &stats.AccessTokens, &stats.Todos, &stats.Workspaces)
+	return stats, err
+}
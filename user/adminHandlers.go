@@ -0,0 +1,220 @@
+package user
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"time"
+	"todo-api/utils"
+)
+
+// adminUser is a user as shown to admins, with the state of the account.
+type adminUser struct {
+	User
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+}
+
+func toAdminUser(user User) adminUser {
+	return adminUser{User: user, DisabledAt: user.disabledAt, DeletedAt: user.deletedAt}
+}
+
+func ListUsersHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
+	type ListUsersRequest struct {
+		Page   uint   `query:"page" validate:"gt=0"`
+		Limit  uint   `query:"limit" validate:"gt=0,lte=100"`
+		Search string `query:"search" validate:"lte=255"`
+		Role   string `query:"role" validate:"omitempty,oneof=user admin"`
+	}
+
+	type ListUsersResponse struct {
+		Data  []adminUser `json:"data"`
+		Page  uint        `json:"page"`
+		Limit uint        `json:"limit"`
+		Total uint        `json:"total"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		req := ListUsersRequest{Page: 1, Limit: 20}
+		err := ctx.Bind().Query(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err = validator.Validate(req); err != nil {
+			return err
+		}
+
+		filter := UserFilter{Search: req.Search, Role: Role(req.Role), Limit: req.Limit, Offset: (req.Page - 1) * req.Limit}
+		users, err := storage.FindUsers(ctx.Context(), filter)
+		if err != nil {
+			return err
+		}
+		total, err := storage.CountUsers(ctx.Context(), filter)
+		if err != nil {
+			return err
+		}
+
+		response := ListUsersResponse{Data: make([]adminUser, len(users)), Page: req.Page, Limit: req.Limit, Total: total}
+		for i, user := range users {
+			response.Data[i] = toAdminUser(user)
+		}
+		return ctx.JSON(response)
+	}
+}
+
+func GetUserHandler(storage Storage) fiber.Handler {
+	type GetUserResponse adminUser
+
+	return func(ctx fiber.Ctx) error {
+		user, err := storage.GetById(ctx.Context(), Id(ctx.Params("id")))
+		if err != nil {
+			if errors.Is(err, NotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return err
+		}
+
+		return ctx.JSON(GetUserResponse(toAdminUser(user)))
+	}
+}
+
+// UpdateRoleHandler changes the role of a user and revokes their tokens, so the new role applies at once.
+func UpdateRoleHandler(storage Storage, revocations *RevocationList, validator *utils.AppValidator) fiber.Handler {
+	type UpdateRoleRequest struct {
+		Role string `json:"role" validate:"required,oneof=user admin"`
+	}
+
+	type UpdateRoleResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		data := UpdateRoleRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		id := Id(ctx.Params("id"))
+		if id == FromContext(ctx).Id {
+			return fiber.NewError(fiber.StatusBadRequest, "you cannot change your own role")
+		}
+
+		err = storage.SetRole(ctx.Context(), id, Role(data.Role))
+		if err != nil {
+			if errors.Is(err, NotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return err
+		}
+		err = revocations.RevokeAll(ctx.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(UpdateRoleResponse{})
+	}
+}
+
+// DisableUserHandler blocks the account and ends all its sessions until EnableUserHandler.
+func DisableUserHandler(storage Storage, revocations *RevocationList) fiber.Handler {
+	type DisableUserResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		id := Id(ctx.Params("id"))
+		if id == FromContext(ctx).Id {
+			return fiber.NewError(fiber.StatusBadRequest, "you cannot disable your own account")
+		}
+
+		err := storage.SetDisabled(ctx.Context(), id, true)
+		if err != nil {
+			if errors.Is(err, NotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return err
+		}
+		err = revocations.RevokeAll(ctx.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(DisableUserResponse{})
+	}
+}
+
+func EnableUserHandler(storage Storage) fiber.Handler {
+	type EnableUserResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		err := storage.SetDisabled(ctx.Context(), Id(ctx.Params("id")), false)
+		if err != nil {
+			if errors.Is(err, NotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return err
+		}
+
+		return ctx.JSON(EnableUserResponse{})
+	}
+}
+
+// ForceLogoutHandler revokes every session and token of a user, personal access tokens stay valid.
+func ForceLogoutHandler(storage Storage, revocations *RevocationList) fiber.Handler {
+	type ForceLogoutResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user, err := storage.GetById(ctx.Context(), Id(ctx.Params("id")))
+		if err != nil {
+			if errors.Is(err, NotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return err
+		}
+		err = revocations.RevokeAll(ctx.Context(), user.Id)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(ForceLogoutResponse{})
+	}
+}
+
+// UnlockUserHandler clears the failed logins of a user locked out by the login throttle.
+func UnlockUserHandler(storage Storage) fiber.Handler {
+	type UnlockUserResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user, err := storage.GetById(ctx.Context(), Id(ctx.Params("id")))
+		if err != nil {
+			if errors.Is(err, NotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return err
+		}
+		err = UnlockAccount(ctx.Context(), storage, user.Email)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(UnlockUserResponse{})
+	}
+}
+
+func StatsHandler(storage Storage) fiber.Handler {
+	type StatsResponse Stats
+
+	return func(ctx fiber.Ctx) error {
+		stats, err := storage.GetStats(ctx.Context())
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(StatsResponse(stats))
+	}
+}
@@ -1,9 +1,16 @@
 package user
 
 import (
+	"context"
+	"errors"
 	"github.com/gofiber/fiber/v3"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/oklog/ulid/v2"
 	jwtware "jwt"
+	"strings"
+	"time"
+	"todo-api/config"
+	"todo-api/utils"
 )
 
 const (
@@ -15,48 +22,214 @@ var (
 	SigningMethod = jwt.SigningMethodHS512
 )
 
-func GetToken(user User, secretKey string) (string, error) {
-	token := jwt.New(SigningMethod)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["sub"] = user.Id
-	claims["email"] = user.Email
-	claims["name"] = user.Name
+// Tokens is the pair returned to clients on login and refresh.
+type Tokens struct {
+	// both are left out in cookie mode, where they are set as cookies
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in"`
+	// TokenType is DPoP for tokens bound to the key of a DPoP proof, left out for bearer tokens
+	TokenType string `json:"token_type,omitempty"`
+}
+
+// accessClaims are the claims of the access tokens.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	// EmailVerified is missing in tokens issued before verification was introduced
+	EmailVerified *bool    `json:"email_verified,omitempty"`
+	Role          Role     `json:"role"`
+	Workspaces    []string `json:"workspaces"`
+	Generation    int      `json:"gen"`
+	SessionId     string   `json:"sid"`
+	// ClientId and Scope are set in tokens granted to an OAuth client
+	ClientId string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// Confirmation binds the token to the key of a DPoP proof
+	Confirmation *confirmation `json:"cnf,omitempty"`
+}
+
+type confirmation struct {
+	Jkt string `json:"jkt"`
+}
+
+// dpopJkt returns the thumbprint of the key the token is bound to, if any.
+func (c *accessClaims) dpopJkt() string {
+	if c.Confirmation == nil {
+		return ""
+	}
+	return c.Confirmation.Jkt
+}
+
+// requiredClaims are the claims every access token has, tokens without them are rejected.
+var requiredClaims = []string{"sub", "jti", "iat", "exp", "email", "name"}
+
+func GetToken(user User, sessionId string, dpopJkt string, keyring *Keyring, ttl time.Duration) (string, error) {
+	return keyring.Sign(userClaims(keyring, user, sessionId, dpopJkt, ttl))
+}
+
+func userClaims(keyring *Keyring, user User, sessionId string, dpopJkt string, ttl time.Duration) accessClaims {
+	now := time.Now()
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        ulid.Make().String(),
+			Issuer:    keyring.issuer,
+			Subject:   string(user.Id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Email:         user.Email,
+		Name:          user.Name,
+		EmailVerified: &user.EmailVerified,
+		Role:          user.Role,
+		Workspaces:    user.WorkspaceIds,
+		Generation:    user.tokenGeneration,
+		SessionId:     sessionId,
+	}
+	if keyring.audience != "" {
+		claims.Audience = jwt.ClaimStrings{keyring.audience}
+	}
+	if dpopJkt != "" {
+		claims.Confirmation = &confirmation{Jkt: dpopJkt}
+	}
+	return claims
+}
+
+// issueTokens starts a new session for the user on the device, e.g. on login.
+func issueTokens(ctx context.Context, config *config.AppConfig, storage Storage, keyring *Keyring, user User, device Device) (Tokens, error) {
+	refreshToken, err := utils.NewRandomToken()
+	if err != nil {
+		return Tokens{}, err
+	}
 
-	return token.SignedString([]byte(secretKey))
+	expiresAt := time.Now().Add(config.RefreshTokenTTL)
+	session, err := storage.CreateSession(ctx, user.Id, device, expiresAt)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	_, err = storage.CreateRefreshToken(ctx, user.Id, session.Id, utils.HashToken(refreshToken), expiresAt)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	return newTokens(config, keyring, user, session.Id, session.DpopJkt, refreshToken)
 }
 
-func ValidateAndExtractTokenMiddleware(secretKey string) func(fiber.Ctx) error {
-	return jwtware.New(jwtware.Config{
-		SigningKey:     jwtware.SigningKey{Key: []byte(secretKey)},
-		SuccessHandler: successHandler,
+func newTokens(config *config.AppConfig, keyring *Keyring, user User, sessionId string, dpopJkt string, refreshToken string) (Tokens, error) {
+	token, err := GetToken(user, sessionId, dpopJkt, keyring, config.AccessTokenTTL)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	tokens := Tokens{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(config.AccessTokenTTL.Seconds()),
+	}
+	if dpopJkt != "" {
+		tokens.TokenType = jwtware.DPoPScheme
+	}
+	return tokens, nil
+}
+
+// ValidateAndExtractTokenMiddleware authenticates the request by a JWT or by a personal access token.
+// In cookie mode the JWT can also come from the access token cookie, then state-changing requests need a csrf token.
+// JWTs bound to a key are sent with the DPoP scheme and a proof of the key.
+func ValidateAndExtractTokenMiddleware(appConfig *config.AppConfig, storage Storage, keyring *Keyring, revocations *RevocationList) func(fiber.Ctx) error {
+	config := jwtware.Config{
+		SuccessHandler: successHandler(revocations),
 		ErrorHandler:   authErrorHandler,
 		ContextKey:     tokenContextKey,
-	})
+		DPoP:           NewDPoPConfig(appConfig, storage),
+	}
+	if appConfig.CookieAuth() {
+		config.TokenLookup = "header:" + fiber.HeaderAuthorization + ",cookie:" + accessTokenCookie
+		config.AuthScheme = "Bearer"
+	}
+	keyring.verificationConfig(&config)
+	jwtMiddleware := jwtware.New(config)
+	accessTokens := accessTokenMiddleware(storage)
+
+	return func(c fiber.Ctx) error {
+		if isAccessTokenRequest(c) {
+			return accessTokens(c)
+		}
+		if appConfig.CookieAuth() && isCookieRequest(c) {
+			if err := checkCsrf(c); err != nil {
+				return err
+			}
+		}
+		return jwtMiddleware(c)
+	}
 }
 
-func successHandler(c fiber.Ctx) error {
+func successHandler(revocations *RevocationList) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		claims, err := tokenClaims(c)
+		if err != nil {
+			return err
+		}
+
+		if revocations.IsRevoked(Id(claims.Subject), claims.ID, claims.SessionId, claims.Generation) {
+			return authErrorHandler(c, jwt.ErrTokenExpired)
+		}
+
+		return setUser(c, claims)
+	}
+}
+
+func tokenClaims(c fiber.Ctx) (*accessClaims, error) {
 	token, ok := c.Locals(tokenContextKey).(*jwt.Token)
 	if !ok {
-		return fiber.ErrUnauthorized
+		return nil, fiber.ErrUnauthorized
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
+	claims, ok := token.Claims.(*accessClaims)
 	if !ok {
-		return fiber.ErrInternalServerError
+		return nil, fiber.ErrInternalServerError
+	}
+	return claims, nil
+}
+
+func setUser(c fiber.Ctx, claims *accessClaims) error {
+	// the required claims are present, but may still be empty
+	if claims.Subject == "" || claims.Email == "" {
+		return authErrorHandler(c, jwt.ErrTokenInvalidClaims)
 	}
 
 	user := User{
-		Id:    Id(claims["sub"].(string)),
-		Email: claims["email"].(string),
-		Name:  claims["name"].(string),
+		Id:           Id(claims.Subject),
+		Email:        claims.Email,
+		Name:         claims.Name,
+		WorkspaceIds: claims.Workspaces,
+	}
+	// tokens issued before verification was introduced have no claim, those accounts are verified
+	user.EmailVerified = claims.EmailVerified == nil || *claims.EmailVerified
+	user.Role = RoleUser
+	if claims.Role.Valid() {
+		user.Role = claims.Role
 	}
 
 	c.Locals(userContextKey, &user)
+	// tokens granted to an OAuth client are limited like personal access tokens
+	if claims.ClientId != "" {
+		c.Locals(scopesContextKey, strings.Fields(claims.Scope))
+	}
 
 	return c.Next()
 }
 
 func authErrorHandler(c fiber.Ctx, err error) error {
+	if errors.Is(err, jwtware.ErrDPoPProof) {
+		c.Set(fiber.HeaderWWWAuthenticate, `DPoP error="invalid_dpop_proof", algs="`+strings.Join(jwtware.DPoPAlgs, " ")+`"`)
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{
+			"status":  fiber.StatusUnauthorized,
+			"message": err.Error(),
+		})
+	}
 	if err.Error() == "missing or malformed JWT" {
 		c.Status(fiber.StatusBadRequest)
 		return c.JSON(fiber.Map{
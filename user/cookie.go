@@ -0,0 +1,116 @@
+package user
+
+import (
+	"crypto/subtle"
+	"github.com/gofiber/fiber/v3"
+	jwtware "jwt"
+	"time"
+	"todo-api/config"
+	"todo-api/utils"
+)
+
+// cookies and header of the cookie auth mode
+const (
+	accessTokenCookie  = "access_token"
+	refreshTokenCookie = "refresh_token"
+	csrfCookie         = "csrf_token"
+	csrfHeader         = "X-CSRF-Token"
+	// refreshTokenPath is the only path the refresh token cookie is sent to
+	refreshTokenPath = "/token/refresh"
+
+	// oidcStateCookie binds a login at the identity provider to the browser that started it
+	oidcStateCookie  = "oidc_state"
+	oidcCallbackPath = "/auth/oidc/callback"
+)
+
+// setAuthCookies moves the tokens into HttpOnly cookies in cookie mode, out of reach of the scripts of the page,
+// and returns what is left of them for the response body. Every new access token comes with a new csrf token.
+// Tokens bound to a DPoP key stay in the body, they are useless without a proof of the key.
+func setAuthCookies(ctx fiber.Ctx, config *config.AppConfig, tokens Tokens) (Tokens, error) {
+	if !config.CookieAuth() || tokens.TokenType == jwtware.DPoPScheme {
+		return tokens, nil
+	}
+
+	csrfToken, err := utils.NewRandomToken()
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	setCookie(ctx, config, accessTokenCookie, tokens.Token, "/", time.Now().Add(config.AccessTokenTTL), true)
+	if tokens.RefreshToken != "" {
+		setCookie(ctx, config, refreshTokenCookie, tokens.RefreshToken, refreshTokenPath, time.Now().Add(config.RefreshTokenTTL), true)
+	}
+	// the page reads the csrf token and repeats it in the X-CSRF-Token header
+	setCookie(ctx, config, csrfCookie, csrfToken, "/", time.Now().Add(config.RefreshTokenTTL), false)
+
+	return Tokens{ExpiresIn: tokens.ExpiresIn}, nil
+}
+
+// clearAuthCookies removes the cookies of setAuthCookies, e.g. on logout.
+func clearAuthCookies(ctx fiber.Ctx, config *config.AppConfig) {
+	if !config.CookieAuth() {
+		return
+	}
+
+	expired := time.Unix(0, 0)
+	setCookie(ctx, config, accessTokenCookie, "", "/", expired, true)
+	setCookie(ctx, config, refreshTokenCookie, "", refreshTokenPath, expired, true)
+	setCookie(ctx, config, csrfCookie, "", "/", expired, false)
+}
+
+func setCookie(ctx fiber.Ctx, config *config.AppConfig, name string, value string, path string, expires time.Time, httpOnly bool) {
+	ctx.Cookie(&fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Expires:  expires,
+		Secure:   config.SecureCookies(),
+		HTTPOnly: httpOnly,
+		SameSite: config.CookieSameSite,
+	})
+}
+
+// setOidcStateCookie keeps the hash of the state in the browser, see checkOidcStateCookie.
+// It is Lax in any mode: the provider redirects back with a top-level navigation from its own site.
+func setOidcStateCookie(ctx fiber.Ctx, config *config.AppConfig, stateHash string, expires time.Time) {
+	ctx.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    stateHash,
+		Path:     oidcCallbackPath,
+		Expires:  expires,
+		Secure:   config.SecureCookies(),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// checkOidcStateCookie rejects a callback in a browser other than the one that started the login,
+// e.g. a link with the code and state of the attacker's own login sent to the victim. The cookie is used up.
+func checkOidcStateCookie(ctx fiber.Ctx, config *config.AppConfig, stateHash string) error {
+	cookie := ctx.Cookies(oidcStateCookie)
+	setOidcStateCookie(ctx, config, "", time.Unix(0, 0))
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(stateHash)) != 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "the login was started in another browser")
+	}
+	return nil
+}
+
+// isCookieRequest reports whether the request is authenticated by the access token cookie rather than a header.
+func isCookieRequest(c fiber.Ctx) bool {
+	return c.Get(fiber.HeaderAuthorization) == "" && c.Cookies(accessTokenCookie) != ""
+}
+
+// checkCsrf protects state-changing requests authenticated by the cookie with the double-submit pattern:
+// the X-CSRF-Token header has to repeat the csrf cookie, which other sites cannot read.
+func checkCsrf(c fiber.Ctx) error {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return nil
+	}
+
+	cookie := c.Cookies(csrfCookie)
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(c.Get(csrfHeader))) != 1 {
+		return fiber.NewError(fiber.StatusForbidden, "missing or invalid csrf token")
+	}
+	return nil
+}
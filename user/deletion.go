@@ -0,0 +1,211 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	DeletionScheduled = errors.New("account is scheduled for deletion")
+	RestoreExpired    = errors.New("account cannot be restored anymore")
+)
+
+// purgeAfter is how long after the deletion request an account is removed.
+// It is never shorter than the access token lifetime: the revoked token generation is kept
+// on the users row, so the row has to live until every access token of the account expired.
+func purgeAfter(grace time.Duration, tokenTTL time.Duration) time.Duration {
+	return max(grace, tokenTTL)
+}
+
+// RunAccountPurge removes the accounts whose deletion grace period is over every interval. It never returns.
+func RunAccountPurge(storage Storage, grace time.Duration, tokenTTL time.Duration, interval time.Duration) {
+	for range time.Tick(interval) {
+		purged, err := storage.PurgeDeleted(context.Background(), time.Now().Add(-purgeAfter(grace, tokenTTL)))
+		if err != nil {
+			log.Printf("account purge failed: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("account purge: %d accounts deleted", purged)
+		}
+	}
+}
+
+// ScheduleDeletion disables the account and removes its personal access tokens, the data stays until PurgeDeleted.
+// Sessions and access tokens are revoked separately, see RevocationList.RevokeAll.
+func (s SqliteUsersStorage) ScheduleDeletion(ctx context.Context, id Id) (time.Time, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	deletedAt := time.Now().UTC()
+	result, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at=? WHERE id=? AND deleted_at IS NULL", deletedAt, id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if affected == 0 {
+		return time.Time{}, DeletionScheduled
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM personal_access_tokens WHERE user_id=?", id)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return deletedAt, tx.Commit()
+}
+
+// RestoreDeleted enables an account again if its deletion was requested after deletedAfter.
+func (s SqliteUsersStorage) RestoreDeleted(ctx context.Context, id Id, deletedAfter time.Time) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE users SET deleted_at=NULL WHERE id=? AND deleted_at>?", id, deletedAfter.UTC())
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return RestoreExpired
+	}
+	return nil
+}
+
+// PurgeDeleted removes the accounts deleted before deletedBefore with all their data and returns their number.
+func (s SqliteUsersStorage) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM users WHERE deleted_at<=?", deletedBefore.UTC())
+	if err != nil {
+		return 0, err
+	}
+	var ids []Id
+	for rows.Next() {
+		var id Id
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		if err = s.purge(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// purge removes the users row, the foreign keys cascade to the data of the account.
+// What they do not reach is removed here: the workspaces the user is the last member of, the history of the todos
+// and the traces keyed by the email.
+func (s SqliteUsersStorage) purge(ctx context.Context, id Id) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// workspaces nobody else is a member of go with the account
+	rows, err := tx.QueryContext(ctx, `
+		SELECT workspace_id FROM workspace_members m
+		WHERE user_id=? AND NOT EXISTS (SELECT 1 FROM workspace_members o WHERE o.workspace_id=m.workspace_id AND o.user_id<>?)
+	`, id, id)
+	if err != nil {
+		return err
+	}
+	var workspaceIds []string
+	for rows.Next() {
+		var workspaceId string
+		if err = rows.Scan(&workspaceId); err != nil {
+			rows.Close()
+			return err
+		}
+		workspaceIds = append(workspaceIds, workspaceId)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, workspaceId := range workspaceIds {
+		if err = deleteHistoryTx(ctx, tx, "workspace_id", workspaceId); err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, "DELETE FROM workspaces WHERE id=?", workspaceId); err != nil {
+			return err
+		}
+	}
+
+	if err = transferOwnershipTx(ctx, tx, id); err != nil {
+		return err
+	}
+
+	if err = deleteHistoryTx(ctx, tx, "user_id", id); err != nil {
+		return err
+	}
+
+	// apps of the user stop working for everyone who granted them access
+	if _, err = revokeClientSessionsTx(ctx, tx, "owner_id=?", id); err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"UPDATE todos SET assignee_id=NULL, assigned_at=NULL WHERE assignee_id=?",
+		"DELETE FROM workspace_invitations WHERE email=(SELECT email FROM users WHERE id=?)",
+		"DELETE FROM login_failures WHERE key=(SELECT 'email:' || lower(email) FROM users WHERE id=?)",
+		"DELETE FROM users WHERE id=?",
+	} {
+		if _, err = tx.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// transferOwnershipTx hands the workspaces the user is the only owner of to the longest standing admin,
+// or member if there is no admin. The roles are those of the workspace package.
+func transferOwnershipTx(ctx context.Context, tx *sql.Tx, id Id) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE workspace_members SET role='owner'
+		WHERE user_id<>?1 AND (workspace_id, user_id) IN (
+			SELECT m.workspace_id, (
+				SELECT o.user_id FROM workspace_members o
+				WHERE o.workspace_id=m.workspace_id AND o.user_id<>?1
+				ORDER BY CASE o.role WHEN 'admin' THEN 0 WHEN 'member' THEN 1 ELSE 2 END, o.joined_at
+				LIMIT 1
+			)
+			FROM workspace_members m
+			WHERE m.user_id=?1 AND m.role='owner' AND NOT EXISTS (
+				SELECT 1 FROM workspace_members x WHERE x.workspace_id=m.workspace_id AND x.user_id<>?1 AND x.role='owner'
+			)
+		)
+	`, id)
+	return err
+}
+
+// deleteHistoryTx removes the events and revisions of the todos whose column matches value,
+// deleted todos included. The history keeps bare todo ids, so the foreign keys do not reach it.
+func deleteHistoryTx(ctx context.Context, tx *sql.Tx, column string, value any) error {
+	todoIds := "SELECT todo_id FROM todo_revisions WHERE json_extract(snapshot, '$." + column + "')=?1 " +
+		"UNION SELECT id FROM todos WHERE " + column + "=?1"
+	for _, table := range []string{"todo_events", "todo_revisions"} {
+		_, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE todo_id IN ("+todoIds+")", value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
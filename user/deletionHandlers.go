@@ -0,0 +1,127 @@
+package user
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"time"
+	"todo-api/config"
+	"todo-api/utils"
+)
+
+// DeleteMeHandler disables the account after checking the password, see confirmPassword, and ends all its sessions.
+// The account and its data are removed when the grace period is over, until then it can be restored.
+func DeleteMeHandler(config *config.AppConfig, storage Storage, revocations *RevocationList, validator *utils.AppValidator) fiber.Handler {
+	type DeleteMeRequest struct {
+		Password string `json:"password" validate:"lte=255"`
+	}
+
+	type DeleteMeResponse struct {
+		RestorableUntil *time.Time `json:"restorable_until,omitempty"`
+		PurgeAfter      time.Time  `json:"purge_after"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		data := DeleteMeRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		user, err := storage.GetById(ctx.Context(), FromContext(ctx).Id)
+		if err != nil {
+			return err
+		}
+		err = confirmPassword(ctx, storage, user, data.Password)
+		if err != nil {
+			return err
+		}
+
+		deletedAt, err := storage.ScheduleDeletion(ctx.Context(), user.Id)
+		if err != nil {
+			if errors.Is(err, DeletionScheduled) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			return err
+		}
+		err = revocations.RevokeAll(ctx.Context(), user.Id)
+		if err != nil {
+			return err
+		}
+
+		response := DeleteMeResponse{PurgeAfter: deletedAt.Add(purgeAfter(config.AccountDeletionGrace, config.AccessTokenTTL))}
+		if config.AccountDeletionGrace > 0 {
+			restorableUntil := deletedAt.Add(config.AccountDeletionGrace)
+			response.RestorableUntil = &restorableUntil
+		}
+		return ctx.JSON(response)
+	}
+}
+
+// RestoreAccountHandler cancels the deletion of an account within the grace period, the user can log in again after it.
+func RestoreAccountHandler(config *config.AppConfig, storage Storage, validator *utils.AppValidator) fiber.Handler {
+	type RestoreAccountRequest struct {
+		Email    string `json:"email" validate:"required,email"`
+		Password string `json:"password" validate:"required,lte=255"`
+	}
+
+	type RestoreAccountResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		data := RestoreAccountRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		err = reserveLoginAttempt(ctx, storage, data.Email)
+		if err != nil {
+			return err
+		}
+
+		user, err := storage.GetUserByEmail(ctx.Context(), data.Email)
+		if err != nil && !errors.Is(err, NotFound) {
+			return err
+		}
+		passwordHash := user.passwordHash
+		if errors.Is(err, NotFound) {
+			passwordHash = dummyPasswordHash()
+		}
+		isValid, err := utils.ComparePassword(data.Password, passwordHash)
+		if err != nil {
+			return err
+		}
+		if !isValid || user.Id == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "wrong email or password")
+		}
+		err = releaseLoginAttempt(ctx, storage, data.Email)
+		if err != nil {
+			return err
+		}
+
+		if user.deletedAt == nil {
+			return fiber.NewError(fiber.StatusBadRequest, "account is not scheduled for deletion")
+		}
+		err = storage.RestoreDeleted(ctx.Context(), user.Id, time.Now().Add(-config.AccountDeletionGrace))
+		if err != nil {
+			if errors.Is(err, RestoreExpired) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			return err
+		}
+		err = clearLoginFailures(ctx, storage, user.Email)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(RestoreAccountResponse{})
+	}
+}
@@ -0,0 +1,77 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	jwtware "jwt"
+	"strings"
+	"time"
+	"todo-api/config"
+	"todo-api/utils"
+)
+
+// dpopContextKey holds the thumbprint of the key of a DPoP proof sent to an endpoint issuing tokens.
+const dpopContextKey = "dpop_jkt"
+
+// NewDPoPConfig returns how DPoP proofs are verified, see RFC 9449. Proofs are remembered in the database,
+// since in prefork mode every process would hold its own in-memory cache.
+func NewDPoPConfig(config *config.AppConfig, storage Storage) *jwtware.DPoPConfig {
+	return &jwtware.DPoPConfig{
+		BaseURL:     strings.TrimRight(config.PublicUrl, "/"),
+		ReplayCache: dpopReplayCache{storage: storage},
+	}
+}
+
+type dpopReplayCache struct {
+	storage Storage
+}
+
+func (c dpopReplayCache) Add(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	return c.storage.UseDpopProof(ctx, utils.HashToken(id), expiresAt)
+}
+
+// UseDpopProof records the id of a proof and reports whether it was not used before.
+func (s SqliteUsersStorage) UseDpopProof(ctx context.Context, jtiHash string, expiresAt time.Time) (bool, error) {
+	result, err := s.db.ExecContext(ctx,
+		"INSERT INTO dpop_proofs (jti_hash, expires_at) VALUES (?, ?) ON CONFLICT (jti_hash) DO NOTHING", jtiHash, expiresAt.UTC())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// DPoPProofMiddleware verifies the DPoP proof sent to an endpoint issuing tokens, if any, and passes invalid proofs
+// to the errorHandler. The session started by the request is then bound to the key of the proof, see deviceFromRequest.
+func DPoPProofMiddleware(dpop *jwtware.DPoPConfig, errorHandler fiber.ErrorHandler) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if len(c.Request().Header.PeekAll("DPoP")) == 0 {
+			return c.Next()
+		}
+
+		thumbprint, err := jwtware.VerifyDPoPProof(c, *dpop, "")
+		if err != nil {
+			if errors.Is(err, jwtware.ErrDPoPProof) {
+				return errorHandler(c, err)
+			}
+			return err
+		}
+		c.Locals(dpopContextKey, thumbprint)
+		return c.Next()
+	}
+}
+
+// dpopThumbprint returns the thumbprint of the key of the verified DPoP proof of the request.
+func dpopThumbprint(c fiber.Ctx) string {
+	thumbprint, _ := c.Locals(dpopContextKey).(string)
+	return thumbprint
+}
+
+// provesDpopKey reports whether the request holds the key the session is bound to, if any.
+func provesDpopKey(c fiber.Ctx, session Session) bool {
+	return session.DpopJkt == "" || session.DpopJkt == dpopThumbprint(c)
+}
@@ -0,0 +1,192 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"time"
+)
+
+// oidcStateTTL is how long a user has to log in at the provider.
+const oidcStateTTL = 10 * time.Minute
+
+var (
+	IdentityNotFound      = errors.New("identity not found")
+	IdentityAlreadyLinked = errors.New("identity is already linked to an account")
+	OidcStateInvalid      = errors.New("invalid or expired login state")
+)
+
+// Identity links an account of an OpenID Connect provider to a user.
+type Identity struct {
+	Id          string     `json:"id"`
+	UserId      Id         `json:"-"`
+	Issuer      string     `json:"issuer"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// OidcState is a login at the provider in progress, stored until the provider redirects back.
+type OidcState struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	DeviceName   string
+	// LinkUserId is set when a logged-in user links the identity instead of logging in
+	LinkUserId Id
+	ExpiresAt  time.Time
+}
+
+// hasPassword reports whether the user can log in with a password,
+// users provisioned by an OpenID Connect login have none until they reset it.
+func (u User) hasPassword() bool {
+	return u.passwordHash != ""
+}
+
+func (s SqliteUsersStorage) CreateOidcState(ctx context.Context, state OidcState) error {
+	var linkUserId *Id
+	if state.LinkUserId != "" {
+		linkUserId = &state.LinkUserId
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO oidc_states (state_hash, nonce, code_verifier, device_name, link_user_id, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, state.StateHash, state.Nonce, state.CodeVerifier, state.DeviceName, linkUserId, state.ExpiresAt.UTC())
+	return err
+}
+
+// TakeOidcState returns and removes the state, so the callback of a login can run once.
+func (s SqliteUsersStorage) TakeOidcState(ctx context.Context, stateHash string) (OidcState, error) {
+	var state OidcState
+	var linkUserId sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		DELETE FROM oidc_states WHERE state_hash=? AND expires_at>?
+		RETURNING state_hash, nonce, code_verifier, device_name, link_user_id, expires_at
+	`, stateHash, time.Now().UTC()).Scan(&state.StateHash, &state.Nonce, &state.CodeVerifier, &state.DeviceName, &linkUserId, &state.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OidcState{}, OidcStateInvalid
+		}
+		return OidcState{}, err
+	}
+	state.LinkUserId = Id(linkUserId.String)
+	return state, nil
+}
+
+func (s SqliteUsersStorage) GetIdentity(ctx context.Context, issuer string, subject string) (Identity, error) {
+	rows, err := s.queryIdentities(ctx, "issuer=? AND subject=?", issuer, subject)
+	if err != nil {
+		return Identity{}, err
+	}
+	if len(rows) == 0 {
+		return Identity{}, IdentityNotFound
+	}
+	return rows[0], nil
+}
+
+func (s SqliteUsersStorage) GetIdentities(ctx context.Context, userId Id) ([]Identity, error) {
+	return s.queryIdentities(ctx, "user_id=? ORDER BY id", userId)
+}
+
+func (s SqliteUsersStorage) queryIdentities(ctx context.Context, where string, args ...any) ([]Identity, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, user_id, issuer, subject, email, created_at, last_login_at FROM identities WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var identity Identity
+		var lastLoginAt sql.NullTime
+		err = rows.Scan(&identity.Id, &identity.UserId, &identity.Issuer, &identity.Subject, &identity.Email,
+			&identity.CreatedAt, &lastLoginAt)
+		if err != nil {
+			return nil, err
+		}
+		if lastLoginAt.Valid {
+			identity.LastLoginAt = &lastLoginAt.Time
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (s SqliteUsersStorage) CreateIdentity(ctx context.Context, userId Id, issuer string, subject string, email string) (Identity, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer tx.Rollback()
+
+	if err = createIdentityTx(ctx, tx, userId, issuer, subject, email); err != nil {
+		return Identity{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return Identity{}, err
+	}
+	return s.GetIdentity(ctx, issuer, subject)
+}
+
+// CreateExternalUser provisions a user without a password for the identity of a first OpenID Connect login.
+func (s SqliteUsersStorage) CreateExternalUser(ctx context.Context, email string, name string, emailVerified bool, issuer string, subject string) (User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	userId := Id(ulid.Make().String())
+	var verifiedAt *time.Time
+	if emailVerified {
+		now := time.Now().UTC()
+		verifiedAt = &now
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO users (id, email, name, password_hash, email_verified_at) VALUES (?, ?, ?, '', ?)",
+		userId, email, name, verifiedAt)
+	if err != nil {
+		return User{}, mapError(err)
+	}
+	if err = createIdentityTx(ctx, tx, userId, issuer, subject, email); err != nil {
+		return User{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return s.GetById(ctx, userId)
+}
+
+func createIdentityTx(ctx context.Context, tx *sql.Tx, userId Id, issuer string, subject string, email string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO identities (id, user_id, issuer, subject, email, created_at, last_login_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, ulid.Make().String(), userId, issuer, subject, email, time.Now().UTC(), time.Now().UTC())
+	if err = mapError(err); errors.Is(err, AlreadyExists) {
+		return IdentityAlreadyLinked
+	}
+	return err
+}
+
+// TouchIdentity records a login with the identity and the email the provider has for it now.
+func (s SqliteUsersStorage) TouchIdentity(ctx context.Context, id string, email string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE identities SET last_login_at=?, email=? WHERE id=?", time.Now().UTC(), email, id)
+	return err
+}
+
+func (s SqliteUsersStorage) DeleteIdentity(ctx context.Context, userId Id, id string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM identities WHERE id=? AND user_id=?", id, userId)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return IdentityNotFound
+	}
+	return nil
+}
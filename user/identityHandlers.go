@@ -0,0 +1,280 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"log"
+	"strings"
+	"time"
+	"todo-api/config"
+	"todo-api/oidc"
+	"todo-api/utils"
+)
+
+// startOidcLogin stores a new login state, binds it to the browser and returns the url of the provider to send the user to.
+func startOidcLogin(ctx fiber.Ctx, config *config.AppConfig, storage Storage, provider *oidc.Provider, deviceName string, linkUserId Id) (string, error) {
+	state, err := utils.NewRandomToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := utils.NewRandomToken()
+	if err != nil {
+		return "", err
+	}
+	codeVerifier, err := utils.NewRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	authorizationUrl, err := provider.AuthorizationUrl(ctx.Context(), state, nonce, codeVerifier)
+	if err != nil {
+		return "", err
+	}
+
+	oidcState := OidcState{
+		StateHash:    utils.HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		DeviceName:   deviceName,
+		LinkUserId:   linkUserId,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}
+	err = storage.CreateOidcState(ctx.Context(), oidcState)
+	if err != nil {
+		return "", err
+	}
+	setOidcStateCookie(ctx, config, oidcState.StateHash, oidcState.ExpiresAt)
+
+	return authorizationUrl, nil
+}
+
+// OidcLoginHandler redirects the browser to the provider, which redirects back to OidcCallbackHandler.
+func OidcLoginHandler(config *config.AppConfig, storage Storage, provider *oidc.Provider, validator *utils.AppValidator) fiber.Handler {
+	type OidcLoginRequest struct {
+		DeviceName string `query:"device_name" validate:"lte=255"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		req := OidcLoginRequest{}
+		err := ctx.Bind().Query(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err = validator.Validate(req); err != nil {
+			return err
+		}
+
+		authorizationUrl, err := startOidcLogin(ctx, config, storage, provider, req.DeviceName, "")
+		if err != nil {
+			log.Printf("oidc login: %v", err)
+			return fiber.NewError(fiber.StatusBadGateway, "the identity provider is not available")
+		}
+
+		return ctx.Redirect().Status(fiber.StatusFound).To(authorizationUrl)
+	}
+}
+
+// LinkIdentityHandler starts a login at the provider that links the identity to the current user.
+// The authorization url is returned instead of a redirect, as the request carries the access token.
+func LinkIdentityHandler(config *config.AppConfig, storage Storage, provider *oidc.Provider) fiber.Handler {
+	type LinkIdentityResponse struct {
+		AuthorizationUrl string `json:"authorization_url"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		authorizationUrl, err := startOidcLogin(ctx, config, storage, provider, "", FromContext(ctx).Id)
+		if err != nil {
+			log.Printf("oidc link: %v", err)
+			return fiber.NewError(fiber.StatusBadGateway, "the identity provider is not available")
+		}
+
+		return ctx.JSON(LinkIdentityResponse{AuthorizationUrl: authorizationUrl})
+	}
+}
+
+// OidcCallbackHandler completes a login at the provider. It logs the user of the identity in,
+// provisioning a new user on the first login, or links the identity when the login was started by LinkIdentityHandler.
+func OidcCallbackHandler(config *config.AppConfig, storage Storage, keyring *Keyring, provider *oidc.Provider) fiber.Handler {
+	type OidcCallbackRequest struct {
+		Code             string `query:"code"`
+		State            string `query:"state"`
+		Error            string `query:"error"`
+		ErrorDescription string `query:"error_description"`
+	}
+
+	type OidcLoginResponse Tokens
+
+	type MfaRequiredResponse mfaRequired
+
+	type LinkResponse Identity
+
+	return func(ctx fiber.Ctx) error {
+		req := OidcCallbackRequest{}
+		err := ctx.Bind().Query(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if req.Error != "" {
+			return fiber.NewError(fiber.StatusBadRequest, strings.TrimSpace("login at the identity provider failed: "+req.Error+" "+req.ErrorDescription))
+		}
+		if req.Code == "" || req.State == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "code and state are required")
+		}
+
+		stateHash := utils.HashToken(req.State)
+		err = checkOidcStateCookie(ctx, config, stateHash)
+		if err != nil {
+			return err
+		}
+		state, err := storage.TakeOidcState(ctx.Context(), stateHash)
+		if err != nil {
+			if errors.Is(err, OidcStateInvalid) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			return err
+		}
+
+		claims, err := provider.Exchange(ctx.Context(), req.Code, state.CodeVerifier, state.Nonce)
+		if err != nil {
+			log.Printf("oidc callback: %v", err)
+			if errors.Is(err, oidc.InvalidIdToken) {
+				return fiber.NewError(fiber.StatusUnauthorized, oidc.InvalidIdToken.Error())
+			}
+			return fiber.NewError(fiber.StatusBadGateway, "the login could not be completed at the identity provider")
+		}
+
+		if state.LinkUserId != "" {
+			identity, err := storage.CreateIdentity(ctx.Context(), state.LinkUserId, provider.Issuer(), claims.Subject, claims.Email)
+			if err != nil {
+				if errors.Is(err, IdentityAlreadyLinked) {
+					return fiber.NewError(fiber.StatusConflict, err.Error())
+				}
+				return err
+			}
+			return ctx.JSON(LinkResponse(identity))
+		}
+
+		user, err := identityUser(ctx.Context(), config, storage, provider, claims)
+		if err != nil {
+			return err
+		}
+		if user.deletedAt != nil {
+			return fiber.NewError(fiber.StatusForbidden, "account is scheduled for deletion, restore it at /account/restore")
+		}
+		if user.disabledAt != nil {
+			return fiber.NewError(fiber.StatusForbidden, AccountDisabled.Error())
+		}
+
+		// the provider proved the first factor only
+		if user.TwoFactor {
+			challenge, err := newMfaChallenge(ctx.Context(), storage, user.Id, state.DeviceName)
+			if err != nil {
+				return err
+			}
+			return ctx.JSON(MfaRequiredResponse(challenge))
+		}
+
+		tokens, err := issueTokens(ctx.Context(), config, storage, keyring, user, deviceFromRequest(ctx, state.DeviceName))
+		if err != nil {
+			return err
+		}
+
+		tokens, err = setAuthCookies(ctx, config, tokens)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(OidcLoginResponse(tokens))
+	}
+}
+
+// identityUser returns the user linked to the identity of the claims, provisioning one on the first login.
+// An existing account with the same email is never taken over, its owner has to link the identity.
+func identityUser(ctx context.Context, config *config.AppConfig, storage Storage, provider *oidc.Provider, claims oidc.Claims) (User, error) {
+	identity, err := storage.GetIdentity(ctx, provider.Issuer(), claims.Subject)
+	if err == nil {
+		err = storage.TouchIdentity(ctx, identity.Id, claims.Email)
+		if err != nil {
+			return User{}, err
+		}
+		return storage.GetById(ctx, identity.UserId)
+	}
+	if !errors.Is(err, IdentityNotFound) {
+		return User{}, err
+	}
+
+	if !config.OidcAutoProvision {
+		return User{}, fiber.NewError(fiber.StatusForbidden, "no account is linked to this identity")
+	}
+	if claims.Email == "" {
+		return User{}, fiber.NewError(fiber.StatusBadRequest, "the identity provider did not share an email address")
+	}
+	// an unverified address could be anyone's, and the account would receive their mail
+	if !claims.EmailVerified {
+		return User{}, fiber.NewError(fiber.StatusForbidden, "the identity provider has not verified the email address")
+	}
+
+	name := claims.Name
+	if len(name) < 2 {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	user, err := storage.CreateExternalUser(ctx, claims.Email, name, claims.EmailVerified, provider.Issuer(), claims.Subject)
+	if err != nil {
+		if errors.Is(err, AlreadyExists) {
+			return User{}, fiber.NewError(fiber.StatusConflict,
+				"an account with this email already exists, log in and link the identity at POST /me/identities")
+		}
+		if errors.Is(err, IdentityAlreadyLinked) {
+			return User{}, fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+		return User{}, err
+	}
+	return user, nil
+}
+
+func GetIdentitiesHandler(storage Storage) fiber.Handler {
+	type GetIdentitiesResponse []Identity
+
+	return func(ctx fiber.Ctx) error {
+		identities, err := storage.GetIdentities(ctx.Context(), FromContext(ctx).Id)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(GetIdentitiesResponse(identities))
+	}
+}
+
+// UnlinkIdentityHandler removes a linked identity. The last one of a user without a password stays,
+// otherwise the user could not log in anymore.
+func UnlinkIdentityHandler(storage Storage) fiber.Handler {
+	type UnlinkIdentityResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user, err := storage.GetById(ctx.Context(), FromContext(ctx).Id)
+		if err != nil {
+			return err
+		}
+		if !user.hasPassword() {
+			identities, err := storage.GetIdentities(ctx.Context(), user.Id)
+			if err != nil {
+				return err
+			}
+			if len(identities) <= 1 {
+				return fiber.NewError(fiber.StatusBadRequest, "set a password before removing the last identity, see /password/forgot")
+			}
+		}
+
+		err = storage.DeleteIdentity(ctx.Context(), user.Id, ctx.Params("id"))
+		if err != nil {
+			if errors.Is(err, IdentityNotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return err
+		}
+
+		return ctx.JSON(UnlinkIdentityResponse{})
+	}
+}
@@ -0,0 +1,245 @@
+package user
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	jwtware "jwt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"todo-api/config"
+)
+
+// kidDateLayout is the optional prefix of a kid that schedules when the key starts signing tokens.
+const kidDateLayout = "20060102"
+
+type signingKey struct {
+	kid         string
+	method      jwt.SigningMethod
+	private     crypto.Signer
+	activatesAt time.Time
+}
+
+// Keyring signs access tokens and provides the keys to verify them.
+//
+// Without a keys directory tokens are signed with the shared JwtSecret using SigningMethod.
+// Otherwise every <kid>.pem private key in the directory is published in the JWKS and verifies tokens once active,
+// and tokens are signed with the active key with the greatest kid. Kids starting with a YYYYMMDD date
+// become active on that day (UTC), so the next key can be published ahead of the rotation.
+//
+// Tokens carry the configured issuer and audience, which are checked on verification like the required claims.
+type Keyring struct {
+	secret   []byte
+	keys     []signingKey
+	issuer   string
+	audience string
+	leeway   time.Duration
+}
+
+func NewKeyring(config *config.AppConfig) (*Keyring, error) {
+	keyring := &Keyring{issuer: config.JwtIssuer, audience: config.JwtAudience, leeway: config.JwtLeeway}
+	if config.JwtKeysDir == "" {
+		keyring.secret = []byte(config.JwtSecret)
+		return keyring, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(config.JwtKeysDir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.pem keys in %s", config.JwtKeysDir)
+	}
+
+	for _, path := range paths {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %w", path, err)
+		}
+		keyring.keys = append(keyring.keys, key)
+	}
+	sort.Slice(keyring.keys, func(i, j int) bool {
+		return keyring.keys[i].kid < keyring.keys[j].kid
+	})
+
+	if _, err = keyring.current(time.Now()); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+func loadSigningKey(path string) (signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return signingKey{}, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return signingKey{}, fmt.Errorf("unsupported PEM block %s", block.Type)
+	}
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	key := signingKey{kid: strings.TrimSuffix(filepath.Base(path), ".pem")}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private = jwt.SigningMethodRS256, private
+	case *ecdsa.PrivateKey:
+		switch private.Curve {
+		case elliptic.P256():
+			key.method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.method = jwt.SigningMethodES384
+		default:
+			return signingKey{}, errors.New("unsupported elliptic curve, use P-256 or P-384")
+		}
+		key.private = private
+	case ed25519.PrivateKey:
+		key.method, key.private = jwt.SigningMethodEdDSA, private
+	default:
+		return signingKey{}, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	if len(key.kid) >= len(kidDateLayout) {
+		if activatesAt, err := time.Parse(kidDateLayout, key.kid[:len(kidDateLayout)]); err == nil {
+			key.activatesAt = activatesAt
+		}
+	}
+	return key, nil
+}
+
+// current returns the key that signs tokens at the given time.
+func (k *Keyring) current(now time.Time) (signingKey, error) {
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		if !k.keys[i].activatesAt.After(now) {
+			return k.keys[i], nil
+		}
+	}
+	return signingKey{}, errors.New("no active jwt key, every key is scheduled for the future")
+}
+
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	if k.secret != nil {
+		return jwt.NewWithClaims(SigningMethod, claims).SignedString(k.secret)
+	}
+
+	key, err := k.current(time.Now())
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// verificationConfig sets the keys and claims accepted by the jwt middleware.
+func (k *Keyring) verificationConfig(config *jwtware.Config) {
+	config.Claims = &accessClaims{}
+	config.Issuer = k.issuer
+	config.Audience = k.audience
+	config.Leeway = k.leeway
+	config.RequiredClaims = requiredClaims
+
+	if k.secret != nil {
+		config.SigningKey = jwtware.SigningKey{JWTAlg: SigningMethod.Alg(), Key: k.secret}
+		return
+	}
+
+	config.KeyFunc = k.verificationKey
+}
+
+// verificationKey returns the public key of the kid in the token header. A key scheduled for the future
+// is published ahead of the rotation but verifies nothing before its day, give or take the leeway.
+func (k *Keyring) verificationKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range k.keys {
+		if key.kid != kid {
+			continue
+		}
+		if key.activatesAt.After(time.Now().Add(k.leeway)) {
+			return nil, fmt.Errorf("jwt key %s is not active yet", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("jwt key %s does not sign with %s", kid, token.Method.Alg())
+		}
+		return key.private.Public(), nil
+	}
+	return nil, fmt.Errorf("unknown jwt key %q", kid)
+}
+
+// parse verifies a token signed by the keyring outside of the jwt middleware and returns its claims.
+func (k *Keyring) parse(token string) (*accessClaims, error) {
+	config := jwtware.Config{}
+	k.verificationConfig(&config)
+	parsed, err := jwtware.Parse(config, token)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Claims.(*accessClaims), nil
+}
+
+// JWK is a public key in the JSON Web Key format, see RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS returns the public keys of the keyring. It is empty when tokens are signed with the shared secret.
+func (k *Keyring) JWKS() []JWK {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	jwks := make([]JWK, 0, len(k.keys))
+	for _, key := range k.keys {
+		jwk := JWK{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encode(public.N.Bytes())
+			jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = encode(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = encode(public.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = encode(public)
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,167 @@
+package user
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"todo-api/config"
+)
+
+const (
+	oldKid     = "20240101-old"
+	currentKid = "20250101-current"
+	nextKid    = "29990101-next"
+)
+
+// writeKey stores a private key as <kid>.pem in the keys directory.
+func writeKey(t *testing.T, dir string, kid string, key crypto.Signer) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0o600))
+}
+
+func newTestKeyring(t *testing.T, dir string) *Keyring {
+	keyring, err := NewKeyring(&config.AppConfig{JwtKeysDir: dir, JwtIssuer: "todo-api"})
+	assert.NoError(t, err)
+	return keyring
+}
+
+func sign(t *testing.T, keyring *Keyring) string {
+	token, err := GetToken(User{Id: "user", Email: "user@example.com", Name: "User"}, "session", "", keyring, time.Minute)
+	assert.NoError(t, err)
+	return token
+}
+
+func decode(t *testing.T, value string) []byte {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	assert.NoError(t, err)
+	return decoded
+}
+
+func kid(t *testing.T, token string) any {
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &accessClaims{})
+	assert.NoError(t, err)
+	return parsed.Header["kid"]
+}
+
+// keys generates the keys of the tests: an old and a current one, and one scheduled for the future.
+func keys(t *testing.T) (crypto.Signer, crypto.Signer, crypto.Signer) {
+	old, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	current, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, next, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	return old, current, next
+}
+
+func TestKeyringSignsWithTheActiveKey(t *testing.T) {
+	dir := t.TempDir()
+	old, current, next := keys(t)
+	writeKey(t, dir, oldKid, old)
+	writeKey(t, dir, currentKid, current)
+	writeKey(t, dir, nextKid, next)
+	keyring := newTestKeyring(t, dir)
+
+	token := sign(t, keyring)
+	assert.Equal(t, currentKid, kid(t, token), "the greatest kid that is active")
+	claims, err := keyring.parse(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", claims.Subject)
+	assert.Equal(t, "todo-api", claims.Issuer)
+}
+
+func TestKeyringVerifiesThePreviousKey(t *testing.T) {
+	dir := t.TempDir()
+	old, current, _ := keys(t)
+	writeKey(t, dir, oldKid, old)
+	before := newTestKeyring(t, dir)
+	issuedBefore := sign(t, before)
+	assert.Equal(t, oldKid, kid(t, issuedBefore))
+
+	writeKey(t, dir, currentKid, current)
+	rotated := newTestKeyring(t, dir)
+	issuedAfter := sign(t, rotated)
+	assert.Equal(t, currentKid, kid(t, issuedAfter))
+
+	_, err := rotated.parse(issuedBefore)
+	assert.NoError(t, err, "token of the previous key after the rotation")
+	_, err = before.parse(issuedAfter)
+	assert.Error(t, err, "token of a key the keyring does not have")
+}
+
+func TestKeyringRejectsUnknownAndInactiveKeys(t *testing.T) {
+	dir := t.TempDir()
+	_, current, next := keys(t)
+	writeKey(t, dir, currentKid, current)
+	writeKey(t, dir, nextKid, next)
+	keyring := newTestKeyring(t, dir)
+	claims := userClaims(keyring, User{Id: "user", Email: "user@example.com", Name: "User"}, "session", "", time.Minute)
+
+	signWith := func(method jwt.SigningMethod, kid string, key crypto.Signer) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+		return signed
+	}
+
+	_, err := keyring.parse(signWith(jwt.SigningMethodEdDSA, nextKid, next))
+	assert.Error(t, err, "key scheduled for the future")
+	_, err = keyring.parse(signWith(jwt.SigningMethodES256, "unknown", current))
+	assert.Error(t, err, "unknown kid")
+	_, err = keyring.parse(signWith(jwt.SigningMethodES256, "", current))
+	assert.Error(t, err, "missing kid")
+	_, err = keyring.parse(signWith(jwt.SigningMethodES256, currentKid, current))
+	assert.NoError(t, err, "the same token with the active kid")
+}
+
+func TestJWKSHasOnlyPublicKeys(t *testing.T) {
+	dir := t.TempDir()
+	old, current, next := keys(t)
+	writeKey(t, dir, oldKid, old)
+	writeKey(t, dir, currentKid, current)
+	writeKey(t, dir, nextKid, next)
+
+	jwks := newTestKeyring(t, dir).JWKS()
+	byKid := map[string]JWK{}
+	for _, jwk := range jwks {
+		byKid[jwk.Kid] = jwk
+	}
+	assert.Len(t, byKid, 3, "the scheduled key is published ahead")
+	assert.Equal(t, "RSA", byKid[oldKid].Kty)
+	assert.Equal(t, "RS256", byKid[oldKid].Alg)
+	assert.Equal(t, "EC", byKid[currentKid].Kty)
+	assert.Equal(t, "P-256", byKid[currentKid].Crv)
+	assert.Equal(t, "OKP", byKid[nextKid].Kty)
+
+	assert.Equal(t, old.(*rsa.PrivateKey).N.Bytes(), decode(t, byKid[oldKid].N))
+	assert.Equal(t, []byte(next.Public().(ed25519.PublicKey)), decode(t, byKid[nextKid].X))
+	ecKey := current.(*ecdsa.PrivateKey)
+	assert.Equal(t, ecKey.X.FillBytes(make([]byte, 32)), decode(t, byKid[currentKid].X))
+	assert.Equal(t, ecKey.Y.FillBytes(make([]byte, 32)), decode(t, byKid[currentKid].Y))
+
+	encoded, err := json.Marshal(jwks)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(encoded), `"d"`, "no private parts")
+}
+
+func TestJWKSIsEmptyWithTheSharedSecret(t *testing.T) {
+	keyring, err := NewKeyring(&config.AppConfig{JwtSecret: "secret"})
+	assert.NoError(t, err)
+	assert.Empty(t, keyring.JWKS())
+}
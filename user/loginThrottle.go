@@ -0,0 +1,224 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"github.com/gofiber/fiber/v3"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"todo-api/utils"
+)
+
+// loginFailureWindow is how long failed logins are remembered, counting restarts after a quiet window.
+const loginFailureWindow = time.Hour
+
+// throttlePolicy slows down repeated failed logins: after freeFailures every further failure
+// doubles the wait before the next attempt, starting at a second, and after lockAfter failures
+// the key is locked for lockFor.
+type throttlePolicy struct {
+	freeFailures int
+	maxDelay     time.Duration
+	lockAfter    int
+	lockFor      time.Duration
+}
+
+var (
+	accountThrottle = throttlePolicy{freeFailures: 3, maxDelay: 5 * time.Minute, lockAfter: 10, lockFor: 15 * time.Minute}
+	// many users can share an ip behind a NAT, so it gets more attempts than a single account
+	ipThrottle = throttlePolicy{freeFailures: 20, maxDelay: 5 * time.Minute, lockAfter: 100, lockFor: time.Hour}
+)
+
+// LoginFailure counts the failed logins of an account or an ip.
+type LoginFailure struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+}
+
+func (p throttlePolicy) blockedUntil(failure LoginFailure) time.Time {
+	if failure.Failures >= p.lockAfter {
+		return failure.LastFailureAt.Add(p.lockFor)
+	}
+	if failure.Failures < p.freeFailures {
+		return time.Time{}
+	}
+	delay := time.Duration(math.Pow(2, float64(failure.Failures-p.freeFailures))) * time.Second
+	return failure.LastFailureAt.Add(min(delay, p.maxDelay))
+}
+
+func accountThrottleKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// dummyPasswordHash is compared against when the email is unknown,
+// so a login for a missing account takes as long as a wrong password.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := utils.HashPassword("dummy password")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// loginReserveRetries bounds how often a reservation is retried when concurrent attempts change the counter.
+const loginReserveRetries = 5
+
+// reserveLoginAttempt counts the attempt as a failure of the account and the client ip before the password
+// is hashed, and rejects it if either failed too often recently. Counting up front keeps concurrent guesses
+// from all passing the check before any of them is recorded; releaseLoginAttempt gives the attempt back.
+func reserveLoginAttempt(ctx fiber.Ctx, storage Storage, email string) error {
+	accountKey, ipKey := accountThrottleKey(email), ipThrottleKey(ctx.IP())
+
+	blockedUntil, err := reserveAttempt(ctx.Context(), storage, accountKey, accountThrottle)
+	if err != nil {
+		return err
+	}
+	if blockedUntil.IsZero() {
+		blockedUntil, err = reserveAttempt(ctx.Context(), storage, ipKey, ipThrottle)
+		if err != nil {
+			return err
+		}
+		if !blockedUntil.IsZero() {
+			err = storage.ReleaseLoginFailure(ctx.Context(), accountKey)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !blockedUntil.IsZero() {
+		wait := max(time.Until(blockedUntil), time.Second)
+		ctx.Set(fiber.HeaderRetryAfter, fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+		return fiber.NewError(fiber.StatusTooManyRequests, "too many failed logins, try again later")
+	}
+	return nil
+}
+
+// reserveAttempt counts a failure of the key unless it is blocked, then it returns when the block ends.
+// The counter is only increased if nobody changed it since it was read.
+func reserveAttempt(ctx context.Context, storage Storage, key string, policy throttlePolicy) (time.Time, error) {
+	for range loginReserveRetries {
+		failures, err := storage.GetLoginFailures(ctx, key)
+		if err != nil {
+			return time.Time{}, err
+		}
+		var failure LoginFailure
+		if len(failures) > 0 {
+			failure = failures[0]
+		}
+		if until := policy.blockedUntil(failure); until.After(time.Now()) {
+			return until, nil
+		}
+
+		ok, err := storage.RecordLoginFailure(ctx, key, failure.Failures, loginFailureWindow)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if ok {
+			return time.Time{}, nil
+		}
+	}
+	// the counter keeps changing, there are many attempts at the same time
+	return time.Now().Add(time.Second), nil
+}
+
+// releaseLoginAttempt gives back the attempt reserved by reserveLoginAttempt once the password or code was right.
+func releaseLoginAttempt(ctx fiber.Ctx, storage Storage, email string) error {
+	err := storage.ReleaseLoginFailure(ctx.Context(), accountThrottleKey(email))
+	if err != nil {
+		return err
+	}
+	return storage.ReleaseLoginFailure(ctx.Context(), ipThrottleKey(ctx.IP()))
+}
+
+// checkPassword compares the password of a signed-in user before a sensitive change. It is throttled like a login,
+// so a stolen access token cannot be used to guess the password.
+func checkPassword(ctx fiber.Ctx, storage Storage, user User, password string) error {
+	err := reserveLoginAttempt(ctx, storage, user.Email)
+	if err != nil {
+		return err
+	}
+	isValid, err := utils.ComparePassword(password, user.passwordHash)
+	if err != nil {
+		return err
+	}
+	if !isValid {
+		return fiber.NewError(fiber.StatusBadRequest, "wrong password")
+	}
+	return releaseLoginAttempt(ctx, storage, user.Email)
+}
+
+// clearLoginFailures resets the account after a successful login.
+// The ip counter is kept, otherwise one valid account would let a client guess others without limit.
+func clearLoginFailures(ctx fiber.Ctx, storage Storage, email string) error {
+	return storage.ClearLoginFailures(ctx.Context(), accountThrottleKey(email))
+}
+
+// UnlockAccount lifts the lockout of an account after too many failed logins.
+func UnlockAccount(ctx context.Context, storage Storage, email string) error {
+	return storage.ClearLoginFailures(ctx, accountThrottleKey(email))
+}
+
+// GetLoginFailures returns the failures of the keys that are still within loginFailureWindow.
+func (s SqliteUsersStorage) GetLoginFailures(ctx context.Context, keys ...string) ([]LoginFailure, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	args := []any{time.Now().Add(-loginFailureWindow).UTC()}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT key, failures, last_failure_at FROM login_failures WHERE last_failure_at>? AND key IN (?"+
+			strings.Repeat(", ?", len(keys)-1)+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failures []LoginFailure
+	for rows.Next() {
+		var failure LoginFailure
+		if err = rows.Scan(&failure.Key, &failure.Failures, &failure.LastFailureAt); err != nil {
+			return nil, err
+		}
+		failures = append(failures, failure)
+	}
+	return failures, rows.Err()
+}
+
+// RecordLoginFailure counts a failure of the key, starting over if the last one is older than window.
+// It only counts if the key had previous failures within window, and reports whether it did.
+func (s SqliteUsersStorage) RecordLoginFailure(ctx context.Context, key string, previous int, window time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO login_failures (key, failures, last_failure_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures=CASE WHEN last_failure_at>? THEN failures+1 ELSE 1 END,
+			last_failure_at=excluded.last_failure_at
+		WHERE (CASE WHEN last_failure_at>? THEN failures ELSE 0 END)=?
+	`, key, now, now.Add(-window), now.Add(-window), previous)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// ReleaseLoginFailure takes back one failure of the key.
+func (s SqliteUsersStorage) ReleaseLoginFailure(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE login_failures SET failures=failures-1 WHERE key=? AND failures>0", key)
+	return err
+}
+
+func (s SqliteUsersStorage) ClearLoginFailures(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM login_failures WHERE key=?", key)
+	return err
+}
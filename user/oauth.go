@@ -0,0 +1,231 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+// authorizationCodeTTL is how long a client has to redeem an authorization code.
+const authorizationCodeTTL = time.Minute
+
+var (
+	OauthClientNotFound      = errors.New("oauth client not found")
+	AuthorizationCodeInvalid = errors.New("invalid or expired authorization code")
+	AuthorizationCodeReused  = errors.New("authorization code reuse detected")
+)
+
+// OauthClient is a third-party application registered by a user, its developer,
+// that other users grant access to their account.
+// Confidential clients authenticate with a secret, public ones, e.g. single page or mobile apps, only with PKCE.
+type OauthClient struct {
+	Id           string    `json:"client_id"`
+	OwnerId      Id        `json:"-"`
+	Name         string    `json:"name"`
+	RedirectUris []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+	secretHash   string
+}
+
+// AuthorizationCode is the proof of the consent of a user, redeemed once by the client for its tokens.
+type AuthorizationCode struct {
+	CodeHash string
+	ClientId string
+	UserId   Id
+	// RedirectUri is empty if the authorization request left it out, then the token request can too
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	// SessionId is the session the code was exchanged for
+	SessionId string
+}
+
+const oauthClientColumns = "id, owner_id, name, secret_hash, redirect_uris, scopes, created_at"
+
+func scanOauthClient(row rowScanner) (OauthClient, error) {
+	var client OauthClient
+	var redirectUris, scopes string
+	err := row.Scan(&client.Id, &client.OwnerId, &client.Name, &client.secretHash, &redirectUris, &scopes, &client.CreatedAt)
+	if err != nil {
+		return OauthClient{}, err
+	}
+	client.RedirectUris = strings.Fields(redirectUris)
+	client.Scopes = strings.Fields(scopes)
+	client.Confidential = client.secretHash != ""
+	return client, nil
+}
+
+func (s SqliteUsersStorage) CreateOauthClient(ctx context.Context, ownerId Id, name string, secretHash string, redirectUris []string, scopes []string) (OauthClient, error) {
+	return scanOauthClient(s.db.QueryRowContext(ctx, `
+		INSERT INTO oauth_clients (id, owner_id, name, secret_hash, redirect_uris, scopes, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING `+oauthClientColumns,
+		ulid.Make().String(), ownerId, name, secretHash, strings.Join(redirectUris, " "), strings.Join(scopes, " "), time.Now().UTC()))
+}
+
+func (s SqliteUsersStorage) GetOauthClient(ctx context.Context, id string) (OauthClient, error) {
+	client, err := scanOauthClient(s.db.QueryRowContext(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients WHERE id=?", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OauthClient{}, OauthClientNotFound
+		}
+		return OauthClient{}, err
+	}
+	return client, nil
+}
+
+func (s SqliteUsersStorage) GetOauthClients(ctx context.Context, ownerId Id) ([]OauthClient, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients WHERE owner_id=? ORDER BY id", ownerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []OauthClient{}
+	for rows.Next() {
+		client, err := scanOauthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// DeleteOauthClient removes the client of the owner and revokes the sessions granted to it,
+// returning their ids.
+func (s SqliteUsersStorage) DeleteOauthClient(ctx context.Context, ownerId Id, id string) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids, err := revokeClientSessionsTx(ctx, tx, "id=? AND owner_id=?", id, ownerId)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM oauth_clients WHERE id=? AND owner_id=?", id, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, OauthClientNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM oauth_codes WHERE client_id=?", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, tx.Commit()
+}
+
+// revokeClientSessionsTx revokes the sessions of the clients matching where, returning their ids.
+func revokeClientSessionsTx(ctx context.Context, tx *sql.Tx, where string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id FROM sessions WHERE revoked_at IS NULL AND client_id IN (SELECT id FROM oauth_clients WHERE "+where+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if err = revokeSessionTx(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+func (s SqliteUsersStorage) CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO oauth_codes (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, code.CodeHash, code.ClientId, code.UserId, code.RedirectUri, strings.Join(code.Scopes, " "), code.CodeChallenge,
+		code.ExpiresAt.UTC())
+	return err
+}
+
+// RedeemAuthorizationCode marks the code as used and returns it. A code presented a second time
+// is likely stolen, so the session it was exchanged for is revoked and AuthorizationCodeReused is returned.
+func (s SqliteUsersStorage) RedeemAuthorizationCode(ctx context.Context, codeHash string) (AuthorizationCode, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return AuthorizationCode{}, err
+	}
+	defer tx.Rollback()
+
+	var code AuthorizationCode
+	var scope string
+	var usedAt sql.NullTime
+	var sessionId sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT code_hash, client_id, user_id, redirect_uri, scope, code_challenge, expires_at, used_at, session_id
+		FROM oauth_codes WHERE code_hash=?
+	`, codeHash).Scan(&code.CodeHash, &code.ClientId, &code.UserId, &code.RedirectUri, &scope, &code.CodeChallenge,
+		&code.ExpiresAt, &usedAt, &sessionId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return AuthorizationCode{}, AuthorizationCodeInvalid
+		}
+		return AuthorizationCode{}, err
+	}
+	code.Scopes = strings.Fields(scope)
+	code.SessionId = sessionId.String
+
+	if usedAt.Valid {
+		if code.SessionId != "" {
+			if err = revokeSessionTx(ctx, tx, code.SessionId); err != nil {
+				return AuthorizationCode{}, err
+			}
+			if err = tx.Commit(); err != nil {
+				return AuthorizationCode{}, err
+			}
+		}
+		return code, AuthorizationCodeReused
+	}
+	if code.ExpiresAt.Before(time.Now()) {
+		return AuthorizationCode{}, AuthorizationCodeInvalid
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE oauth_codes SET used_at=? WHERE code_hash=?", time.Now().UTC(), codeHash)
+	if err != nil {
+		return AuthorizationCode{}, err
+	}
+	return code, tx.Commit()
+}
+
+func (s SqliteUsersStorage) SetAuthorizationCodeSession(ctx context.Context, codeHash string, sessionId string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE oauth_codes SET session_id=? WHERE code_hash=?", sessionId, codeHash)
+	return err
+}
+
+// allowsRedirect reports whether the uri is registered for the client, compared exactly as OAuth 2.1 requires.
+func (c OauthClient) allowsRedirect(uri string) bool {
+	return slices.Contains(c.RedirectUris, uri)
+}
@@ -0,0 +1,704 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	jwtware "jwt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"todo-api/config"
+	"todo-api/oidc"
+	"todo-api/utils"
+)
+
+// oauthError is an error response of RFC 6749, e.g. invalid_grant.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// grantTokens is the token response of RFC 6749, section 5.1.
+type grantTokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// authorizationRequest holds the parameters of an authorization request, see RFC 6749 section 4.1.1 and RFC 7636.
+type authorizationRequest struct {
+	ResponseType        string `query:"response_type" json:"response_type"`
+	ClientId            string `query:"client_id" json:"client_id"`
+	RedirectUri         string `query:"redirect_uri" json:"redirect_uri"`
+	Scope               string `query:"scope" json:"scope"`
+	State               string `query:"state" json:"state"`
+	CodeChallenge       string `query:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `query:"code_challenge_method" json:"code_challenge_method"`
+
+	// redirectTo is where the browser goes back to: RedirectUri, or the only uri registered if it was left out
+	redirectTo string
+}
+
+func (r authorizationRequest) values() url.Values {
+	values := url.Values{}
+	values.Set("response_type", r.ResponseType)
+	values.Set("client_id", r.ClientId)
+	if r.RedirectUri != "" {
+		values.Set("redirect_uri", r.RedirectUri)
+	}
+	values.Set("scope", r.Scope)
+	values.Set("state", r.State)
+	values.Set("code_challenge", r.CodeChallenge)
+	values.Set("code_challenge_method", r.CodeChallengeMethod)
+	return values
+}
+
+// checkAuthorizationRequest returns the client of the request and the scopes it asks for, filling in the defaults.
+// Until the redirect uri is known to belong to the client a *fiber.Error is returned, the user must not be sent
+// to an unknown uri; later problems are an *oauthError, reported to the client at its redirect uri.
+func checkAuthorizationRequest(ctx context.Context, storage Storage, req *authorizationRequest) (OauthClient, []string, error) {
+	client, err := storage.GetOauthClient(ctx, req.ClientId)
+	if err != nil {
+		if errors.Is(err, OauthClientNotFound) {
+			return OauthClient{}, nil, fiber.NewError(fiber.StatusBadRequest, "unknown client")
+		}
+		return OauthClient{}, nil, err
+	}
+	req.redirectTo = req.RedirectUri
+	if req.redirectTo == "" && len(client.RedirectUris) == 1 {
+		req.redirectTo = client.RedirectUris[0]
+	}
+	if !client.allowsRedirect(req.redirectTo) {
+		return OauthClient{}, nil, fiber.NewError(fiber.StatusBadRequest, "the redirect uri is not registered for the client")
+	}
+
+	if req.ResponseType != "code" {
+		return OauthClient{}, nil, &oauthError{Code: "unsupported_response_type", Description: "only the code response type is supported"}
+	}
+	// PKCE is required from every client, as in OAuth 2.1
+	if req.CodeChallengeMethod != "S256" || len(req.CodeChallenge) != 43 {
+		return OauthClient{}, nil, &oauthError{Code: "invalid_request", Description: "a S256 code challenge is required"}
+	}
+
+	scopes := client.Scopes
+	if req.Scope != "" {
+		scopes = strings.Fields(req.Scope)
+		slices.Sort(scopes)
+		scopes = slices.Compact(scopes)
+		for _, scope := range scopes {
+			if !slices.Contains(client.Scopes, scope) {
+				return OauthClient{}, nil, &oauthError{Code: "invalid_scope", Description: "the client cannot request the " + scope + " scope"}
+			}
+		}
+	}
+	req.Scope = strings.Join(scopes, " ")
+	return client, scopes, nil
+}
+
+// redirectUri adds the params to the query of the redirect uri of a client.
+func redirectUri(uri string, params url.Values) string {
+	redirect, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	query := redirect.Query()
+	for key, values := range params {
+		if values[0] != "" {
+			query[key] = values
+		}
+	}
+	redirect.RawQuery = query.Encode()
+	return redirect.String()
+}
+
+// validRedirectUri accepts https urls, http urls on the loopback interface and private-use schemes
+// like com.example.app:/callback for native apps, see RFC 8252. Fragments are not allowed.
+func validRedirectUri(uri string) bool {
+	if strings.ContainsAny(uri, "# \t\r\n") {
+		return false
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	switch parsed.Scheme {
+	case "https":
+		return parsed.Host != ""
+	case "http":
+		host := parsed.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return strings.Contains(parsed.Scheme, ".")
+	}
+}
+
+func RegisterOauthClientHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
+	type RegisterOauthClientRequest struct {
+		Name         string   `json:"name" validate:"required,lte=255"`
+		RedirectUris []string `json:"redirect_uris" validate:"required,min=1,max=10,unique,dive,required,lte=2048"`
+		Scopes       []string `json:"scopes" validate:"required,min=1,unique,dive,oneof=todos:read todos:write workspaces:read workspaces:write"`
+		// Confidential clients get a secret, public ones, which cannot keep one, rely on PKCE alone
+		Confidential bool `json:"confidential"`
+	}
+
+	// the secret is only returned once, on registration
+	type RegisterOauthClientResponse struct {
+		OauthClient
+		ClientSecret string `json:"client_secret,omitempty"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		data := RegisterOauthClientRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+		for _, uri := range data.RedirectUris {
+			if !validRedirectUri(uri) {
+				return fiber.NewError(fiber.StatusBadRequest,
+					"redirect uri "+uri+" must use https, http on localhost or a private-use scheme, without a fragment")
+			}
+		}
+		slices.Sort(data.Scopes)
+
+		var secret, secretHash string
+		if data.Confidential {
+			secret, err = utils.NewRandomToken()
+			if err != nil {
+				return err
+			}
+			secretHash = utils.HashToken(secret)
+		}
+
+		client, err := storage.CreateOauthClient(ctx.Context(), FromContext(ctx).Id, data.Name, secretHash, data.RedirectUris, data.Scopes)
+		if err != nil {
+			return err
+		}
+
+		return ctx.Status(fiber.StatusCreated).JSON(RegisterOauthClientResponse{OauthClient: client, ClientSecret: secret})
+	}
+}
+
+func GetOauthClientsHandler(storage Storage) fiber.Handler {
+	type GetOauthClientsResponse struct {
+		Data []OauthClient `json:"data"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		clients, err := storage.GetOauthClients(ctx.Context(), FromContext(ctx).Id)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(GetOauthClientsResponse{Data: clients})
+	}
+}
+
+// DeleteOauthClientHandler removes a client, ending the access of every user who granted it some.
+func DeleteOauthClientHandler(revocations *RevocationList) fiber.Handler {
+	type DeleteOauthClientResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		err := revocations.DeleteOauthClient(ctx.Context(), FromContext(ctx).Id, ctx.Params("id"))
+		if err != nil {
+			if errors.Is(err, OauthClientNotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return err
+		}
+
+		return ctx.JSON(DeleteOauthClientResponse{})
+	}
+}
+
+// AuthorizeHandler is the authorization endpoint clients send the browser of the user to.
+// A valid request is forwarded to the consent page of the frontend, which shows it with ConsentHandler
+// and sends the decision of the user to ConsentDecisionHandler.
+func AuthorizeHandler(config *config.AppConfig, storage Storage) fiber.Handler {
+	return func(ctx fiber.Ctx) error {
+		req := authorizationRequest{}
+		err := ctx.Bind().Query(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		_, _, err = checkAuthorizationRequest(ctx.Context(), storage, &req)
+		var authErr *oauthError
+		if errors.As(err, &authErr) {
+			return ctx.Redirect().Status(fiber.StatusFound).To(redirectUri(req.redirectTo, url.Values{
+				"error":             {authErr.Code},
+				"error_description": {authErr.Description},
+				"state":             {req.State},
+			}))
+		}
+		if err != nil {
+			return err
+		}
+
+		return ctx.Redirect().Status(fiber.StatusFound).To(config.ConsentUrl() + "?" + req.values().Encode())
+	}
+}
+
+// ConsentHandler describes an authorization request to the logged-in user, so they can approve or deny it.
+func ConsentHandler(storage Storage) fiber.Handler {
+	type ConsentClient struct {
+		Id   string `json:"client_id"`
+		Name string `json:"name"`
+	}
+
+	type ConsentResponse struct {
+		Client      ConsentClient `json:"client"`
+		Scopes      []string      `json:"scopes"`
+		RedirectUri string        `json:"redirect_uri"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		req := authorizationRequest{}
+		err := ctx.Bind().Query(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		client, scopes, err := checkAuthorizationRequest(ctx.Context(), storage, &req)
+		var authErr *oauthError
+		if errors.As(err, &authErr) {
+			return fiber.NewError(fiber.StatusBadRequest, authErr.Description)
+		}
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(ConsentResponse{
+			Client:      ConsentClient{Id: client.Id, Name: client.Name},
+			Scopes:      scopes,
+			RedirectUri: req.redirectTo,
+		})
+	}
+}
+
+// ConsentDecisionHandler records the decision of the user on an authorization request and returns
+// where to send the browser: back to the client with an authorization code, or with access_denied.
+func ConsentDecisionHandler(storage Storage) fiber.Handler {
+	type ConsentDecisionRequest struct {
+		authorizationRequest
+		Approve bool `json:"approve"`
+	}
+
+	type ConsentDecisionResponse struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		data := ConsentDecisionRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+
+		client, scopes, err := checkAuthorizationRequest(ctx.Context(), storage, &data.authorizationRequest)
+		var authErr *oauthError
+		if errors.As(err, &authErr) {
+			return fiber.NewError(fiber.StatusBadRequest, authErr.Description)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !data.Approve {
+			return ctx.JSON(ConsentDecisionResponse{RedirectTo: redirectUri(data.redirectTo, url.Values{
+				"error":             {"access_denied"},
+				"error_description": {"the user denied the request"},
+				"state":             {data.State},
+			})})
+		}
+
+		code, err := utils.NewRandomToken()
+		if err != nil {
+			return err
+		}
+		err = storage.CreateAuthorizationCode(ctx.Context(), AuthorizationCode{
+			CodeHash:      utils.HashToken(code),
+			ClientId:      client.Id,
+			UserId:        FromContext(ctx).Id,
+			RedirectUri:   data.RedirectUri,
+			Scopes:        scopes,
+			CodeChallenge: data.CodeChallenge,
+			ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+		})
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(ConsentDecisionResponse{RedirectTo: redirectUri(data.redirectTo, url.Values{
+			"code":  {code},
+			"state": {data.State},
+		})})
+	}
+}
+
+// TokenHandler is the token endpoint, clients redeem authorization codes and refresh tokens at it.
+// It takes form-encoded requests and answers with the errors of RFC 6749, section 5.2.
+func TokenHandler(config *config.AppConfig, storage Storage, keyring *Keyring, revocations *RevocationList) fiber.Handler {
+	type TokenResponse grantTokens
+
+	return func(ctx fiber.Ctx) error {
+		ctx.Set(fiber.HeaderCacheControl, "no-store")
+
+		client, err := authenticateClient(ctx, storage)
+		if err != nil {
+			return sendOauthError(ctx, err)
+		}
+
+		var tokens grantTokens
+		switch ctx.FormValue("grant_type") {
+		case "authorization_code":
+			tokens, err = exchangeAuthorizationCode(ctx, config, storage, keyring, revocations, client)
+		case "refresh_token":
+			tokens, err = refreshGrant(ctx, config, storage, keyring, revocations, client)
+		default:
+			err = &oauthError{Code: "unsupported_grant_type", Description: "expected authorization_code or refresh_token"}
+		}
+		if err != nil {
+			return sendOauthError(ctx, err)
+		}
+
+		return ctx.JSON(TokenResponse(tokens))
+	}
+}
+
+func exchangeAuthorizationCode(ctx fiber.Ctx, config *config.AppConfig, storage Storage, keyring *Keyring, revocations *RevocationList, client OauthClient) (grantTokens, error) {
+	codeHash := utils.HashToken(ctx.FormValue("code"))
+	code, err := storage.RedeemAuthorizationCode(ctx.Context(), codeHash)
+	if err != nil {
+		if errors.Is(err, AuthorizationCodeReused) && code.SessionId != "" {
+			revocations.MarkSessionRevoked(code.SessionId)
+		}
+		if errors.Is(err, AuthorizationCodeInvalid) || errors.Is(err, AuthorizationCodeReused) {
+			return grantTokens{}, &oauthError{Code: "invalid_grant", Description: err.Error()}
+		}
+		return grantTokens{}, err
+	}
+	if code.ClientId != client.Id {
+		return grantTokens{}, &oauthError{Code: "invalid_grant", Description: "the code was issued to another client"}
+	}
+	// required when the authorization request had one, see RFC 6749 section 4.1.3
+	if code.RedirectUri != "" && ctx.FormValue("redirect_uri") != code.RedirectUri {
+		return grantTokens{}, &oauthError{Code: "invalid_grant", Description: "the redirect uri does not match the authorization request"}
+	}
+	verifier := ctx.FormValue("code_verifier")
+	if verifier == "" || subtle.ConstantTimeCompare([]byte(oidc.CodeChallenge(verifier)), []byte(code.CodeChallenge)) != 1 {
+		return grantTokens{}, &oauthError{Code: "invalid_grant", Description: "the code verifier does not match the code challenge"}
+	}
+
+	user, err := grantUser(ctx.Context(), storage, code.UserId)
+	if err != nil {
+		return grantTokens{}, err
+	}
+
+	refreshToken, err := utils.NewRandomToken()
+	if err != nil {
+		return grantTokens{}, err
+	}
+	expiresAt := time.Now().Add(config.RefreshTokenTTL)
+	device := deviceFromRequest(ctx, client.Name)
+	session, err := storage.CreateGrantSession(ctx.Context(), user.Id, client.Id, code.Scopes, device, expiresAt)
+	if err != nil {
+		return grantTokens{}, err
+	}
+	err = storage.SetAuthorizationCodeSession(ctx.Context(), codeHash, session.Id)
+	if err != nil {
+		return grantTokens{}, err
+	}
+	_, err = storage.CreateRefreshToken(ctx.Context(), user.Id, session.Id, utils.HashToken(refreshToken), expiresAt)
+	if err != nil {
+		return grantTokens{}, err
+	}
+
+	return newGrantTokens(config, keyring, user, session, session.Scopes, refreshToken)
+}
+
+// refreshGrant rotates a refresh token of the client. The client can ask for fewer scopes than it was granted,
+// for the new access token only.
+func refreshGrant(ctx fiber.Ctx, config *config.AppConfig, storage Storage, keyring *Keyring, revocations *RevocationList, client OauthClient) (grantTokens, error) {
+	tokenHash := utils.HashToken(ctx.FormValue("refresh_token"))
+	_, session, err := refreshTokenSession(ctx.Context(), storage, tokenHash)
+	if err != nil {
+		return grantTokens{}, err
+	}
+	if session.ClientId == "" || session.ClientId != client.Id {
+		return grantTokens{}, &oauthError{Code: "invalid_grant", Description: RefreshTokenInvalid.Error()}
+	}
+	if !provesDpopKey(ctx, session) {
+		return grantTokens{}, &oauthError{Code: "invalid_dpop_proof", Description: "the refresh token is bound to a DPoP key, send a proof signed by it"}
+	}
+
+	scopes := session.Scopes
+	if scope := ctx.FormValue("scope"); scope != "" {
+		scopes = strings.Fields(scope)
+		for _, scope := range scopes {
+			if !slices.Contains(session.Scopes, scope) {
+				return grantTokens{}, &oauthError{Code: "invalid_scope", Description: "the " + scope + " scope was not granted"}
+			}
+		}
+	}
+
+	refreshToken, err := utils.NewRandomToken()
+	if err != nil {
+		return grantTokens{}, err
+	}
+	next, err := storage.RotateRefreshToken(ctx.Context(), tokenHash, utils.HashToken(refreshToken), time.Now().Add(config.RefreshTokenTTL))
+	if err != nil {
+		if errors.Is(err, RefreshTokenReused) {
+			revocations.MarkSessionRevoked(next.FamilyId)
+		}
+		if errors.Is(err, RefreshTokenInvalid) || errors.Is(err, RefreshTokenReused) {
+			return grantTokens{}, &oauthError{Code: "invalid_grant", Description: err.Error()}
+		}
+		return grantTokens{}, err
+	}
+
+	user, err := grantUser(ctx.Context(), storage, next.UserId)
+	if err != nil {
+		return grantTokens{}, err
+	}
+
+	return newGrantTokens(config, keyring, user, session, scopes, refreshToken)
+}
+
+// grantUser returns the user a client acts for, if the account can still be used.
+func grantUser(ctx context.Context, storage Storage, id Id) (User, error) {
+	user, err := storage.GetById(ctx, id)
+	if err != nil {
+		if errors.Is(err, NotFound) {
+			return User{}, &oauthError{Code: "invalid_grant", Description: "the account no longer exists"}
+		}
+		return User{}, err
+	}
+	if user.deletedAt != nil || user.disabledAt != nil {
+		return User{}, &oauthError{Code: "invalid_grant", Description: "the account is disabled or scheduled for deletion"}
+	}
+	return user, nil
+}
+
+// newGrantTokens issues an access token of the session limited to the scopes, which the jwt middleware
+// enforces like those of personal access tokens.
+func newGrantTokens(config *config.AppConfig, keyring *Keyring, user User, session Session, scopes []string, refreshToken string) (grantTokens, error) {
+	claims := userClaims(keyring, user, session.Id, session.DpopJkt, config.AccessTokenTTL)
+	claims.ClientId = session.ClientId
+	claims.Scope = strings.Join(scopes, " ")
+	token, err := keyring.Sign(claims)
+	if err != nil {
+		return grantTokens{}, err
+	}
+
+	tokenType := "Bearer"
+	if session.DpopJkt != "" {
+		tokenType = jwtware.DPoPScheme
+	}
+	return grantTokens{
+		AccessToken:  token,
+		TokenType:    tokenType,
+		ExpiresIn:    int(config.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+// refreshTokenSession returns a refresh token and its session, both empty if there is no such active session.
+func refreshTokenSession(ctx context.Context, storage Storage, tokenHash string) (RefreshToken, Session, error) {
+	token, err := storage.GetRefreshToken(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, RefreshTokenInvalid) {
+			return RefreshToken{}, Session{}, nil
+		}
+		return RefreshToken{}, Session{}, err
+	}
+	session, err := storage.GetSession(ctx, token.FamilyId)
+	if err != nil {
+		if errors.Is(err, SessionNotFound) {
+			return RefreshToken{}, Session{}, nil
+		}
+		return RefreshToken{}, Session{}, err
+	}
+	return token, session, nil
+}
+
+// authenticateClient identifies the client by HTTP basic auth or by the client_id and client_secret form fields.
+// Public clients only send their id.
+func authenticateClient(ctx fiber.Ctx, storage Storage) (OauthClient, error) {
+	id, secret := ctx.FormValue("client_id"), ctx.FormValue("client_secret")
+	const scheme = "Basic "
+	if auth := ctx.Get(fiber.HeaderAuthorization); len(auth) > len(scheme) && strings.EqualFold(auth[:len(scheme)], scheme) {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[len(scheme):]))
+		if err != nil {
+			return OauthClient{}, &oauthError{Code: "invalid_client", Description: "malformed basic auth"}
+		}
+		basicId, basicSecret, _ := strings.Cut(string(decoded), ":")
+		id, _ = url.QueryUnescape(basicId)
+		secret, _ = url.QueryUnescape(basicSecret)
+	}
+	if id == "" {
+		return OauthClient{}, &oauthError{Code: "invalid_client", Description: "client authentication is required"}
+	}
+
+	client, err := storage.GetOauthClient(ctx.Context(), id)
+	if err != nil {
+		if errors.Is(err, OauthClientNotFound) {
+			return OauthClient{}, &oauthError{Code: "invalid_client", Description: "unknown client"}
+		}
+		return OauthClient{}, err
+	}
+	if client.Confidential && subtle.ConstantTimeCompare([]byte(utils.HashToken(secret)), []byte(client.secretHash)) != 1 {
+		return OauthClient{}, &oauthError{Code: "invalid_client", Description: "wrong client secret"}
+	}
+	return client, nil
+}
+
+// sendOauthError answers with an error response of RFC 6749, other errors go to the error handler of the app.
+func sendOauthError(ctx fiber.Ctx, err error) error {
+	var authErr *oauthError
+	if !errors.As(err, &authErr) {
+		return err
+	}
+	if authErr.Code == "invalid_client" {
+		ctx.Set(fiber.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+		return ctx.Status(fiber.StatusUnauthorized).JSON(authErr)
+	}
+	return ctx.Status(fiber.StatusBadRequest).JSON(authErr)
+}
+
+// RevokeTokenHandler revokes a token of the client, see RFC 7009. A refresh token ends the whole grant,
+// an access token only itself. Unknown tokens are not an error.
+func RevokeTokenHandler(storage Storage, keyring *Keyring, revocations *RevocationList) fiber.Handler {
+	type RevokeTokenResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		client, err := authenticateClient(ctx, storage)
+		if err != nil {
+			return sendOauthError(ctx, err)
+		}
+		token := ctx.FormValue("token")
+		if token == "" {
+			return sendOauthError(ctx, &oauthError{Code: "invalid_request", Description: "token is required"})
+		}
+
+		_, session, err := refreshTokenSession(ctx.Context(), storage, utils.HashToken(token))
+		if err != nil {
+			return err
+		}
+		if session.ClientId != "" && session.ClientId == client.Id {
+			err = revocations.RevokeSession(ctx.Context(), session.UserId, session.Id)
+			if err != nil && !errors.Is(err, SessionNotFound) {
+				return err
+			}
+			return ctx.JSON(RevokeTokenResponse{})
+		}
+
+		claims, err := keyring.parse(token)
+		if err == nil && claims.ClientId == client.Id {
+			expiresAt, err := claims.GetExpirationTime()
+			if err == nil && expiresAt != nil {
+				err = revocations.Revoke(ctx.Context(), Id(claims.Subject), claims.ID, expiresAt.Time)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return ctx.JSON(RevokeTokenResponse{})
+	}
+}
+
+// IntrospectTokenHandler tells a client whether one of its tokens is active, see RFC 7662.
+// Tokens of other clients are reported as inactive.
+func IntrospectTokenHandler(storage Storage, keyring *Keyring, revocations *RevocationList) fiber.Handler {
+	type IntrospectTokenResponse struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientId  string `json:"client_id,omitempty"`
+		Username  string `json:"username,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+		IssuedAt  int64  `json:"iat,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		// Confirmation is the key the token is bound to, see RFC 9449 section 6.2
+		Confirmation *confirmation `json:"cnf,omitempty"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		client, err := authenticateClient(ctx, storage)
+		if err != nil {
+			return sendOauthError(ctx, err)
+		}
+		token := ctx.FormValue("token")
+		if token == "" {
+			return sendOauthError(ctx, &oauthError{Code: "invalid_request", Description: "token is required"})
+		}
+
+		refreshToken, session, err := refreshTokenSession(ctx.Context(), storage, utils.HashToken(token))
+		if err != nil {
+			return err
+		}
+		if session.ClientId != "" && session.ClientId == client.Id {
+			if !refreshToken.Usable() {
+				return ctx.JSON(IntrospectTokenResponse{})
+			}
+			response := IntrospectTokenResponse{
+				Active:    true,
+				Scope:     strings.Join(session.Scopes, " "),
+				ClientId:  client.Id,
+				ExpiresAt: refreshToken.ExpiresAt.Unix(),
+				IssuedAt:  refreshToken.CreatedAt.Unix(),
+				Subject:   string(session.UserId),
+			}
+			if session.DpopJkt != "" {
+				response.Confirmation = &confirmation{Jkt: session.DpopJkt}
+			}
+			return ctx.JSON(response)
+		}
+
+		claims, err := keyring.parse(token)
+		if err != nil || claims.ClientId != client.Id {
+			return ctx.JSON(IntrospectTokenResponse{})
+		}
+		if revocations.IsRevoked(Id(claims.Subject), claims.ID, claims.SessionId, claims.Generation) {
+			return ctx.JSON(IntrospectTokenResponse{})
+		}
+
+		response := IntrospectTokenResponse{
+			Active:       true,
+			Scope:        claims.Scope,
+			ClientId:     client.Id,
+			Username:     claims.Email,
+			TokenType:    "Bearer",
+			Subject:      claims.Subject,
+			Confirmation: claims.Confirmation,
+		}
+		if claims.Confirmation != nil {
+			response.TokenType = jwtware.DPoPScheme
+		}
+		if expiresAt, err := claims.GetExpirationTime(); err == nil && expiresAt != nil {
+			response.ExpiresAt = expiresAt.Unix()
+		}
+		if issuedAt, err := claims.GetIssuedAt(); err == nil && issuedAt != nil {
+			response.IssuedAt = issuedAt.Unix()
+		}
+		return ctx.JSON(response)
+	}
+}
@@ -0,0 +1,169 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"github.com/oklog/ulid/v2"
+	"log"
+	"time"
+	"todo-api/utils"
+)
+
+const (
+	// passwordResetTTL is how long a password reset link can be used.
+	passwordResetTTL = time.Hour
+	// reauthenticationWindow is how long after logging in a user without a password can confirm a change.
+	reauthenticationWindow = 10 * time.Minute
+)
+
+var PasswordResetInvalid = errors.New("invalid or expired password reset token")
+
+func (s SqliteUsersStorage) UpdatePassword(ctx context.Context, id Id, passwordHash string) error {
+	stmt, err := s.db.PrepareContext(ctx, "UPDATE users SET password_hash=? WHERE id=?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx, passwordHash, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return NotFound
+	}
+	return nil
+}
+
+// confirmPassword checks the password of the user before a sensitive change. Users without one, who log in
+// through an identity provider, confirm by logging in again instead: the session of the request has to be recent.
+func confirmPassword(ctx fiber.Ctx, storage Storage, user User, password string) error {
+	if !user.hasPassword() {
+		claims, err := tokenClaims(ctx)
+		if err != nil {
+			return err
+		}
+		session, err := storage.GetSession(ctx.Context(), claims.SessionId)
+		if err != nil && !errors.Is(err, SessionNotFound) {
+			return err
+		}
+		if err != nil || time.Since(session.CreatedAt) > reauthenticationWindow {
+			return fiber.NewError(fiber.StatusForbidden, "log in again to confirm the change")
+		}
+		return nil
+	}
+
+	return checkPassword(ctx, storage, user, password)
+}
+
+// rehashPassword upgrades the stored hash to the current argon2 parameters.
+// The login goes on if it fails, the old hash still works.
+func rehashPassword(ctx context.Context, storage Storage, user User, password string) {
+	passwordHash, err := utils.HashPassword(password)
+	if err == nil {
+		err = storage.UpdatePasswordHash(ctx, user.Id, user.passwordHash, passwordHash)
+	}
+	if err != nil {
+		log.Printf("password rehash of user %s failed: %v", user.Id, err)
+	}
+}
+
+// UpdatePasswordHash replaces a hash of the same password, e.g. one with outdated parameters.
+// Nothing is changed if the password was changed meanwhile.
+func (s SqliteUsersStorage) UpdatePasswordHash(ctx context.Context, id Id, oldHash string, newHash string) error {
+	stmt, err := s.db.PrepareContext(ctx, "UPDATE users SET password_hash=? WHERE id=? AND password_hash=?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, newHash, id, oldHash)
+	return err
+}
+
+func (s SqliteUsersStorage) CreatePasswordReset(ctx context.Context, userId Id, tokenHash string, expiresAt time.Time) error {
+	stmt, err := s.db.PrepareContext(ctx, "INSERT INTO password_resets (id, user_id, token_hash, expires_at) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, ulid.Make().String(), userId, tokenHash, expiresAt.UTC())
+	return err
+}
+
+// ResetPassword sets the password of the user the reset token was issued to.
+// The token and every other pending reset of the user are used up.
+func (s SqliteUsersStorage) ResetPassword(ctx context.Context, tokenHash string, passwordHash string) (Id, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var userId Id
+	err = tx.QueryRowContext(ctx,
+		"SELECT user_id FROM password_resets WHERE token_hash=? AND used_at IS NULL AND expires_at>?",
+		tokenHash, time.Now().UTC()).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", PasswordResetInvalid
+		}
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE password_resets SET used_at=CURRENT_TIMESTAMP WHERE user_id=? AND used_at IS NULL", userId)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET password_hash=? WHERE id=?", passwordHash, userId)
+	if err != nil {
+		return "", err
+	}
+
+	return userId, tx.Commit()
+}
+
+// RevokeOtherSessions revokes every session of the user except keep and returns the ids of the revoked ones.
+func (s SqliteUsersStorage) RevokeOtherSessions(ctx context.Context, userId Id, keep string) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id FROM sessions WHERE user_id=? AND id<>? AND revoked_at IS NULL", userId, keep)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if err = revokeSessionTx(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, tx.Commit()
+}
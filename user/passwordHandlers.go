@@ -0,0 +1,187 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v3"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"todo-api/config"
+	"todo-api/mail"
+	"todo-api/utils"
+)
+
+// ChangePasswordHandler sets a new password after checking the current one and ends the other sessions of the user.
+func ChangePasswordHandler(storage Storage, revocations *RevocationList, passwords *PasswordPolicy, validator *utils.AppValidator) fiber.Handler {
+	type ChangePasswordRequest struct {
+		CurrentPassword string `json:"current_password" validate:"required,lte=255"`
+		NewPassword     string `json:"new_password" validate:"required,lte=255"`
+	}
+
+	type ChangePasswordResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		u := FromContext(ctx)
+
+		data := ChangePasswordRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		user, err := storage.GetById(ctx.Context(), u.Id)
+		if err != nil {
+			return err
+		}
+		err = checkPassword(ctx, storage, user, data.CurrentPassword)
+		if err != nil {
+			return err
+		}
+		err = passwords.Check("NewPassword", data.NewPassword, user.Email, user.Name)
+		if err != nil {
+			return err
+		}
+
+		passwordHash, err := utils.HashPassword(data.NewPassword)
+		if err != nil {
+			return err
+		}
+		err = storage.UpdatePassword(ctx.Context(), user.Id, passwordHash)
+		if err != nil {
+			return err
+		}
+
+		claims, err := tokenClaims(ctx)
+		if err != nil {
+			return err
+		}
+		err = revocations.RevokeOtherSessions(ctx.Context(), user.Id, claims.SessionId)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(ChangePasswordResponse{})
+	}
+}
+
+// ForgotPasswordHandler mails a password reset link. It answers the same way whether the email is registered or not.
+func ForgotPasswordHandler(config *config.AppConfig, storage Storage, mailer mail.Mailer, validator *utils.AppValidator) fiber.Handler {
+	type ForgotPasswordRequest struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	type ForgotPasswordResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		data := ForgotPasswordRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		user, err := storage.GetUserByEmail(ctx.Context(), data.Email)
+		if err != nil {
+			if errors.Is(err, NotFound) {
+				return ctx.JSON(ForgotPasswordResponse{})
+			}
+			return err
+		}
+		if user.deletedAt != nil || user.disabledAt != nil {
+			return ctx.JSON(ForgotPasswordResponse{})
+		}
+
+		// created and mailed in the background, so the response takes as long as for an unknown email
+		go sendPasswordReset(config, storage, mailer, user)
+
+		return ctx.JSON(ForgotPasswordResponse{})
+	}
+}
+
+// sendPasswordReset creates a reset token for the user and mails its link.
+func sendPasswordReset(config *config.AppConfig, storage Storage, mailer mail.Mailer, user User) {
+	ctx := context.Background()
+	token, err := utils.NewRandomToken()
+	if err != nil {
+		log.Printf("password reset of user %s failed: %v", user.Id, err)
+		return
+	}
+	expiresAt := time.Now().Add(passwordResetTTL)
+	err = storage.CreatePasswordReset(ctx, user.Id, utils.HashToken(token), expiresAt)
+	if err != nil {
+		log.Printf("password reset of user %s failed: %v", user.Id, err)
+		return
+	}
+
+	err = mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password of your account.\n\nSet a new password: %s/password/reset?token=%s\n\nThe link expires at %s. If it was not you, ignore this email.",
+			strings.TrimRight(config.PublicUrl, "/"), url.QueryEscape(token), expiresAt.Format(time.RFC1123),
+		),
+	})
+	if err != nil {
+		log.Printf("password reset mail to %s failed: %v", user.Email, err)
+	}
+}
+
+// ResetPasswordHandler sets a new password with a reset token and ends every session of the user.
+func ResetPasswordHandler(storage Storage, revocations *RevocationList, passwords *PasswordPolicy, validator *utils.AppValidator) fiber.Handler {
+	type ResetPasswordRequest struct {
+		Token       string `json:"token" validate:"required"`
+		NewPassword string `json:"new_password" validate:"required,lte=255"`
+	}
+
+	type ResetPasswordResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		data := ResetPasswordRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+		// the user is not known before the token is used, so only the rules without the email and name apply
+		err = passwords.Check("NewPassword", data.NewPassword, "", "")
+		if err != nil {
+			return err
+		}
+
+		passwordHash, err := utils.HashPassword(data.NewPassword)
+		if err != nil {
+			return err
+		}
+
+		userId, err := storage.ResetPassword(ctx.Context(), utils.HashToken(data.Token), passwordHash)
+		if err != nil {
+			if errors.Is(err, PasswordResetInvalid) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			return err
+		}
+
+		err = revocations.RevokeAll(ctx.Context(), userId)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(ResetPasswordResponse{})
+	}
+}
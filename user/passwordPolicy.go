@@ -0,0 +1,172 @@
+package user
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"todo-api/config"
+	"todo-api/utils"
+)
+
+// breachedLineBuffer is how much is read at a time while looking up a hash, enough for two lines of the list.
+const breachedLineBuffer = 256
+
+// BreachedPasswords is a list of leaked passwords, stored as hex SHA-1 hashes in a file sorted by hash.
+// The list is searched on disk, the Pwned Passwords download holds about a billion hashes.
+type BreachedPasswords struct {
+	file *os.File
+	size int64
+}
+
+// LoadBreachedPasswords opens a file with one SHA-1 hash per line, optionally followed by ":count",
+// ordered by hash like the "ordered by hash" download of the Pwned Passwords list.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	breached := &BreachedPasswords{file: file, size: stat.Size()}
+	first, err := breached.hashAt(0)
+	if err == nil && !isSha1Hash(first) {
+		err = fmt.Errorf("%s:1: not a SHA-1 hash", path)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return breached, nil
+}
+
+func isSha1Hash(hash string) bool {
+	_, err := hex.DecodeString(hash)
+	return err == nil && len(hash) == sha1.Size*2
+}
+
+// hashAt returns the upper case hash of the first line starting at or after offset, or "" at the end of the file.
+func (b *BreachedPasswords) hashAt(offset int64) (string, error) {
+	start := offset
+	if offset > 0 {
+		// a line starts at offset if the previous byte ends a line
+		start = offset - 1
+	}
+	buffer := make([]byte, breachedLineBuffer)
+	n, err := b.file.ReadAt(buffer, start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	lines := buffer[:n]
+	if offset > 0 {
+		newline := bytes.IndexByte(lines, '\n')
+		if newline < 0 {
+			return "", nil
+		}
+		lines = lines[newline+1:]
+	}
+	line, _, _ := bytes.Cut(lines, []byte("\n"))
+	hash, _, _ := strings.Cut(strings.TrimSpace(string(line)), ":")
+	return strings.ToUpper(hash), nil
+}
+
+// Contains reports whether the password is on the list, by a binary search over the byte offsets of the file.
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// find the first offset whose line has a hash not before the target, the end of the file sorts last
+	low, high := int64(0), b.size
+	for low < high {
+		middle := low + (high-low)/2
+		hash, err := b.hashAt(middle)
+		if err != nil {
+			return false, err
+		}
+		if hash == "" || hash >= target {
+			high = middle
+		} else {
+			low = middle + 1
+		}
+	}
+
+	hash, err := b.hashAt(low)
+	return hash == target, err
+}
+
+// PasswordPolicy decides which new passwords are accepted on registration and password changes.
+type PasswordPolicy struct {
+	minLength   int
+	minStrength int
+	breached    *BreachedPasswords
+}
+
+func NewPasswordPolicy(config *config.AppConfig) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{minLength: config.PasswordMinLength, minStrength: config.PasswordMinStrength}
+	if config.BreachedPasswordsFile != "" {
+		breached, err := LoadBreachedPasswords(config.BreachedPasswordsFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.breached = breached
+	}
+	return policy, nil
+}
+
+// Check returns a utils.ValidationErrorResponse listing every rule the password of the user breaks,
+// reported for the request field. The password itself is never put into the response.
+func (p *PasswordPolicy) Check(field string, password string, email string, name string) error {
+	var details []utils.ValidationError
+	fail := func(tag string, value interface{}) {
+		details = append(details, utils.ValidationError{FailedField: field, Tag: tag, Value: value})
+	}
+
+	if len([]rune(password)) < p.minLength {
+		fail("min", p.minLength)
+	}
+
+	lower := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+	if len(localPart) >= 3 && strings.Contains(lower, localPart) {
+		fail("contains_email", nil)
+	}
+	nameWords := strings.Fields(strings.ToLower(name))
+	for _, word := range nameWords {
+		if len(word) >= 3 && strings.Contains(lower, word) {
+			fail("contains_name", nil)
+			break
+		}
+	}
+
+	if strength := utils.PasswordStrength(password, append(nameWords, localPart)...); strength < p.minStrength {
+		fail("strength", strength)
+	}
+
+	if p.breached != nil {
+		breached, err := p.breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			fail("breached", nil)
+		}
+	}
+
+	if len(details) == 0 {
+		return nil
+	}
+	return utils.ValidationErrorResponse{
+		Status:  http.StatusBadRequest,
+		Message: "password does not meet the password policy",
+		Details: details,
+	}
+}
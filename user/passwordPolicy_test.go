@@ -0,0 +1,54 @@
+package user
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	var passwords, lines []string
+	for i := range 1000 {
+		passwords = append(passwords, fmt.Sprintf("password%d", i))
+	}
+	for i, password := range passwords {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(password), i+1))
+	}
+	slices.Sort(lines)
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600))
+
+	breached, err := LoadBreachedPasswords(path)
+	assert.NoError(t, err)
+
+	for _, password := range passwords {
+		found, err := breached.Contains(password)
+		assert.NoError(t, err)
+		assert.True(t, found, password)
+	}
+	for _, password := range []string{"", "not breached", "password1000"} {
+		found, err := breached.Contains(password)
+		assert.NoError(t, err)
+		assert.False(t, found, password)
+	}
+}
+
+func TestLoadBreachedPasswordsRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwords.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("password\n"), 0o600))
+
+	_, err := LoadBreachedPasswords(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,58 @@
+package user
+
+import (
+	"context"
+	"strings"
+)
+
+// ProfileUpdate holds the changed profile fields, nil fields are kept.
+type ProfileUpdate struct {
+	Name      *string
+	Email     *string
+	Timezone  *string
+	Locale    *string
+	SortBy    *string
+	SortOrder *string
+}
+
+// UpdateProfile changes the profile and returns the updated user.
+// A new email is unverified, unless it is the current one.
+func (s SqliteUsersStorage) UpdateProfile(ctx context.Context, id Id, update ProfileUpdate) (User, error) {
+	var set []string
+	var args []any
+	column := func(name string, value *string) {
+		if value != nil {
+			set = append(set, name+"=?")
+			args = append(args, *value)
+		}
+	}
+
+	if update.Email != nil {
+		// the old value of email is compared, the assignments of an UPDATE see the row before it
+		set = append(set, "email_verified_at=CASE WHEN email=? THEN email_verified_at ELSE NULL END",
+			"verification_sent_at=CASE WHEN email=? THEN verification_sent_at ELSE NULL END")
+		args = append(args, *update.Email, *update.Email)
+	}
+	column("name", update.Name)
+	column("email", update.Email)
+	column("timezone", update.Timezone)
+	column("locale", update.Locale)
+	column("sort_by", update.SortBy)
+	column("sort_order", update.SortOrder)
+
+	if len(set) > 0 {
+		result, err := s.db.ExecContext(ctx, "UPDATE users SET "+strings.Join(set, ", ")+" WHERE id=?", append(args, id)...)
+		if err != nil {
+			return User{}, mapError(err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return User{}, err
+		}
+		if affected == 0 {
+			return User{}, NotFound
+		}
+	}
+
+	return s.GetById(ctx, id)
+}
@@ -0,0 +1,143 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v3"
+	"log"
+	"todo-api/config"
+	"todo-api/mail"
+	"todo-api/utils"
+)
+
+func GetMeHandler(storage Storage) fiber.Handler {
+	type GetMeResponse User
+
+	return func(ctx fiber.Ctx) error {
+		user, err := storage.GetById(ctx.Context(), FromContext(ctx).Id)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(GetMeResponse(user))
+	}
+}
+
+// UpdateMeHandler changes the profile of the user. Changing the email needs the password, see confirmPassword,
+// and a new verification.
+// The access token carries the name and the email, so a new one is returned and the current one is revoked.
+func UpdateMeHandler(config *config.AppConfig, storage Storage, keyring *Keyring, revocations *RevocationList, mailer mail.Mailer, validator *utils.AppValidator) fiber.Handler {
+	type UpdatePreferencesRequest struct {
+		SortBy    *string `json:"sort_by" validate:"omitnil,oneof=id title description"`
+		SortOrder *string `json:"sort_order" validate:"omitnil,oneof=asc desc"`
+	}
+
+	type UpdateMeRequest struct {
+		Name        *string                  `json:"name" validate:"omitnil,gte=2,lte=255"`
+		Email       *string                  `json:"email" validate:"omitnil,email"`
+		Password    string                   `json:"password" validate:"lte=255"`
+		Timezone    *string                  `json:"timezone" validate:"omitnil,timezone"`
+		Locale      *string                  `json:"locale" validate:"omitnil,bcp47_language_tag"`
+		Preferences UpdatePreferencesRequest `json:"preferences"`
+	}
+
+	type UpdateMeResponse struct {
+		User
+		// left out in cookie mode, where it is set as a cookie
+		Token     string `json:"token,omitempty"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		data := UpdateMeRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		current, err := storage.GetById(ctx.Context(), FromContext(ctx).Id)
+		if err != nil {
+			return err
+		}
+
+		// emails are stored as submitted, so a change of the case is a change too
+		emailChanged := data.Email != nil && *data.Email != current.Email
+		if emailChanged {
+			err = confirmPassword(ctx, storage, current, data.Password)
+			if err != nil {
+				return err
+			}
+		} else {
+			data.Email = nil
+		}
+
+		user, err := storage.UpdateProfile(ctx.Context(), current.Id, ProfileUpdate{
+			Name:      data.Name,
+			Email:     data.Email,
+			Timezone:  data.Timezone,
+			Locale:    data.Locale,
+			SortBy:    data.Preferences.SortBy,
+			SortOrder: data.Preferences.SortOrder,
+		})
+		if err != nil {
+			if errors.Is(err, AlreadyExists) {
+				return fiber.NewError(fiber.StatusBadRequest, "email is already taken")
+			}
+			return err
+		}
+
+		if emailChanged {
+			notifyEmailChanged(ctx, config, storage, mailer, current, user)
+		}
+
+		claims, err := tokenClaims(ctx)
+		if err != nil {
+			return err
+		}
+		token, err := GetToken(user, claims.SessionId, claims.dpopJkt(), keyring, config.AccessTokenTTL)
+		if err != nil {
+			return err
+		}
+		if expiresAt, err := claims.GetExpirationTime(); err == nil && expiresAt != nil {
+			err = revocations.Revoke(ctx.Context(), user.Id, claims.ID, expiresAt.Time)
+			if err != nil {
+				return err
+			}
+		}
+
+		tokens, err := setAuthCookies(ctx, config, Tokens{Token: token, ExpiresIn: int(config.AccessTokenTTL.Seconds())})
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(UpdateMeResponse{User: user, Token: tokens.Token, ExpiresIn: tokens.ExpiresIn})
+	}
+}
+
+// notifyEmailChanged sends the verification link to the new address and a notice to the old one.
+// Failures are only logged, the change is already saved.
+func notifyEmailChanged(ctx fiber.Ctx, config *config.AppConfig, storage Storage, mailer mail.Mailer, before User, after User) {
+	_, err := storage.MarkVerificationSent(ctx.Context(), after.Id, verificationResendInterval)
+	if err == nil {
+		err = sendVerification(ctx.Context(), config, mailer, after)
+	}
+	if err != nil {
+		log.Printf("verification mail to %s failed: %v", after.Email, err)
+	}
+
+	err = mailer.Send(ctx.Context(), mail.Message{
+		To:      before.Email,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nthe email address of your account was changed to %s.\n\nIf it was not you, reset your password and contact us.",
+			before.Name, after.Email,
+		),
+	})
+	if err != nil {
+		log.Printf("email change notice to %s failed: %v", before.Email, err)
+	}
+}
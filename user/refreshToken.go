@@ -0,0 +1,159 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"time"
+)
+
+var (
+	RefreshTokenInvalid = errors.New("invalid or expired refresh token")
+	RefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// RefreshToken is an opaque token that can be exchanged once for a new access token.
+// Tokens issued from the same login share a family, the id of its Session.
+// Reusing one of them revokes the whole session.
+type RefreshToken struct {
+	Id        string
+	FamilyId  string
+	UserId    Id
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+const refreshTokenColumns = "id, family_id, user_id, created_at, expires_at, used_at, revoked_at"
+
+func scanRefreshToken(row rowScanner) (RefreshToken, error) {
+	var token RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	err := row.Scan(&token.Id, &token.FamilyId, &token.UserId, &token.CreatedAt, &token.ExpiresAt, &usedAt, &revokedAt)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return token, nil
+}
+
+// Usable reports whether the token can still be redeemed.
+func (t RefreshToken) Usable() bool {
+	return t.UsedAt == nil && t.RevokedAt == nil && t.ExpiresAt.After(time.Now())
+}
+
+func (s SqliteUsersStorage) CreateRefreshToken(ctx context.Context, userId Id, familyId string, tokenHash string, expiresAt time.Time) (RefreshToken, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		INSERT INTO refresh_tokens (id, family_id, user_id, token_hash, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING `+refreshTokenColumns)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer stmt.Close()
+
+	return scanRefreshToken(stmt.QueryRowContext(ctx, ulid.Make().String(), familyId, userId, tokenHash, expiresAt.UTC()))
+}
+
+// GetRefreshToken returns the token by its hash, also when it was used or revoked already.
+func (s SqliteUsersStorage) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	token, err := scanRefreshToken(s.db.QueryRowContext(ctx,
+		"SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE token_hash=?", tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, RefreshTokenInvalid
+		}
+		return RefreshToken{}, err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken marks the token as used and stores its successor in the same family.
+// Presenting a token that was already used revokes the session and returns the token with RefreshTokenReused.
+func (s SqliteUsersStorage) RotateRefreshToken(ctx context.Context, tokenHash string, newTokenHash string, expiresAt time.Time) (RefreshToken, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	current, err := scanRefreshToken(tx.QueryRowContext(ctx,
+		"SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE token_hash=?", tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, RefreshTokenInvalid
+		}
+		return RefreshToken{}, err
+	}
+	if current.RevokedAt != nil || current.ExpiresAt.Before(time.Now()) {
+		return RefreshToken{}, RefreshTokenInvalid
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET used_at=CURRENT_TIMESTAMP WHERE id=? AND used_at IS NULL", current.Id)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if affected == 0 {
+		if err = revokeSessionTx(ctx, tx, current.FamilyId); err != nil {
+			return RefreshToken{}, err
+		}
+		if err = tx.Commit(); err != nil {
+			return RefreshToken{}, err
+		}
+		return current, RefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE sessions SET last_seen_at=CURRENT_TIMESTAMP, expires_at=? WHERE id=?", expiresAt.UTC(), current.FamilyId)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	next, err := scanRefreshToken(tx.QueryRowContext(ctx, `
+		INSERT INTO refresh_tokens (id, family_id, user_id, token_hash, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING `+refreshTokenColumns,
+		ulid.Make().String(), current.FamilyId, current.UserId, newTokenHash, expiresAt.UTC()))
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	return next, tx.Commit()
+}
+
+// RevokeRefreshToken revokes the session of the given refresh token if it belongs to the user.
+func (s SqliteUsersStorage) RevokeRefreshToken(ctx context.Context, userId Id, tokenHash string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var familyId string
+	err = tx.QueryRowContext(ctx,
+		"SELECT family_id FROM refresh_tokens WHERE token_hash=? AND user_id=?", tokenHash, userId).Scan(&familyId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	if err = revokeSessionTx(ctx, tx, familyId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
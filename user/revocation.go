@@ -0,0 +1,298 @@
+package user
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Revocations lists what makes an otherwise valid access token unusable:
+// its jti, its session or a token generation of the user higher than the one in the token.
+type Revocations struct {
+	Tokens      map[string]time.Time
+	Sessions    map[string]bool
+	Generations map[Id]int
+}
+
+// RevocationList keeps the revocations in memory, so validating a token does not need a query.
+// It is reloaded from the database periodically, since in prefork mode every process holds its own copy.
+type RevocationList struct {
+	storage  Storage
+	tokenTTL time.Duration
+	leeway   time.Duration
+	mu       sync.RWMutex
+	current  Revocations
+}
+
+// NewRevocationList creates a list for access tokens living at most tokenTTL, accepted for leeway after they expire;
+// revoked sessions and tokens are only kept for that long.
+func NewRevocationList(storage Storage, tokenTTL time.Duration, leeway time.Duration) *RevocationList {
+	return &RevocationList{
+		storage:  storage,
+		tokenTTL: tokenTTL,
+		leeway:   leeway,
+		current: Revocations{
+			Tokens:      map[string]time.Time{},
+			Sessions:    map[string]bool{},
+			Generations: map[Id]int{},
+		},
+	}
+}
+
+func (l *RevocationList) Reload(ctx context.Context) error {
+	revocations, err := l.storage.GetRevocations(ctx, time.Now().Add(-l.tokenTTL-l.leeway))
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.current = revocations
+	return nil
+}
+
+// Run removes expired tokens from the database and reloads the list every interval. It never returns.
+func (l *RevocationList) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx := context.Background()
+		if err := l.storage.DeleteExpiredTokens(ctx); err != nil {
+			log.Printf("revocation list: cleanup failed: %v", err)
+		}
+		if err := l.Reload(ctx); err != nil {
+			log.Printf("revocation list: reload failed: %v", err)
+		}
+	}
+}
+
+// Revoke invalidates a single access token until it expires.
+func (l *RevocationList) Revoke(ctx context.Context, userId Id, jti string, expiresAt time.Time) error {
+	expiresAt = expiresAt.Add(l.leeway)
+	err := l.storage.RevokeToken(ctx, jti, userId, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.current.Tokens[jti] = expiresAt
+	return nil
+}
+
+// RevokeSession ends a session of the user, invalidating its access and refresh tokens.
+func (l *RevocationList) RevokeSession(ctx context.Context, userId Id, sessionId string) error {
+	err := l.storage.RevokeSession(ctx, userId, sessionId)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.current.Sessions[sessionId] = true
+	return nil
+}
+
+// MarkSessionRevoked records a session the storage revoked by itself, on the reuse of a refresh token
+// or an authorization code, so its access tokens stop working before the next reload.
+func (l *RevocationList) MarkSessionRevoked(sessionId string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.current.Sessions[sessionId] = true
+}
+
+// RevokeOtherSessions ends every session of the user except keep.
+func (l *RevocationList) RevokeOtherSessions(ctx context.Context, userId Id, keep string) error {
+	ids, err := l.storage.RevokeOtherSessions(ctx, userId, keep)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		l.current.Sessions[id] = true
+	}
+	return nil
+}
+
+// DeleteOauthClient removes a client of the owner and ends every session granted to it.
+func (l *RevocationList) DeleteOauthClient(ctx context.Context, ownerId Id, clientId string) error {
+	ids, err := l.storage.DeleteOauthClient(ctx, ownerId, clientId)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		l.current.Sessions[id] = true
+	}
+	return nil
+}
+
+// RevokeAll invalidates every access and refresh token issued to the user so far.
+func (l *RevocationList) RevokeAll(ctx context.Context, userId Id) error {
+	generation, err := l.storage.RevokeAllTokens(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.current.Generations[userId] = generation
+	return nil
+}
+
+func (l *RevocationList) IsRevoked(userId Id, jti string, sessionId string, generation int) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if generation < l.current.Generations[userId] || l.current.Sessions[sessionId] {
+		return true
+	}
+	_, revoked := l.current.Tokens[jti]
+	return revoked
+}
+
+func (s SqliteUsersStorage) RevokeToken(ctx context.Context, jti string, userId Id, expiresAt time.Time) error {
+	stmt, err := s.db.PrepareContext(ctx, "INSERT OR IGNORE INTO revoked_tokens (jti, user_id, expires_at) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, jti, userId, expiresAt.UTC())
+	return err
+}
+
+// RevokeAllTokens increases the token generation of the user and revokes all their sessions and refresh tokens.
+// It returns the new generation.
+func (s SqliteUsersStorage) RevokeAllTokens(ctx context.Context, userId Id) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var generation int
+	err = tx.QueryRowContext(ctx,
+		"UPDATE users SET token_generation=token_generation+1 WHERE id=? RETURNING token_generation", userId).Scan(&generation)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at=CURRENT_TIMESTAMP WHERE user_id=? AND revoked_at IS NULL", userId)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at=CURRENT_TIMESTAMP WHERE user_id=? AND revoked_at IS NULL", userId)
+	if err != nil {
+		return 0, err
+	}
+
+	return generation, tx.Commit()
+}
+
+// GetRevocations returns the revoked tokens that have not expired yet, sessions revoked after since
+// and the token generations of users who revoked all their tokens.
+func (s SqliteUsersStorage) GetRevocations(ctx context.Context, since time.Time) (Revocations, error) {
+	revocations := Revocations{
+		Tokens:      map[string]time.Time{},
+		Sessions:    map[string]bool{},
+		Generations: map[Id]int{},
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT jti, expires_at FROM revoked_tokens WHERE expires_at>?", time.Now().UTC())
+	if err != nil {
+		return Revocations{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		if err = rows.Scan(&jti, &expiresAt); err != nil {
+			return Revocations{}, err
+		}
+		revocations.Tokens[jti] = expiresAt
+	}
+	if err = rows.Err(); err != nil {
+		return Revocations{}, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, "SELECT id FROM sessions WHERE revoked_at>datetime(?)", since.UTC())
+	if err != nil {
+		return Revocations{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return Revocations{}, err
+		}
+		revocations.Sessions[id] = true
+	}
+	if err = rows.Err(); err != nil {
+		return Revocations{}, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, "SELECT id, token_generation FROM users WHERE token_generation>0")
+	if err != nil {
+		return Revocations{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id Id
+		var generation int
+		if err = rows.Scan(&id, &generation); err != nil {
+			return Revocations{}, err
+		}
+		revocations.Generations[id] = generation
+	}
+	return revocations, rows.Err()
+}
+
+func (s SqliteUsersStorage) DeleteExpiredTokens(ctx context.Context) error {
+	now := time.Now().UTC()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at<=?", now)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at<=?", now)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at<=?", now)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE expires_at<=?", now)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM oidc_states WHERE expires_at<=?", now)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM oauth_codes WHERE expires_at<=?", now)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM dpop_proofs WHERE expires_at<=?", now)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM login_failures WHERE last_failure_at<=?",
+		now.Add(-max(loginFailureWindow, accountThrottle.lockFor, ipThrottle.lockFor)))
+	return err
+}
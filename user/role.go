@@ -0,0 +1,31 @@
+package user
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v3"
+)
+
+// Role is the instance wide role of a user, unrelated to the roles in workspaces.
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+var AccountDisabled = errors.New("account is disabled")
+
+func (r Role) Valid() bool {
+	return r == RoleUser || r == RoleAdmin
+}
+
+// RequireRole rejects requests of users without the role. The role is read from the access token,
+// so role changes revoke the tokens of the user, see UpdateRoleHandler.
+func RequireRole(role Role) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if FromContext(c).Role != role {
+			return fiber.NewError(fiber.StatusForbidden, "this endpoint requires the "+string(role)+" role")
+		}
+		return c.Next()
+	}
+}
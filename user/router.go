@@ -4,25 +4,85 @@ import (
 	"errors"
 	"github.com/gofiber/fiber/v3"
 	"log"
+	"time"
 	"todo-api/config"
+	"todo-api/mail"
+	"todo-api/oidc"
 	"todo-api/utils"
 )
 
-func SetupRoutes(app *fiber.App, config *config.AppConfig, storage Storage, validator *utils.AppValidator) {
-	app.Post("/register", Register(config, storage, validator))
-	app.Post("/login", Login(config, storage, validator))
+func SetupRoutes(app *fiber.App, config *config.AppConfig, storage Storage, keyring *Keyring, revocations *RevocationList, passwords *PasswordPolicy, provider *oidc.Provider, auth fiber.Handler, mailer mail.Mailer, validator *utils.AppValidator) {
+	app.Get("/.well-known/jwks.json", JWKSHandler(keyring))
+	// tokens issued with a DPoP proof are bound to its key
+	dpop := NewDPoPConfig(config, storage)
+	proof := DPoPProofMiddleware(dpop, func(ctx fiber.Ctx, err error) error {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	})
+	oauthProof := DPoPProofMiddleware(dpop, func(ctx fiber.Ctx, err error) error {
+		return sendOauthError(ctx, &oauthError{Code: "invalid_dpop_proof", Description: err.Error()})
+	})
+	app.Post("/register", Register(config, storage, keyring, passwords, mailer, validator), proof)
+	app.Post("/login", Login(config, storage, keyring, validator), proof)
+	app.Post("/login/mfa", LoginMfaHandler(config, storage, keyring, validator), proof)
+	app.Post("/token/refresh", Refresh(config, storage, keyring, revocations, validator), proof)
+	app.Post("/logout", Logout(config, storage, revocations, validator), auth, RequireSession)
+	app.Post("/logout-all", LogoutAll(config, revocations), auth, RequireSession)
+	app.Get("/sessions", GetSessionsHandler(storage), auth, RequireSession)
+	app.Delete("/sessions/:id", RevokeSessionHandler(revocations), auth, RequireSession)
+	app.Post("/tokens", CreateAccessTokenHandler(storage, validator), auth, RequireSession)
+	app.Get("/tokens", GetAccessTokensHandler(storage), auth, RequireSession)
+	app.Delete("/tokens/:id", DeleteAccessTokenHandler(storage), auth, RequireSession)
+	app.Get("/me", GetMeHandler(storage), auth)
+	app.Patch("/me", UpdateMeHandler(config, storage, keyring, revocations, mailer, validator), auth, RequireSession)
+	app.Delete("/me", DeleteMeHandler(config, storage, revocations, validator), auth, RequireSession)
+	app.Post("/account/restore", RestoreAccountHandler(config, storage, validator))
+	app.Post("/me/password", ChangePasswordHandler(storage, revocations, passwords, validator), auth, RequireSession)
+	app.Post("/me/2fa/totp", EnrollTotpHandler(storage), auth, RequireSession)
+	app.Post("/me/2fa/totp/confirm", ConfirmTotpHandler(storage, validator), auth, RequireSession)
+	app.Delete("/me/2fa/totp", DisableTwoFactorHandler(storage, validator), auth, RequireSession)
+	app.Post("/me/2fa/recovery-codes", RegenerateRecoveryCodesHandler(storage, validator), auth, RequireSession)
+	app.Post("/password/forgot", ForgotPasswordHandler(config, storage, mailer, validator))
+	app.Post("/password/reset", ResetPasswordHandler(storage, revocations, passwords, validator))
+	app.Post("/verify-email", VerifyEmailHandler(config, storage))
+	app.Post("/verify-email/resend", ResendVerificationHandler(config, storage, mailer), auth, RequireSession)
+	app.Get("/me/identities", GetIdentitiesHandler(storage), auth, RequireSession)
+	app.Delete("/me/identities/:id", UnlinkIdentityHandler(storage), auth, RequireSession)
+	if provider != nil {
+		app.Get("/auth/oidc/login", OidcLoginHandler(config, storage, provider, validator))
+		app.Get("/auth/oidc/callback", OidcCallbackHandler(config, storage, keyring, provider))
+		app.Post("/me/identities", LinkIdentityHandler(config, storage, provider), auth, RequireSession)
+	}
+
+	app.Get("/oauth/authorize", AuthorizeHandler(config, storage))
+	app.Get("/oauth/consent", ConsentHandler(storage), auth, RequireSession)
+	app.Post("/oauth/consent", ConsentDecisionHandler(storage), auth, RequireSession)
+	app.Post("/oauth/token", TokenHandler(config, storage, keyring, revocations), oauthProof)
+	app.Post("/oauth/revoke", RevokeTokenHandler(storage, keyring, revocations))
+	app.Post("/oauth/introspect", IntrospectTokenHandler(storage, keyring, revocations))
+	app.Post("/oauth/clients", RegisterOauthClientHandler(storage, validator), auth, RequireSession)
+	app.Get("/oauth/clients", GetOauthClientsHandler(storage), auth, RequireSession)
+	app.Delete("/oauth/clients/:id", DeleteOauthClientHandler(revocations), auth, RequireSession)
+
+	admin := app.Group("/admin", auth, RequireSession, RequireRole(RoleAdmin))
+	admin.Get("/users", ListUsersHandler(storage, validator))
+	admin.Get("/users/:id", GetUserHandler(storage))
+	admin.Put("/users/:id/role", UpdateRoleHandler(storage, revocations, validator))
+	admin.Post("/users/:id/disable", DisableUserHandler(storage, revocations))
+	admin.Post("/users/:id/enable", EnableUserHandler(storage))
+	admin.Post("/users/:id/logout", ForceLogoutHandler(storage, revocations))
+	admin.Post("/users/:id/unlock", UnlockUserHandler(storage))
+	admin.Get("/stats", StatsHandler(storage))
 }
 
-func Register(config *config.AppConfig, storage Storage, validator *utils.AppValidator) fiber.Handler {
+func Register(config *config.AppConfig, storage Storage, keyring *Keyring, passwords *PasswordPolicy, mailer mail.Mailer, validator *utils.AppValidator) fiber.Handler {
 	type RegistrationRequest struct {
-		Email    string `json:"email" validate:"required,email"`
-		Password string `json:"password" validate:"required,gte=4,lte=255"`
-		Name     string `json:"name" validate:"required,gte=2,lte=255"`
+		Email      string `json:"email" validate:"required,email"`
+		Password   string `json:"password" validate:"required,lte=255"`
+		Name       string `json:"name" validate:"required,gte=2,lte=255"`
+		DeviceName string `json:"device_name" validate:"lte=255"`
 	}
 
-	type RegistrationResponse struct {
-		Token string `json:"token"`
-	}
+	type RegistrationResponse Tokens
 
 	return func(ctx fiber.Ctx) error {
 		data := RegistrationRequest{}
@@ -34,12 +94,15 @@ func Register(config *config.AppConfig, storage Storage, validator *utils.AppVal
 		if err != nil {
 			return err
 		}
+		err = passwords.Check("Password", data.Password, data.Email, data.Name)
+		if err != nil {
+			return err
+		}
 
 		passwordHash, err := utils.HashPassword(data.Password)
 		if err != nil {
 			return err
 		}
-		log.Printf("password:%s passwordHash: %s", data.Password, passwordHash)
 
 		user, err := storage.Create(ctx.Context(), data.Email, passwordHash, data.Name)
 		if err != nil {
@@ -49,24 +112,40 @@ func Register(config *config.AppConfig, storage Storage, validator *utils.AppVal
 			return fiber.ErrInternalServerError
 		}
 
-		token, err := GetToken(user, config.JwtSecret)
+		_, err = storage.MarkVerificationSent(ctx.Context(), user.Id, verificationResendInterval)
 		if err != nil {
 			return err
 		}
+		err = sendVerification(ctx.Context(), config, mailer, user)
+		if err != nil {
+			log.Printf("verification mail to %s failed: %v", user.Email, err)
+		}
 
-		return ctx.JSON(RegistrationResponse{Token: token})
+		tokens, err := issueTokens(ctx.Context(), config, storage, keyring, user, deviceFromRequest(ctx, data.DeviceName))
+		if err != nil {
+			return err
+		}
+
+		tokens, err = setAuthCookies(ctx, config, tokens)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(RegistrationResponse(tokens))
 	}
 }
 
-func Login(config *config.AppConfig, storage Storage, validator *utils.AppValidator) fiber.Handler {
+func Login(config *config.AppConfig, storage Storage, keyring *Keyring, validator *utils.AppValidator) fiber.Handler {
 	type LoginRequest struct {
-		Email    string `json:"email" validate:"required,email"`
-		Password string `json:"password" validate:"required,gte=4,lte=255"`
+		Email      string `json:"email" validate:"required,email"`
+		Password   string `json:"password" validate:"required,gte=4,lte=255"`
+		DeviceName string `json:"device_name" validate:"lte=255"`
 	}
 
-	type LoginResponse struct {
-		Token string `json:"token"`
-	}
+	type LoginResponse Tokens
+
+	// returned instead of the tokens when the user has two-factor authentication, see LoginMfaHandler
+	type MfaRequiredResponse mfaRequired
 
 	return func(ctx fiber.Ctx) error {
 		data := LoginRequest{}
@@ -79,27 +158,285 @@ func Login(config *config.AppConfig, storage Storage, validator *utils.AppValida
 			return err
 		}
 
+		err = reserveLoginAttempt(ctx, storage, data.Email)
+		if err != nil {
+			return err
+		}
+
 		user, err := storage.GetUserByEmail(ctx.Context(), data.Email)
+		if err != nil && !errors.Is(err, NotFound) {
+			return err
+		}
+		passwordHash := user.passwordHash
+		if errors.Is(err, NotFound) {
+			passwordHash = dummyPasswordHash()
+		}
+
+		isValid, err := utils.ComparePassword(data.Password, passwordHash)
+		if err != nil {
+			return err
+		}
+		if !isValid || user.Id == "" {
+			// the failure was counted by reserveLoginAttempt
+			return fiber.NewError(fiber.StatusBadRequest, "wrong email or password")
+		}
+		err = releaseLoginAttempt(ctx, storage, data.Email)
+		if err != nil {
+			return err
+		}
+
+		if user.deletedAt != nil {
+			return fiber.NewError(fiber.StatusForbidden, "account is scheduled for deletion, restore it at /account/restore")
+		}
+		if user.disabledAt != nil {
+			return fiber.NewError(fiber.StatusForbidden, AccountDisabled.Error())
+		}
+
+		if utils.NeedsRehash(user.passwordHash) {
+			rehashPassword(ctx.Context(), storage, user, data.Password)
+		}
+
+		if user.TwoFactor {
+			challenge, err := newMfaChallenge(ctx.Context(), storage, user.Id, data.DeviceName)
+			if err != nil {
+				return err
+			}
+			return ctx.JSON(MfaRequiredResponse(challenge))
+		}
+
+		err = clearLoginFailures(ctx, storage, user.Email)
+		if err != nil {
+			return err
+		}
+
+		tokens, err := issueTokens(ctx.Context(), config, storage, keyring, user, deviceFromRequest(ctx, data.DeviceName))
+		if err != nil {
+			return err
+		}
+
+		tokens, err = setAuthCookies(ctx, config, tokens)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(LoginResponse(tokens))
+	}
+}
+
+func Refresh(config *config.AppConfig, storage Storage, keyring *Keyring, revocations *RevocationList, validator *utils.AppValidator) fiber.Handler {
+	type RefreshRequest struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}
+
+	type RefreshResponse Tokens
+
+	return func(ctx fiber.Ctx) error {
+		data := RefreshRequest{}
+		if len(ctx.Body()) > 0 {
+			err := ctx.Bind().Body(&data)
+			if err != nil {
+				return err
+			}
+		}
+		// in cookie mode browsers send the refresh token as a cookie
+		if data.RefreshToken == "" && config.CookieAuth() {
+			data.RefreshToken = ctx.Cookies(refreshTokenCookie)
+		}
+		err := validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		// tokens granted to OAuth clients are refreshed by the client, at /oauth/token
+		_, session, err := refreshTokenSession(ctx.Context(), storage, utils.HashToken(data.RefreshToken))
+		if err != nil {
+			return err
+		}
+		if session.ClientId != "" {
+			return fiber.NewError(fiber.StatusUnauthorized, RefreshTokenInvalid.Error())
+		}
+		if !provesDpopKey(ctx, session) {
+			return fiber.NewError(fiber.StatusUnauthorized, "the refresh token is bound to a DPoP key, send a proof signed by it")
+		}
+
+		refreshToken, err := utils.NewRandomToken()
+		if err != nil {
+			return err
+		}
+
+		next, err := storage.RotateRefreshToken(ctx.Context(), utils.HashToken(data.RefreshToken), utils.HashToken(refreshToken), time.Now().Add(config.RefreshTokenTTL))
+		if err != nil {
+			if errors.Is(err, RefreshTokenReused) {
+				// the session was revoked with the stolen family, its access tokens too
+				revocations.MarkSessionRevoked(next.FamilyId)
+			}
+			if errors.Is(err, RefreshTokenInvalid) || errors.Is(err, RefreshTokenReused) {
+				return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+			}
+			return err
+		}
+
+		user, err := storage.GetById(ctx.Context(), next.UserId)
 		if err != nil {
 			if errors.Is(err, NotFound) {
-				return fiber.NewError(fiber.StatusBadRequest, "wrong email or password")
+				return fiber.NewError(fiber.StatusUnauthorized, RefreshTokenInvalid.Error())
 			}
 			return err
 		}
+		if user.disabledAt != nil {
+			return fiber.NewError(fiber.StatusForbidden, AccountDisabled.Error())
+		}
 
-		isValid, err := utils.ComparePassword(data.Password, user.passwordHash)
+		tokens, err := newTokens(config, keyring, user, next.FamilyId, session.DpopJkt, refreshToken)
 		if err != nil {
 			return err
 		}
-		if !isValid {
-			return fiber.NewError(fiber.StatusBadRequest, "wrong email or password")
+
+		tokens, err = setAuthCookies(ctx, config, tokens)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(RefreshResponse(tokens))
+	}
+}
+
+// Logout ends the session of the access token. Tokens issued without a session are revoked on their own,
+// together with the refresh token from the body.
+func Logout(config *config.AppConfig, storage Storage, revocations *RevocationList, validator *utils.AppValidator) fiber.Handler {
+	type LogoutRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	type LogoutResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user := FromContext(ctx)
+
+		data := LogoutRequest{}
+		if len(ctx.Body()) > 0 {
+			err := ctx.Bind().Body(&data)
+			if err != nil {
+				return err
+			}
+		}
+		err := validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		claims, err := tokenClaims(ctx)
+		if err != nil {
+			return err
+		}
+
+		if claims.SessionId != "" {
+			err = revocations.RevokeSession(ctx.Context(), user.Id, claims.SessionId)
+			if err != nil && !errors.Is(err, SessionNotFound) {
+				return err
+			}
+			clearAuthCookies(ctx, config)
+			return ctx.JSON(LogoutResponse{})
+		}
+
+		expiresAt, err := claims.GetExpirationTime()
+		if err != nil || expiresAt == nil {
+			return fiber.NewError(fiber.StatusBadRequest, "token cannot be revoked on its own, use /logout-all")
+		}
+
+		err = revocations.Revoke(ctx.Context(), user.Id, claims.ID, expiresAt.Time)
+		if err != nil {
+			return err
+		}
+
+		if data.RefreshToken != "" {
+			err = storage.RevokeRefreshToken(ctx.Context(), user.Id, utils.HashToken(data.RefreshToken))
+			if err != nil {
+				return err
+			}
+		}
+
+		clearAuthCookies(ctx, config)
+		return ctx.JSON(LogoutResponse{})
+	}
+}
+
+func LogoutAll(config *config.AppConfig, revocations *RevocationList) fiber.Handler {
+	type LogoutAllResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user := FromContext(ctx)
+
+		err := revocations.RevokeAll(ctx.Context(), user.Id)
+		if err != nil {
+			return err
 		}
 
-		token, err := GetToken(user, config.JwtSecret)
+		clearAuthCookies(ctx, config)
+		return ctx.JSON(LogoutAllResponse{})
+	}
+}
+
+func GetSessionsHandler(storage Storage) fiber.Handler {
+	type SessionDto struct {
+		Session
+		Current bool `json:"current"`
+	}
+
+	type GetSessionsResponse struct {
+		Data []SessionDto `json:"data"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user := FromContext(ctx)
+
+		claims, err := tokenClaims(ctx)
 		if err != nil {
 			return err
 		}
 
-		return ctx.JSON(LoginResponse{Token: token})
+		sessions, err := storage.GetSessions(ctx.Context(), user.Id)
+		if err != nil {
+			return err
+		}
+
+		response := GetSessionsResponse{Data: make([]SessionDto, 0, len(sessions))}
+		for _, session := range sessions {
+			response.Data = append(response.Data, SessionDto{Session: session, Current: session.Id == claims.SessionId})
+		}
+		return ctx.JSON(response)
+	}
+}
+
+func RevokeSessionHandler(revocations *RevocationList) fiber.Handler {
+	type RevokeSessionResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user := FromContext(ctx)
+
+		err := revocations.RevokeSession(ctx.Context(), user.Id, ctx.Params("id", ""))
+		if err != nil {
+			if errors.Is(err, SessionNotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return err
+		}
+
+		return ctx.JSON(RevokeSessionResponse{})
+	}
+}
+
+// JWKSHandler publishes the public keys that verify access tokens, so other services can check them without the secret.
+func JWKSHandler(keyring *Keyring) fiber.Handler {
+	type JWKSResponse struct {
+		Keys []JWK `json:"keys"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		ctx.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return ctx.JSON(JWKSResponse{Keys: keyring.JWKS()})
 	}
 }
@@ -0,0 +1,175 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"github.com/oklog/ulid/v2"
+	"strings"
+	"time"
+)
+
+var SessionNotFound = errors.New("session not found")
+
+// Device describes the client a session was started from.
+type Device struct {
+	Name      string
+	UserAgent string
+	Ip        string
+	// DpopJkt is the thumbprint of the key the device proved to hold, see DPoPProofMiddleware
+	DpopJkt string
+}
+
+func deviceFromRequest(ctx fiber.Ctx, name string) Device {
+	userAgent := ctx.Get(fiber.HeaderUserAgent)
+	if name == "" {
+		name = userAgent
+	}
+	return Device{Name: name, UserAgent: userAgent, Ip: ctx.IP(), DpopJkt: dpopThumbprint(ctx)}
+}
+
+// Session is a single login of a user. Its id is also the family id of the refresh tokens
+// issued for it and is carried in the sid claim of access tokens.
+// Sessions granted to an OAuth client carry its id and are limited to Scopes.
+// The tokens of a session with a DpopJkt are bound to that key.
+type Session struct {
+	Id         string    `json:"id"`
+	UserId     Id        `json:"-"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	Ip         string    `json:"ip"`
+	ClientId   string    `json:"client_id,omitempty"`
+	Scopes     []string  `json:"scopes,omitempty"`
+	DpopJkt    string    `json:"dpop_jkt,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+const sessionColumns = "id, user_id, device_name, user_agent, ip, client_id, scope, dpop_jkt, created_at, last_seen_at, expires_at"
+
+func scanSession(row rowScanner) (Session, error) {
+	var session Session
+	var clientId sql.NullString
+	var scope string
+	err := row.Scan(&session.Id, &session.UserId, &session.DeviceName, &session.UserAgent, &session.Ip,
+		&clientId, &scope, &session.DpopJkt, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	session.ClientId = clientId.String
+	session.Scopes = strings.Fields(scope)
+	return session, err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func (s SqliteUsersStorage) CreateSession(ctx context.Context, userId Id, device Device, expiresAt time.Time) (Session, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		INSERT INTO sessions (id, user_id, device_name, user_agent, ip, dpop_jkt, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING `+sessionColumns)
+	if err != nil {
+		return Session{}, err
+	}
+	defer stmt.Close()
+
+	return scanSession(stmt.QueryRowContext(ctx,
+		ulid.Make().String(), userId, device.Name, device.UserAgent, device.Ip, device.DpopJkt, expiresAt.UTC()))
+}
+
+// CreateGrantSession starts the session of an OAuth client the user granted the scopes to.
+func (s SqliteUsersStorage) CreateGrantSession(ctx context.Context, userId Id, clientId string, scopes []string, device Device, expiresAt time.Time) (Session, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		INSERT INTO sessions (id, user_id, device_name, user_agent, ip, client_id, scope, dpop_jkt, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING `+sessionColumns)
+	if err != nil {
+		return Session{}, err
+	}
+	defer stmt.Close()
+
+	return scanSession(stmt.QueryRowContext(ctx,
+		ulid.Make().String(), userId, device.Name, device.UserAgent, device.Ip, clientId, strings.Join(scopes, " "), device.DpopJkt,
+		expiresAt.UTC()))
+}
+
+// GetSession returns the session if it is neither revoked nor expired.
+func (s SqliteUsersStorage) GetSession(ctx context.Context, id string) (Session, error) {
+	session, err := scanSession(s.db.QueryRowContext(ctx,
+		"SELECT "+sessionColumns+" FROM sessions WHERE id=? AND revoked_at IS NULL AND expires_at>?", id, time.Now().UTC()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Session{}, SessionNotFound
+		}
+		return Session{}, err
+	}
+	return session, nil
+}
+
+// GetSessions returns the sessions of the user that are neither revoked nor expired, most recently used first.
+func (s SqliteUsersStorage) GetSessions(ctx context.Context, userId Id) ([]Session, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		SELECT `+sessionColumns+` FROM sessions
+		WHERE user_id=? AND revoked_at IS NULL AND expires_at>?
+		ORDER BY last_seen_at DESC, id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, userId, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes the session of the user together with its refresh tokens.
+func (s SqliteUsersStorage) RevokeSession(ctx context.Context, userId Id, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT TRUE FROM sessions WHERE id=? AND user_id=? AND revoked_at IS NULL", id, userId).Scan(&exists)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return SessionNotFound
+		}
+		return err
+	}
+
+	if err = revokeSessionTx(ctx, tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// revokeSessionTx revokes the session and every refresh token of its family.
+func revokeSessionTx(ctx context.Context, tx *sql.Tx, id string) error {
+	_, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at=CURRENT_TIMESTAMP WHERE family_id=? AND revoked_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at=CURRENT_TIMESTAMP WHERE id=? AND revoked_at IS NULL", id)
+	return err
+}
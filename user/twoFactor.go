@@ -0,0 +1,339 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"strings"
+	"time"
+	"todo-api/utils"
+)
+
+const (
+	// totpIssuer names the account in authenticator apps
+	totpIssuer         = "ToDo API"
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+	// mfaChallengeTTL is how long the second login step can be completed
+	mfaChallengeTTL         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+)
+
+var (
+	TwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	TwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	MfaChallengeInvalid     = errors.New("invalid or expired mfa token")
+)
+
+// RecoveryCode is a single-use code replacing the TOTP code when the authenticator is lost.
+type RecoveryCode struct {
+	Id       string
+	codeHash string
+}
+
+// MfaChallenge is the pending second step of a login with two-factor authentication.
+type MfaChallenge struct {
+	Id         string
+	UserId     Id
+	DeviceName string
+}
+
+// mfaRequired is returned by a login instead of the tokens when the user has two-factor authentication.
+type mfaRequired struct {
+	MfaRequired bool   `json:"mfa_required"`
+	MfaToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// newMfaChallenge starts the second step of a login, completed by LoginMfaHandler.
+func newMfaChallenge(ctx context.Context, storage Storage, userId Id, deviceName string) (mfaRequired, error) {
+	mfaToken, err := utils.NewRandomToken()
+	if err != nil {
+		return mfaRequired{}, err
+	}
+	err = storage.CreateMfaChallenge(ctx, userId, utils.HashToken(mfaToken), deviceName, time.Now().Add(mfaChallengeTTL))
+	if err != nil {
+		return mfaRequired{}, err
+	}
+	return mfaRequired{MfaRequired: true, MfaToken: mfaToken, ExpiresIn: int(mfaChallengeTTL.Seconds())}, nil
+}
+
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns the codes to show to the user once and their hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeLength*5/8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := recoveryCodeEncoding.EncodeToString(b)
+
+		hash, err := utils.HashPassword(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		hashes[i] = hash
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed in upper case or without the dash.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// verifySecondFactor checks a TOTP code or uses up a recovery code of the user.
+func verifySecondFactor(ctx context.Context, storage Storage, user User, code string) (bool, error) {
+	if !user.TwoFactor {
+		return false, TwoFactorNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == utils.TotpDigits && strings.Trim(code, "0123456789") == "" {
+		step, ok, err := utils.ValidateTotp(user.totpSecret, code, time.Now())
+		if err != nil || !ok {
+			return false, err
+		}
+		return storage.UseTotpStep(ctx, user.Id, step)
+	}
+
+	recoveryCodes, err := storage.GetRecoveryCodes(ctx, user.Id)
+	if err != nil {
+		return false, err
+	}
+	code = normalizeRecoveryCode(code)
+	for _, recoveryCode := range recoveryCodes {
+		isValid, err := utils.ComparePassword(code, recoveryCode.codeHash)
+		if err != nil {
+			return false, err
+		}
+		if isValid {
+			return storage.UseRecoveryCode(ctx, recoveryCode.Id)
+		}
+	}
+	return false, nil
+}
+
+// SetTotpSecret starts the enrollment, the secret is not used for logins until EnableTwoFactor.
+func (s SqliteUsersStorage) SetTotpSecret(ctx context.Context, id Id, secret string) error {
+	stmt, err := s.db.PrepareContext(ctx, "UPDATE users SET totp_secret=? WHERE id=? AND totp_enabled_at IS NULL")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx, secret, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return TwoFactorAlreadyEnabled
+	}
+	return nil
+}
+
+// EnableTwoFactor confirms the enrollment with the step of the code the user entered.
+func (s SqliteUsersStorage) EnableTwoFactor(ctx context.Context, id Id, step int64, recoveryCodeHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users SET totp_enabled_at=CURRENT_TIMESTAMP, totp_last_step=?
+		WHERE id=? AND totp_enabled_at IS NULL AND totp_secret IS NOT NULL
+	`, step, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return TwoFactorAlreadyEnabled
+	}
+
+	err = replaceRecoveryCodesTx(ctx, tx, id, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s SqliteUsersStorage) DisableTwoFactor(ctx context.Context, id Id) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE users SET totp_secret=NULL, totp_enabled_at=NULL, totp_last_step=0 WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id=?", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTotpStep records an accepted TOTP code, it reports false if the step or a later one was used already.
+func (s SqliteUsersStorage) UseTotpStep(ctx context.Context, id Id, step int64) (bool, error) {
+	stmt, err := s.db.PrepareContext(ctx, "UPDATE users SET totp_last_step=? WHERE id=? AND totp_last_step<?")
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx, step, id, step)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// GetRecoveryCodes returns the unused recovery codes of the user.
+func (s SqliteUsersStorage) GetRecoveryCodes(ctx context.Context, userId Id) ([]RecoveryCode, error) {
+	stmt, err := s.db.PrepareContext(ctx, "SELECT id, code_hash FROM recovery_codes WHERE user_id=? AND used_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []RecoveryCode
+	for rows.Next() {
+		var code RecoveryCode
+		if err = rows.Scan(&code.Id, &code.codeHash); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, rows.Err()
+}
+
+// UseRecoveryCode marks the code as used, it reports false if it was used concurrently.
+func (s SqliteUsersStorage) UseRecoveryCode(ctx context.Context, id string) (bool, error) {
+	stmt, err := s.db.PrepareContext(ctx, "UPDATE recovery_codes SET used_at=CURRENT_TIMESTAMP WHERE id=? AND used_at IS NULL")
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+func (s SqliteUsersStorage) ReplaceRecoveryCodes(ctx context.Context, userId Id, codeHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodesTx(ctx, tx, userId, codeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodesTx(ctx context.Context, tx *sql.Tx, userId Id, codeHashes []string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id=?", userId)
+	if err != nil {
+		return err
+	}
+
+	for _, codeHash := range codeHashes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes (id, user_id, code_hash) VALUES (?, ?, ?)",
+			ulid.Make().String(), userId, codeHash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s SqliteUsersStorage) CreateMfaChallenge(ctx context.Context, userId Id, tokenHash string, deviceName string, expiresAt time.Time) error {
+	stmt, err := s.db.PrepareContext(ctx,
+		"INSERT INTO mfa_challenges (id, user_id, token_hash, device_name, expires_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, ulid.Make().String(), userId, tokenHash, deviceName, expiresAt.UTC())
+	return err
+}
+
+// GetMfaChallenge returns a challenge that is not used, expired or locked by too many wrong codes.
+func (s SqliteUsersStorage) GetMfaChallenge(ctx context.Context, tokenHash string) (MfaChallenge, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		SELECT id, user_id, device_name FROM mfa_challenges
+		WHERE token_hash=? AND used_at IS NULL AND expires_at>? AND attempts<?
+	`)
+	if err != nil {
+		return MfaChallenge{}, err
+	}
+	defer stmt.Close()
+
+	var challenge MfaChallenge
+	err = stmt.QueryRowContext(ctx, tokenHash, time.Now().UTC(), mfaChallengeMaxAttempts).
+		Scan(&challenge.Id, &challenge.UserId, &challenge.DeviceName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return MfaChallenge{}, MfaChallengeInvalid
+		}
+		return MfaChallenge{}, err
+	}
+	return challenge, nil
+}
+
+func (s SqliteUsersStorage) FailMfaChallenge(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE mfa_challenges SET attempts=attempts+1 WHERE id=?", id)
+	return err
+}
+
+func (s SqliteUsersStorage) CompleteMfaChallenge(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE mfa_challenges SET used_at=CURRENT_TIMESTAMP WHERE id=? AND used_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return MfaChallengeInvalid
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package user
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"time"
+	"todo-api/config"
+	"todo-api/utils"
+)
+
+// EnrollTotpHandler creates a new TOTP secret. Two-factor authentication is enabled after ConfirmTotpHandler.
+func EnrollTotpHandler(storage Storage) fiber.Handler {
+	type EnrollTotpResponse struct {
+		Secret string `json:"secret"`
+		Uri    string `json:"uri"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user, err := storage.GetById(ctx.Context(), FromContext(ctx).Id)
+		if err != nil {
+			return err
+		}
+		if user.TwoFactor {
+			return fiber.NewError(fiber.StatusBadRequest, TwoFactorAlreadyEnabled.Error())
+		}
+
+		secret, err := utils.NewTotpSecret()
+		if err != nil {
+			return err
+		}
+		err = storage.SetTotpSecret(ctx.Context(), user.Id, secret)
+		if err != nil {
+			if errors.Is(err, TwoFactorAlreadyEnabled) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			return err
+		}
+
+		return ctx.JSON(EnrollTotpResponse{Secret: secret, Uri: utils.TotpUri(totpIssuer, user.Email, secret)})
+	}
+}
+
+// ConfirmTotpHandler enables two-factor authentication with the first code from the authenticator app
+// and returns the recovery codes, they are shown only once.
+func ConfirmTotpHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
+	type ConfirmTotpRequest struct {
+		Code string `json:"code" validate:"required,numeric,len=6"`
+	}
+
+	type ConfirmTotpResponse struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		data := ConfirmTotpRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		user, err := storage.GetById(ctx.Context(), FromContext(ctx).Id)
+		if err != nil {
+			return err
+		}
+		if user.TwoFactor {
+			return fiber.NewError(fiber.StatusBadRequest, TwoFactorAlreadyEnabled.Error())
+		}
+		if user.totpSecret == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "start the enrollment first")
+		}
+
+		step, ok, err := utils.ValidateTotp(user.totpSecret, data.Code, time.Now())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "wrong code")
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			return err
+		}
+		err = storage.EnableTwoFactor(ctx.Context(), user.Id, step, hashes)
+		if err != nil {
+			if errors.Is(err, TwoFactorAlreadyEnabled) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			return err
+		}
+
+		return ctx.JSON(ConfirmTotpResponse{RecoveryCodes: codes})
+	}
+}
+
+// DisableTwoFactorHandler turns two-factor authentication off, it needs both the password and a code.
+func DisableTwoFactorHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
+	type DisableTwoFactorRequest struct {
+		Password string `json:"password" validate:"required,lte=255"`
+		Code     string `json:"code" validate:"required,lte=32"`
+	}
+
+	type DisableTwoFactorResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		data := DisableTwoFactorRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		user, err := storage.GetById(ctx.Context(), FromContext(ctx).Id)
+		if err != nil {
+			return err
+		}
+		err = checkPassword(ctx, storage, user, data.Password)
+		if err != nil {
+			return err
+		}
+
+		ok, err := verifySecondFactor(ctx.Context(), storage, user, data.Code)
+		if err != nil {
+			if errors.Is(err, TwoFactorNotEnabled) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			return err
+		}
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "wrong code")
+		}
+
+		err = storage.DisableTwoFactor(ctx.Context(), user.Id)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(DisableTwoFactorResponse{})
+	}
+}
+
+// RegenerateRecoveryCodesHandler replaces every recovery code of the user with new ones.
+func RegenerateRecoveryCodesHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
+	type RegenerateRecoveryCodesRequest struct {
+		Code string `json:"code" validate:"required,lte=32"`
+	}
+
+	type RegenerateRecoveryCodesResponse struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		data := RegenerateRecoveryCodesRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		user, err := storage.GetById(ctx.Context(), FromContext(ctx).Id)
+		if err != nil {
+			return err
+		}
+		ok, err := verifySecondFactor(ctx.Context(), storage, user, data.Code)
+		if err != nil {
+			if errors.Is(err, TwoFactorNotEnabled) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			return err
+		}
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "wrong code")
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			return err
+		}
+		err = storage.ReplaceRecoveryCodes(ctx.Context(), user.Id, hashes)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(RegenerateRecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// LoginMfaHandler completes a login of a user with two-factor authentication,
+// exchanging the mfa token returned by Login and a TOTP or recovery code for the tokens.
+func LoginMfaHandler(config *config.AppConfig, storage Storage, keyring *Keyring, validator *utils.AppValidator) fiber.Handler {
+	type LoginMfaRequest struct {
+		MfaToken string `json:"mfa_token" validate:"required"`
+		Code     string `json:"code" validate:"required,lte=32"`
+	}
+
+	type LoginMfaResponse Tokens
+
+	return func(ctx fiber.Ctx) error {
+		data := LoginMfaRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+		err = validator.Validate(data)
+		if err != nil {
+			return err
+		}
+
+		challenge, err := storage.GetMfaChallenge(ctx.Context(), utils.HashToken(data.MfaToken))
+		if err != nil {
+			if errors.Is(err, MfaChallengeInvalid) {
+				return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+			}
+			return err
+		}
+
+		user, err := storage.GetById(ctx.Context(), challenge.UserId)
+		if err != nil {
+			return err
+		}
+		if user.disabledAt != nil {
+			return fiber.NewError(fiber.StatusForbidden, AccountDisabled.Error())
+		}
+		err = reserveLoginAttempt(ctx, storage, user.Email)
+		if err != nil {
+			return err
+		}
+
+		ok, err := verifySecondFactor(ctx.Context(), storage, user, data.Code)
+		if err != nil && !errors.Is(err, TwoFactorNotEnabled) {
+			return err
+		}
+		if !ok {
+			err = storage.FailMfaChallenge(ctx.Context(), challenge.Id)
+			if err != nil {
+				return err
+			}
+			return fiber.NewError(fiber.StatusBadRequest, "wrong code")
+		}
+		err = releaseLoginAttempt(ctx, storage, user.Email)
+		if err != nil {
+			return err
+		}
+
+		err = storage.CompleteMfaChallenge(ctx.Context(), challenge.Id)
+		if err != nil {
+			if errors.Is(err, MfaChallengeInvalid) {
+				return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+			}
+			return err
+		}
+
+		err = clearLoginFailures(ctx, storage, user.Email)
+		if err != nil {
+			return err
+		}
+
+		tokens, err := issueTokens(ctx.Context(), config, storage, keyring, user, deviceFromRequest(ctx, challenge.DeviceName))
+		if err != nil {
+			return err
+		}
+
+		tokens, err = setAuthCookies(ctx, config, tokens)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(LoginMfaResponse(tokens))
+	}
+}
@@ -8,15 +8,38 @@ import (
 	"github.com/mattn/go-sqlite3"
 	"github.com/oklog/ulid/v2"
 	"strings"
+	"time"
 )
 
 type Id string
 
 type User struct {
-	Id           Id     `json:"id"`
-	Email        string `json:"email"`
-	Name         string `json:"name"`
-	passwordHash string
+	Id            Id          `json:"id"`
+	Email         string      `json:"email"`
+	Name          string      `json:"name"`
+	WorkspaceIds  []string    `json:"workspace_ids"`
+	EmailVerified bool        `json:"email_verified"`
+	TwoFactor     bool        `json:"two_factor"`
+	Timezone      string      `json:"timezone"`
+	Locale        string      `json:"locale"`
+	Preferences   Preferences `json:"preferences"`
+	Role          Role        `json:"role"`
+	passwordHash  string
+	// tokenGeneration is increased to invalidate every token issued to the user before
+	tokenGeneration int
+	// totpSecret is set during enrollment already, TwoFactor only once it is confirmed
+	totpSecret   string
+	totpLastStep int64
+	// deletedAt is set while the account waits for its deletion
+	deletedAt *time.Time
+	// disabledAt is set while an admin blocks the account
+	disabledAt *time.Time
+}
+
+// Preferences are settings applied for the user when a request does not choose otherwise.
+type Preferences struct {
+	SortBy    string `json:"sort_by"`
+	SortOrder string `json:"sort_order"`
 }
 
 func FromContext(c fiber.Ctx) *User {
@@ -31,6 +54,83 @@ var (
 type Storage interface {
 	Create(ctx context.Context, email string, passwordHash string, name string) (User, error)
 	GetUserByEmail(ctx context.Context, email string) (User, error)
+	GetById(ctx context.Context, id Id) (User, error)
+	UpdateProfile(ctx context.Context, id Id, update ProfileUpdate) (User, error)
+	UpdatePassword(ctx context.Context, id Id, passwordHash string) error
+	ScheduleDeletion(ctx context.Context, id Id) (time.Time, error)
+	RestoreDeleted(ctx context.Context, id Id, deletedAfter time.Time) error
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error)
+	UpdatePasswordHash(ctx context.Context, id Id, oldHash string, newHash string) error
+
+	CreatePasswordReset(ctx context.Context, userId Id, tokenHash string, expiresAt time.Time) error
+	ResetPassword(ctx context.Context, tokenHash string, passwordHash string) (Id, error)
+
+	MarkEmailVerified(ctx context.Context, id Id, email string) error
+	MarkVerificationSent(ctx context.Context, id Id, interval time.Duration) (bool, error)
+
+	SetTotpSecret(ctx context.Context, id Id, secret string) error
+	EnableTwoFactor(ctx context.Context, id Id, step int64, recoveryCodeHashes []string) error
+	DisableTwoFactor(ctx context.Context, id Id) error
+	UseTotpStep(ctx context.Context, id Id, step int64) (bool, error)
+	GetRecoveryCodes(ctx context.Context, userId Id) ([]RecoveryCode, error)
+	UseRecoveryCode(ctx context.Context, id string) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userId Id, codeHashes []string) error
+	CreateMfaChallenge(ctx context.Context, userId Id, tokenHash string, deviceName string, expiresAt time.Time) error
+	GetMfaChallenge(ctx context.Context, tokenHash string) (MfaChallenge, error)
+	FailMfaChallenge(ctx context.Context, id string) error
+	CompleteMfaChallenge(ctx context.Context, id string) error
+
+	GetLoginFailures(ctx context.Context, keys ...string) ([]LoginFailure, error)
+	RecordLoginFailure(ctx context.Context, key string, previous int, window time.Duration) (bool, error)
+	ReleaseLoginFailure(ctx context.Context, key string) error
+	ClearLoginFailures(ctx context.Context, key string) error
+
+	CreateRefreshToken(ctx context.Context, userId Id, familyId string, tokenHash string, expiresAt time.Time) (RefreshToken, error)
+	GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, tokenHash string, newTokenHash string, expiresAt time.Time) (RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, userId Id, tokenHash string) error
+
+	CreateSession(ctx context.Context, userId Id, device Device, expiresAt time.Time) (Session, error)
+	CreateGrantSession(ctx context.Context, userId Id, clientId string, scopes []string, device Device, expiresAt time.Time) (Session, error)
+	GetSession(ctx context.Context, id string) (Session, error)
+	GetSessions(ctx context.Context, userId Id) ([]Session, error)
+	RevokeSession(ctx context.Context, userId Id, id string) error
+	RevokeOtherSessions(ctx context.Context, userId Id, keep string) ([]string, error)
+
+	CreateAccessToken(ctx context.Context, userId Id, name string, hint string, tokenHash string, scopes []string, expiresAt *time.Time) (AccessToken, error)
+	GetAccessTokens(ctx context.Context, userId Id) ([]AccessToken, error)
+	UseAccessToken(ctx context.Context, tokenHash string) (AccessToken, error)
+	DeleteAccessToken(ctx context.Context, userId Id, id string) error
+
+	RevokeToken(ctx context.Context, jti string, userId Id, expiresAt time.Time) error
+	RevokeAllTokens(ctx context.Context, userId Id) (int, error)
+	GetRevocations(ctx context.Context, since time.Time) (Revocations, error)
+	DeleteExpiredTokens(ctx context.Context) error
+
+	FindUsers(ctx context.Context, filter UserFilter) ([]User, error)
+	CountUsers(ctx context.Context, filter UserFilter) (uint, error)
+	SetRole(ctx context.Context, id Id, role Role) error
+	SetDisabled(ctx context.Context, id Id, disabled bool) error
+	GetStats(ctx context.Context) (Stats, error)
+
+	CreateOidcState(ctx context.Context, state OidcState) error
+	TakeOidcState(ctx context.Context, stateHash string) (OidcState, error)
+	GetIdentity(ctx context.Context, issuer string, subject string) (Identity, error)
+	GetIdentities(ctx context.Context, userId Id) ([]Identity, error)
+	CreateIdentity(ctx context.Context, userId Id, issuer string, subject string, email string) (Identity, error)
+	CreateExternalUser(ctx context.Context, email string, name string, emailVerified bool, issuer string, subject string) (User, error)
+	TouchIdentity(ctx context.Context, id string, email string) error
+	DeleteIdentity(ctx context.Context, userId Id, id string) error
+
+	CreateOauthClient(ctx context.Context, ownerId Id, name string, secretHash string, redirectUris []string, scopes []string) (OauthClient, error)
+	GetOauthClient(ctx context.Context, id string) (OauthClient, error)
+	GetOauthClients(ctx context.Context, ownerId Id) ([]OauthClient, error)
+	DeleteOauthClient(ctx context.Context, ownerId Id, id string) ([]string, error)
+	CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) error
+	RedeemAuthorizationCode(ctx context.Context, codeHash string) (AuthorizationCode, error)
+	SetAuthorizationCodeSession(ctx context.Context, codeHash string, sessionId string) error
+
+	UseDpopProof(ctx context.Context, jtiHash string, expiresAt time.Time) (bool, error)
 }
 
 type SqliteUsersStorage struct {
@@ -63,22 +163,30 @@ func (s SqliteUsersStorage) Create(ctx context.Context, email string, passwordHa
 		return User{}, errors.New("user creation error")
 	}
 
-	return User{
-		Id:    Id(userId),
-		Email: email,
-		Name:  name,
-	}, nil
+	// read back for the defaults of the columns
+	return s.GetById(ctx, Id(userId))
 }
 
 func (s SqliteUsersStorage) GetUserByEmail(ctx context.Context, email string) (User, error) {
-	stmt, err := s.db.PrepareContext(ctx, "SELECT id, email, name, password_hash FROM users WHERE email=?")
+	return s.getUser(ctx, "email=?", email)
+}
+
+func (s SqliteUsersStorage) GetById(ctx context.Context, id Id) (User, error) {
+	return s.getUser(ctx, "id=?", id)
+}
+
+const userColumns = `id, email, name, password_hash, token_generation, email_verified_at,
+	COALESCE(totp_secret, ''), totp_enabled_at, totp_last_step,
+	timezone, locale, sort_by, sort_order, deleted_at, role, disabled_at`
+
+func (s SqliteUsersStorage) getUser(ctx context.Context, where string, args ...any) (User, error) {
+	stmt, err := s.db.PrepareContext(ctx, "SELECT "+userColumns+" FROM users WHERE "+where)
 	if err != nil {
 		return User{}, err
 	}
 	defer stmt.Close()
 
-	var user = User{}
-	err = stmt.QueryRowContext(ctx, email).Scan(&user.Id, &user.Email, &user.Name, &user.passwordHash)
+	user, err := scanUser(stmt.QueryRowContext(ctx, args...))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, NotFound
@@ -86,9 +194,60 @@ func (s SqliteUsersStorage) GetUserByEmail(ctx context.Context, email string) (U
 		return User{}, err
 	}
 
+	user.WorkspaceIds, err = s.getWorkspaceIds(ctx, user.Id)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// scanUser reads a row of userColumns, the workspaces of the user are not part of it.
+func scanUser(row rowScanner) (User, error) {
+	var user = User{}
+	var emailVerifiedAt, totpEnabledAt, deletedAt, disabledAt sql.NullTime
+	err := row.Scan(&user.Id, &user.Email, &user.Name, &user.passwordHash, &user.tokenGeneration,
+		&emailVerifiedAt, &user.totpSecret, &totpEnabledAt, &user.totpLastStep,
+		&user.Timezone, &user.Locale, &user.Preferences.SortBy, &user.Preferences.SortOrder, &deletedAt,
+		&user.Role, &disabledAt)
+	if err != nil {
+		return User{}, err
+	}
+	user.EmailVerified = emailVerifiedAt.Valid
+	user.TwoFactor = totpEnabledAt.Valid
+	if deletedAt.Valid {
+		user.deletedAt = &deletedAt.Time
+	}
+	if disabledAt.Valid {
+		user.disabledAt = &disabledAt.Time
+	}
 	return user, nil
 }
 
+func (s SqliteUsersStorage) getWorkspaceIds(ctx context.Context, id Id) ([]string, error) {
+	stmt, err := s.db.PrepareContext(ctx, "SELECT workspace_id FROM workspace_members WHERE user_id=? ORDER BY workspace_id")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaceIds := []string{}
+	for rows.Next() {
+		var workspaceId string
+		if err = rows.Scan(&workspaceId); err != nil {
+			return nil, err
+		}
+		workspaceIds = append(workspaceIds, workspaceId)
+	}
+	return workspaceIds, rows.Err()
+}
+
 func mapError(err error) error {
 	var sqlErr sqlite3.Error
 	if errors.As(err, &sqlErr) {
@@ -0,0 +1,146 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"todo-api/config"
+	"todo-api/mail"
+)
+
+const (
+	// verificationTTL is how long an email verification link can be used.
+	verificationTTL = 48 * time.Hour
+	// verificationResendInterval is the minimal time between two verification emails to the same user.
+	verificationResendInterval = time.Minute
+)
+
+var VerificationInvalid = errors.New("invalid or expired verification token")
+
+type verificationClaims struct {
+	UserId    Id     `json:"uid"`
+	Email     string `json:"email"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// verificationKey derives the key that signs verification links from the jwt secret,
+// so a verification token can never pass as an access token or the other way around.
+func verificationKey(config *config.AppConfig) []byte {
+	mac := hmac.New(sha256.New, []byte(config.JwtSecret))
+	mac.Write([]byte("email-verification"))
+	return mac.Sum(nil)
+}
+
+// signVerification returns a token proving that the link was sent to the email of the user.
+// Changing the email invalidates the links sent before.
+func signVerification(config *config.AppConfig, user User, expiresAt time.Time) (string, error) {
+	payload, err := json.Marshal(verificationClaims{UserId: user.Id, Email: user.Email, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, verificationKey(config))
+	mac.Write(payload)
+
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode(payload) + "." + encode(mac.Sum(nil)), nil
+}
+
+func parseVerification(config *config.AppConfig, token string) (verificationClaims, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return verificationClaims{}, VerificationInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return verificationClaims{}, VerificationInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return verificationClaims{}, VerificationInvalid
+	}
+
+	mac := hmac.New(sha256.New, verificationKey(config))
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return verificationClaims{}, VerificationInvalid
+	}
+
+	var claims verificationClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return verificationClaims{}, VerificationInvalid
+	}
+	if time.Now().Unix() > claims.ExpiresAt {
+		return verificationClaims{}, VerificationInvalid
+	}
+	return claims, nil
+}
+
+func sendVerification(ctx context.Context, config *config.AppConfig, mailer mail.Mailer, user User) error {
+	expiresAt := time.Now().Add(verificationTTL)
+	token, err := signVerification(config, user, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nconfirm your email address: %s/verify-email?token=%s\n\nThe link expires at %s.",
+			user.Name, strings.TrimRight(config.PublicUrl, "/"), url.QueryEscape(token), expiresAt.Format(time.RFC1123),
+		),
+	})
+}
+
+func (s SqliteUsersStorage) MarkEmailVerified(ctx context.Context, id Id, email string) error {
+	stmt, err := s.db.PrepareContext(ctx, `
+		UPDATE users SET email_verified_at=COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+		WHERE id=? AND email=?
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx, id, email)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return VerificationInvalid
+	}
+	return nil
+}
+
+// MarkVerificationSent records that a verification email goes out now,
+// unless one was already sent within interval; it reports whether sending is allowed.
+func (s SqliteUsersStorage) MarkVerificationSent(ctx context.Context, id Id, interval time.Duration) (bool, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		UPDATE users SET verification_sent_at=?
+		WHERE id=? AND (verification_sent_at IS NULL OR verification_sent_at<=?)
+	`)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	result, err := stmt.ExecContext(ctx, now, id, now.Add(-interval))
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
@@ -0,0 +1,92 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v3"
+	"log"
+	"todo-api/config"
+	"todo-api/mail"
+)
+
+// RequireVerifiedEmail rejects users who have not verified their email yet, when enabled.
+// The state is read from the storage, the claim of the access token is outdated after a verification or an email change.
+func RequireVerifiedEmail(enabled bool, storage Storage) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if !enabled {
+			return c.Next()
+		}
+		user, err := storage.GetById(c.Context(), FromContext(c).Id)
+		if err != nil {
+			return err
+		}
+		if !user.EmailVerified {
+			return fiber.NewError(fiber.StatusForbidden, "verify your email address first")
+		}
+		return c.Next()
+	}
+}
+
+func VerifyEmailHandler(config *config.AppConfig, storage Storage) fiber.Handler {
+	type VerifyEmailRequest struct {
+		Token string `json:"token"`
+	}
+
+	type VerifyEmailResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		data := VerifyEmailRequest{}
+		err := ctx.Bind().Body(&data)
+		if err != nil {
+			return err
+		}
+
+		claims, err := parseVerification(config, data.Token)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		err = storage.MarkEmailVerified(ctx.Context(), claims.UserId, claims.Email)
+		if err != nil {
+			if errors.Is(err, VerificationInvalid) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			return err
+		}
+
+		return ctx.JSON(VerifyEmailResponse{})
+	}
+}
+
+func ResendVerificationHandler(config *config.AppConfig, storage Storage, mailer mail.Mailer) fiber.Handler {
+	type ResendVerificationResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		user, err := storage.GetById(ctx.Context(), FromContext(ctx).Id)
+		if err != nil {
+			return err
+		}
+		if user.EmailVerified {
+			return fiber.NewError(fiber.StatusBadRequest, "email is already verified")
+		}
+
+		allowed, err := storage.MarkVerificationSent(ctx.Context(), user.Id, verificationResendInterval)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			ctx.Set(fiber.HeaderRetryAfter, fmt.Sprint(int(verificationResendInterval.Seconds())))
+			return fiber.NewError(fiber.StatusTooManyRequests, "verification email was sent recently")
+		}
+
+		err = sendVerification(ctx.Context(), config, mailer, user)
+		if err != nil {
+			log.Printf("verification mail to %s failed: %v", user.Email, err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.JSON(ResendVerificationResponse{})
+	}
+}
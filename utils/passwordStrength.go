@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// commonPasswords are rejected regardless of their length, the breached passwords list catches the rest.
+var commonPasswords = map[string]bool{
+	"123456": true, "12345678": true, "123456789": true, "1234567890": true, "password": true, "password1": true,
+	"password123": true, "qwerty": true, "qwerty123": true, "qwertyuiop": true, "111111": true, "000000": true,
+	"abc123": true, "iloveyou": true, "letmein": true, "welcome": true, "monkey": true, "dragon": true,
+	"football": true, "baseball": true, "sunshine": true, "princess": true, "admin": true, "admin123": true,
+	"passw0rd": true, "trustno1": true, "superman": true, "master": true, "hello123": true, "changeme": true,
+}
+
+// keyboardRows are walked by passwords like "qwerty" or "asdfgh".
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+// PasswordStrength estimates how hard the password is to guess on the zxcvbn scale:
+// 0 - too guessable, 1 - very guessable, 2 - somewhat guessable, 3 - safely unguessable, 4 - very unguessable.
+// It is a rough estimate: repeated characters, sequences, keyboard walks, common passwords
+// and the userInputs (e.g. the email and the name) add almost nothing to the guesses needed.
+func PasswordStrength(password string, userInputs ...string) int {
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return 0
+	}
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		if len(input) >= 3 {
+			lower = strings.ReplaceAll(lower, input, "\x00")
+		}
+	}
+
+	bitsPerChar := math.Log2(float64(charsetSize(password)))
+	var bits float64
+	runes := []rune(lower)
+	for i, r := range runes {
+		switch {
+		case r == 0:
+			// a user input, guessed as a single word
+			bits += 4
+		case i > 0 && (r == runes[i-1] || r == runes[i-1]+1 || r == runes[i-1]-1 || isKeyboardNeighbour(runes[i-1], r)):
+			// repetitions, sequences and keyboard walks are nearly free to guess
+			bits += 0.5
+		default:
+			bits += bitsPerChar
+		}
+	}
+
+	guessesLog10 := bits * math.Log10(2)
+	switch {
+	case guessesLog10 < 3:
+		return 0
+	case guessesLog10 < 6:
+		return 1
+	case guessesLog10 < 8:
+		return 2
+	case guessesLog10 < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func charsetSize(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if other {
+		size += 33
+	}
+	return max(size, 2)
+}
+
+func isKeyboardNeighbour(a rune, b rune) bool {
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, a)
+		if i < 0 {
+			continue
+		}
+		if (i+1 < len(row) && rune(row[i+1]) == b) || (i > 0 && rune(row[i-1]) == b) {
+			return true
+		}
+	}
+	return false
+}
@@ -18,11 +18,30 @@ type argonOptions struct {
 	saltLen uint32
 }
 
+// ArgonParams are the argon2id cost parameters used for new password hashes, Memory is in KiB.
+// Hashes keep the parameters they were created with, see NeedsRehash.
+type ArgonParams struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+var argonParams = ArgonParams{
+	Time:    1,
+	Memory:  60 * 1024,
+	Threads: 4,
+}
+
+// SetArgonParams changes the cost of new password hashes. It must be called before the hashing starts.
+func SetArgonParams(params ArgonParams) {
+	argonParams = params
+}
+
 func argonDefault() argonOptions {
 	return argonOptions{
-		time:    1,
-		memory:  60 * 1024,
-		threads: 4,
+		time:    argonParams.Time,
+		memory:  argonParams.Memory,
+		threads: argonParams.Threads,
 		keyLen:  32,
 		saltLen: 16,
 	}
@@ -50,36 +69,62 @@ func HashPassword(plaintextPassword string) (string, error) {
 
 // ComparePassword compares a plaintext password with a hashed password and returns true if they match.
 func ComparePassword(plaintextPassword string, hashedPassword string) (bool, error) {
-	a := argonDefault()
+	// accounts without a password, e.g. provisioned by a single sign-on, never match
+	if hashedPassword == "" {
+		return false, nil
+	}
+	a, salt, decodedHash, err := decodeHash(hashedPassword)
+	if err != nil {
+		return false, err
+	}
+
+	computedHash := argon2.IDKey([]byte(plaintextPassword), salt, a.time, a.memory, a.threads, a.keyLen)
+
+	return subtle.ConstantTimeCompare(decodedHash, computedHash) == 1, nil
+}
+
+// NeedsRehash reports whether the hash was created with other parameters than the current ones,
+// so it should be replaced the next time the plaintext password is known.
+func NeedsRehash(hashedPassword string) bool {
+	a, salt, _, err := decodeHash(hashedPassword)
+	if err != nil {
+		return true
+	}
+	current := argonDefault()
+	return a.time != current.time || a.memory != current.memory || a.threads != current.threads ||
+		a.keyLen != current.keyLen || uint32(len(salt)) != current.saltLen
+}
+
+func decodeHash(hashedPassword string) (argonOptions, []byte, []byte, error) {
+	a := argonOptions{}
 
 	parts := strings.Split(hashedPassword, "$")
 	if len(parts) != 5 {
-		return false, errors.New("hashed password invalid format")
+		return a, nil, nil, errors.New("hashed password invalid format")
 	}
 
 	var version int
 	_, err := fmt.Sscanf(parts[2], "v=%d,m=%d,t=%d,p=%d", &version, &a.memory, &a.time, &a.threads)
 	if err != nil {
-		return false, err
+		return a, nil, nil, err
 	}
 	if version != argon2.Version {
-		return false, errors.New("argon2 versions mismatch")
+		return a, nil, nil, errors.New("argon2 versions mismatch")
 	}
 
 	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
 	if err != nil {
-		return false, err
+		return a, nil, nil, err
 	}
 
 	decodedHash, err := base64.RawStdEncoding.DecodeString(parts[4])
 	if err != nil {
-		return false, err
+		return a, nil, nil, err
 	}
 	a.keyLen = uint32(len(decodedHash))
+	a.saltLen = uint32(len(salt))
 
-	computedHash := argon2.IDKey([]byte(plaintextPassword), salt, a.time, a.memory, a.threads, a.keyLen)
-
-	return subtle.ConstantTimeCompare(decodedHash, computedHash) == 1, nil
+	return a, salt, decodedHash, nil
 }
 
 func getRandomBytes(n uint32) ([]byte, error) {
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"encoding/base32"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestTotpCode checks the SHA-1 test vectors of RFC 6238 Appendix B,
+// the codes are the last TotpDigits digits of the 8 digit codes listed there.
+func TestTotpCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, test := range tests {
+		code, err := TotpCode(secret, TotpStep(time.Unix(test.unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, test.code[len(test.code)-TotpDigits:], code, test.unix)
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	secret, err := NewTotpSecret()
+	assert.NoError(t, err)
+	now := time.Now()
+
+	for _, offset := range []time.Duration{-TotpPeriod, 0, TotpPeriod} {
+		step := TotpStep(now.Add(offset))
+		code, err := TotpCode(secret, step)
+		assert.NoError(t, err)
+
+		matched, ok, err := ValidateTotp(secret, code, now)
+		assert.NoError(t, err)
+		assert.True(t, ok, offset)
+		assert.Equal(t, step, matched)
+	}
+
+	code, err := TotpCode(secret, TotpStep(now.Add(3*TotpPeriod)))
+	assert.NoError(t, err)
+	_, ok, err := ValidateTotp(secret, code, now)
+	assert.NoError(t, err)
+	assert.False(t, ok, "code outside of the skew")
+}
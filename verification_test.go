@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerifiedEmailIsReadFromTheDatabase(t *testing.T) {
+	a := newTestApp(t, "REQUIRE_VERIFIED_EMAIL", "true")
+	token, _ := a.register(t, "user@example.com")
+	todo := map[string]string{"title": "verified"}
+
+	status, _ := a.do(t, "POST", "/todos", todo, bearer(token)...)
+	assert.Equal(t, 403, status, "unverified email")
+	status, _ = a.do(t, "GET", "/todos", nil, bearer(token)...)
+	assert.Equal(t, 200, status, "reading needs no verified email")
+
+	status, response := a.do(t, "POST", "/verify-email", map[string]string{"token": mailToken(t, a, "user@example.com")})
+	assert.Equal(t, 200, status, response)
+	status, _ = a.do(t, "POST", "/todos", todo, bearer(token)...)
+	assert.Equal(t, 200, status, "token issued before the verification")
+
+	_, err := a.db.Exec("UPDATE users SET email_verified_at=NULL")
+	assert.NoError(t, err)
+	status, _ = a.do(t, "POST", "/todos", todo, bearer(token)...)
+	assert.Equal(t, 403, status, "the email is no longer verified")
+}
@@ -0,0 +1,59 @@
+package workspace
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"todo-api/user"
+)
+
+const (
+	HeaderWorkspaceId = "X-Workspace-Id"
+	ParamWorkspaceId  = "workspaceId"
+
+	memberContextKey = "workspaceMember"
+)
+
+// ContextMiddleware selects the workspace of the request from the workspaceId path parameter
+// or the X-Workspace-Id header and checks that the current user is a member of it.
+// Requests without a workspace are passed through unchanged.
+func ContextMiddleware(storage Storage) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		workspaceId := c.Params(ParamWorkspaceId)
+		if workspaceId == "" {
+			workspaceId = c.Get(HeaderWorkspaceId)
+		}
+		if workspaceId == "" {
+			return c.Next()
+		}
+
+		u := user.FromContext(c)
+		member, err := storage.GetMember(c.Context(), Id(workspaceId), u.Id)
+		if err != nil {
+			if errors.Is(err, NotMember) {
+				return fiber.ErrForbidden
+			}
+			return fiber.ErrInternalServerError
+		}
+
+		c.Locals(memberContextKey, &member)
+		return c.Next()
+	}
+}
+
+// FromContext returns the membership of the current user in the selected workspace
+// or nil if the request has no workspace context.
+func FromContext(c fiber.Ctx) *Member {
+	member, _ := c.Locals(memberContextKey).(*Member)
+	return member
+}
+
+// RequireRole rejects requests whose workspace membership is missing or below the required role.
+func RequireRole(role Role) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		member := FromContext(c)
+		if member == nil || !member.Role.Includes(role) {
+			return fiber.ErrForbidden
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,114 @@
+package workspace
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"time"
+	"todo-api/user"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+type Invitation struct {
+	Id          string     `json:"id"`
+	WorkspaceId Id         `json:"workspace_id"`
+	Email       string     `json:"email"`
+	Role        Role       `json:"role"`
+	InvitedBy   user.Id    `json:"invited_by"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	AcceptedAt  *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (i *Invitation) Usable() bool {
+	return i.AcceptedAt == nil && time.Now().Before(i.ExpiresAt)
+}
+
+var InvitationNotFound = errors.New("invitation not found")
+
+func (s SqliteStorage) CreateInvitation(ctx context.Context, id Id, email string, role Role, tokenHash string, invitedBy user.Id, expiresAt time.Time) (Invitation, error) {
+	invitation := Invitation{
+		Id:          ulid.Make().String(),
+		WorkspaceId: id,
+		Email:       email,
+		Role:        role,
+		InvitedBy:   invitedBy,
+		ExpiresAt:   expiresAt,
+		CreatedAt:   time.Now(),
+	}
+
+	stmt, err := s.db.PrepareContext(ctx, `
+		INSERT INTO workspace_invitations (id, workspace_id, email, role, token_hash, invited_by, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return Invitation{}, err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, invitation.Id, id, email, role, tokenHash, invitedBy, expiresAt)
+	if err != nil {
+		return Invitation{}, err
+	}
+	return invitation, nil
+}
+
+func (s SqliteStorage) GetInvitationByTokenHash(ctx context.Context, tokenHash string) (Invitation, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		SELECT id, workspace_id, email, role, invited_by, expires_at, accepted_at, created_at
+		FROM workspace_invitations WHERE token_hash=?
+	`)
+	if err != nil {
+		return Invitation{}, err
+	}
+	defer stmt.Close()
+
+	invitation := Invitation{}
+	var acceptedAt sql.NullTime
+	err = stmt.QueryRowContext(ctx, tokenHash).Scan(&invitation.Id, &invitation.WorkspaceId, &invitation.Email,
+		&invitation.Role, &invitation.InvitedBy, &invitation.ExpiresAt, &acceptedAt, &invitation.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Invitation{}, InvitationNotFound
+		}
+		return Invitation{}, err
+	}
+	if acceptedAt.Valid {
+		invitation.AcceptedAt = &acceptedAt.Time
+	}
+	return invitation, nil
+}
+
+// AcceptInvitation marks the invitation as used and adds the user to the workspace with the invited role.
+func (s SqliteStorage) AcceptInvitation(ctx context.Context, invitation Invitation, userId user.Id) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exec, err := tx.ExecContext(ctx,
+		"UPDATE workspace_invitations SET accepted_at=CURRENT_TIMESTAMP WHERE id=? AND accepted_at IS NULL",
+		invitation.Id)
+	if err != nil {
+		return err
+	}
+	affected, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return InvitationNotFound
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (?, ?, ?)",
+		invitation.WorkspaceId, userId, invitation.Role)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,317 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v3"
+	"net/url"
+	"strings"
+	"time"
+	"todo-api/config"
+	"todo-api/mail"
+	"todo-api/user"
+	"todo-api/utils"
+)
+
+func SetupRoutes(app *fiber.App, config *config.AppConfig, auth fiber.Handler, storage Storage, mailer mail.Mailer, validator *utils.AppValidator) {
+	member := ContextMiddleware(storage)
+	read := user.RequireScope(user.ScopeWorkspacesRead)
+	write := user.RequireScope(user.ScopeWorkspacesWrite)
+
+	app.Post("/workspaces", CreateHandler(storage, validator), auth, write)
+	app.Get("/workspaces", ListHandler(storage), auth, read)
+	app.Get("/workspaces/:workspaceId", GetHandler(storage), auth, read, member, RequireRole(RoleGuest))
+	app.Get("/workspaces/:workspaceId/members", GetMembersHandler(storage), auth, read, member, RequireRole(RoleGuest))
+	app.Put("/workspaces/:workspaceId/members/:userId", UpdateMemberHandler(storage, validator), auth, write, member, RequireRole(RoleAdmin))
+	app.Delete("/workspaces/:workspaceId/members/:userId", RemoveMemberHandler(storage), auth, write, member, RequireRole(RoleGuest))
+	app.Post("/workspaces/:workspaceId/invitations", InviteHandler(config, storage, mailer, validator), auth, write, member, RequireRole(RoleAdmin))
+	app.Post("/invitations/accept", AcceptInvitationHandler(storage, validator), auth, write)
+}
+
+// canManage reports whether a member with the actor role may change or remove a member with the target role.
+func canManage(actor, target Role) bool {
+	return actor == RoleOwner || (actor.Includes(RoleAdmin) && !target.Includes(RoleAdmin))
+}
+
+func CreateHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
+	type CreateRequest struct {
+		Name string `json:"name" validate:"required,lte=255"`
+	}
+
+	type CreateResponse Workspace
+
+	return func(ctx fiber.Ctx) error {
+		u := user.FromContext(ctx)
+
+		req := CreateRequest{}
+		err := ctx.Bind().Body(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err = validator.Validate(req); err != nil {
+			return err
+		}
+
+		workspace, err := storage.Create(ctx.Context(), req.Name, u.Id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.JSON(CreateResponse(workspace))
+	}
+}
+
+func ListHandler(storage Storage) fiber.Handler {
+	type ListResponse struct {
+		Data []Workspace `json:"data"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		u := user.FromContext(ctx)
+
+		workspaces, err := storage.GetByUserId(ctx.Context(), u.Id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if workspaces == nil {
+			workspaces = []Workspace{}
+		}
+
+		return ctx.JSON(ListResponse{Data: workspaces})
+	}
+}
+
+func GetHandler(storage Storage) fiber.Handler {
+	type GetResponse struct {
+		Workspace
+		Role Role `json:"role"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		member := FromContext(ctx)
+
+		workspace, err := storage.GetById(ctx.Context(), member.WorkspaceId)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.JSON(GetResponse{Workspace: workspace, Role: member.Role})
+	}
+}
+
+func GetMembersHandler(storage Storage) fiber.Handler {
+	type GetMembersResponse struct {
+		Data []Member `json:"data"`
+	}
+
+	return func(ctx fiber.Ctx) error {
+		member := FromContext(ctx)
+
+		members, err := storage.GetMembers(ctx.Context(), member.WorkspaceId)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.JSON(GetMembersResponse{Data: members})
+	}
+}
+
+func UpdateMemberHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
+	type UpdateMemberRequest struct {
+		Role Role `json:"role" validate:"required,oneof=owner admin member guest"`
+	}
+
+	type UpdateMemberResponse Member
+
+	return func(ctx fiber.Ctx) error {
+		actor := FromContext(ctx)
+		userId := user.Id(ctx.Params("userId", ""))
+
+		req := UpdateMemberRequest{}
+		err := ctx.Bind().Body(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err = validator.Validate(req); err != nil {
+			return err
+		}
+
+		target, err := storage.GetMember(ctx.Context(), actor.WorkspaceId, userId)
+		if err != nil {
+			if errors.Is(err, NotMember) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return fiber.ErrInternalServerError
+		}
+		if !canManage(actor.Role, target.Role) || !actor.Role.Includes(req.Role) {
+			return fiber.ErrForbidden
+		}
+		if target.Role == RoleOwner && req.Role != RoleOwner {
+			if err = requireAnotherOwner(ctx, storage, actor.WorkspaceId); err != nil {
+				return err
+			}
+		}
+
+		err = storage.UpdateMemberRole(ctx.Context(), actor.WorkspaceId, userId, req.Role)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		target.Role = req.Role
+		return ctx.JSON(UpdateMemberResponse(target))
+	}
+}
+
+func RemoveMemberHandler(storage Storage) fiber.Handler {
+	type RemoveMemberResponse struct {
+	}
+
+	return func(ctx fiber.Ctx) error {
+		actor := FromContext(ctx)
+		userId := user.Id(ctx.Params("userId", ""))
+
+		target, err := storage.GetMember(ctx.Context(), actor.WorkspaceId, userId)
+		if err != nil {
+			if errors.Is(err, NotMember) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return fiber.ErrInternalServerError
+		}
+		// members may always leave, removing someone else requires a higher role
+		if target.UserId != actor.UserId && !canManage(actor.Role, target.Role) {
+			return fiber.ErrForbidden
+		}
+		if target.Role == RoleOwner {
+			if err = requireAnotherOwner(ctx, storage, actor.WorkspaceId); err != nil {
+				return err
+			}
+		}
+
+		err = storage.RemoveMember(ctx.Context(), actor.WorkspaceId, userId)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.JSON(RemoveMemberResponse{})
+	}
+}
+
+func requireAnotherOwner(ctx fiber.Ctx, storage Storage, workspaceId Id) error {
+	owners, err := storage.CountOwners(ctx.Context(), workspaceId)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if owners < 2 {
+		return fiber.NewError(fiber.StatusBadRequest, "workspace must keep at least one owner")
+	}
+	return nil
+}
+
+func InviteHandler(config *config.AppConfig, storage Storage, mailer mail.Mailer, validator *utils.AppValidator) fiber.Handler {
+	type InviteRequest struct {
+		Email string `json:"email" validate:"required,email"`
+		Role  Role   `json:"role" validate:"required,oneof=owner admin member guest"`
+	}
+
+	type InviteResponse Invitation
+
+	return func(ctx fiber.Ctx) error {
+		u := user.FromContext(ctx)
+		actor := FromContext(ctx)
+
+		req := InviteRequest{}
+		err := ctx.Bind().Body(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err = validator.Validate(req); err != nil {
+			return err
+		}
+		if !actor.Role.Includes(req.Role) {
+			return fiber.ErrForbidden
+		}
+
+		workspace, err := storage.GetById(ctx.Context(), actor.WorkspaceId)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		token, err := utils.NewRandomToken()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		expiresAt := time.Now().Add(invitationTTL)
+		invitation, err := storage.CreateInvitation(ctx.Context(), workspace.Id, req.Email, req.Role, utils.HashToken(token), u.Id, expiresAt)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		err = mailer.Send(ctx.Context(), mail.Message{
+			To:      req.Email,
+			Subject: fmt.Sprintf("You are invited to %s", workspace.Name),
+			Body: fmt.Sprintf(
+				"%s invited you to join the workspace %s as %s.\n\nAccept the invitation: %s?token=%s\n\nThe invitation expires at %s.",
+				u.Name, workspace.Name, req.Role, config.InvitationUrl(), url.QueryEscape(token), expiresAt.Format(time.RFC1123),
+			),
+		})
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.JSON(InviteResponse(invitation))
+	}
+}
+
+func AcceptInvitationHandler(storage Storage, validator *utils.AppValidator) fiber.Handler {
+	type AcceptRequest struct {
+		Token string `json:"token" validate:"required"`
+	}
+
+	type AcceptResponse Member
+
+	return func(ctx fiber.Ctx) error {
+		u := user.FromContext(ctx)
+
+		req := AcceptRequest{}
+		err := ctx.Bind().Body(&req)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err = validator.Validate(req); err != nil {
+			return err
+		}
+
+		invitation, err := storage.GetInvitationByTokenHash(ctx.Context(), utils.HashToken(req.Token))
+		if err != nil {
+			if errors.Is(err, InvitationNotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return fiber.ErrInternalServerError
+		}
+		if !invitation.Usable() {
+			return fiber.NewError(fiber.StatusGone, "invitation is expired or already used")
+		}
+		if !strings.EqualFold(invitation.Email, u.Email) {
+			return fiber.NewError(fiber.StatusForbidden, "invitation was sent to a different email")
+		}
+
+		err = storage.AcceptInvitation(ctx.Context(), invitation, u.Id)
+		if err != nil {
+			if errors.Is(err, AlreadyMember) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			if errors.Is(err, InvitationNotFound) {
+				return fiber.NewError(fiber.StatusGone, "invitation is expired or already used")
+			}
+			return fiber.ErrInternalServerError
+		}
+
+		member, err := storage.GetMember(ctx.Context(), invitation.WorkspaceId, u.Id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.JSON(AcceptResponse(member))
+	}
+}
@@ -0,0 +1,282 @@
+package workspace
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/mattn/go-sqlite3"
+	"github.com/oklog/ulid/v2"
+	"strings"
+	"time"
+	"todo-api/user"
+)
+
+type Id string
+
+type Role string
+
+const (
+	RoleGuest  Role = "guest"
+	RoleMember Role = "member"
+	RoleAdmin  Role = "admin"
+	RoleOwner  Role = "owner"
+)
+
+var roleLevels = map[Role]int{
+	RoleGuest:  1,
+	RoleMember: 2,
+	RoleAdmin:  3,
+	RoleOwner:  4,
+}
+
+func (r Role) Valid() bool {
+	return roleLevels[r] > 0
+}
+
+// Includes reports whether r grants at least the access of required.
+func (r Role) Includes(required Role) bool {
+	return r.Valid() && roleLevels[r] >= roleLevels[required]
+}
+
+type Workspace struct {
+	Id        Id        `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy user.Id   `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Member struct {
+	WorkspaceId Id        `json:"workspace_id"`
+	UserId      user.Id   `json:"user_id"`
+	Email       string    `json:"email"`
+	Name        string    `json:"name"`
+	Role        Role      `json:"role"`
+	JoinedAt    time.Time `json:"joined_at"`
+}
+
+var (
+	NotFound      = errors.New("workspace not found")
+	NotMember     = errors.New("user is not a member of the workspace")
+	AlreadyMember = errors.New("user is already a member of the workspace")
+)
+
+type Storage interface {
+	Create(ctx context.Context, name string, ownerId user.Id) (Workspace, error)
+	GetById(ctx context.Context, id Id) (Workspace, error)
+	GetByUserId(ctx context.Context, userId user.Id) ([]Workspace, error)
+
+	GetMember(ctx context.Context, id Id, userId user.Id) (Member, error)
+	GetMembers(ctx context.Context, id Id) ([]Member, error)
+	AddMember(ctx context.Context, id Id, userId user.Id, role Role) error
+	UpdateMemberRole(ctx context.Context, id Id, userId user.Id, role Role) error
+	RemoveMember(ctx context.Context, id Id, userId user.Id) error
+	CountOwners(ctx context.Context, id Id) (uint, error)
+
+	CreateInvitation(ctx context.Context, id Id, email string, role Role, tokenHash string, invitedBy user.Id, expiresAt time.Time) (Invitation, error)
+	GetInvitationByTokenHash(ctx context.Context, tokenHash string) (Invitation, error)
+	AcceptInvitation(ctx context.Context, invitation Invitation, userId user.Id) error
+}
+
+type SqliteStorage struct {
+	db *sql.DB
+}
+
+func NewSqliteStorage(db *sql.DB) *SqliteStorage {
+	return &SqliteStorage{db: db}
+}
+
+func (s SqliteStorage) Create(ctx context.Context, name string, ownerId user.Id) (Workspace, error) {
+	workspace := Workspace{
+		Id:        Id(ulid.Make().String()),
+		Name:      name,
+		CreatedBy: ownerId,
+		CreatedAt: time.Now(),
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Workspace{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO workspaces (id, name, created_by) VALUES (?, ?, ?)", workspace.Id, name, ownerId)
+	if err != nil {
+		return Workspace{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (?, ?, ?)", workspace.Id, ownerId, RoleOwner)
+	if err != nil {
+		return Workspace{}, err
+	}
+
+	return workspace, tx.Commit()
+}
+
+func (s SqliteStorage) GetById(ctx context.Context, id Id) (Workspace, error) {
+	stmt, err := s.db.PrepareContext(ctx, "SELECT id, name, created_by, created_at FROM workspaces WHERE id=?")
+	if err != nil {
+		return Workspace{}, err
+	}
+	defer stmt.Close()
+
+	workspace := Workspace{}
+	err = stmt.QueryRowContext(ctx, id).Scan(&workspace.Id, &workspace.Name, &workspace.CreatedBy, &workspace.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Workspace{}, NotFound
+		}
+		return Workspace{}, err
+	}
+	return workspace, nil
+}
+
+func (s SqliteStorage) GetByUserId(ctx context.Context, userId user.Id) ([]Workspace, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		SELECT w.id, w.name, w.created_by, w.created_at
+		FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE m.user_id=?
+		ORDER BY w.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workspaces []Workspace
+	for rows.Next() {
+		var workspace Workspace
+		err = rows.Scan(&workspace.Id, &workspace.Name, &workspace.CreatedBy, &workspace.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+func (s SqliteStorage) GetMember(ctx context.Context, id Id, userId user.Id) (Member, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		SELECT m.workspace_id, m.user_id, u.email, u.name, m.role, m.joined_at
+		FROM workspace_members m JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id=? AND m.user_id=?
+	`)
+	if err != nil {
+		return Member{}, err
+	}
+	defer stmt.Close()
+
+	member := Member{}
+	err = stmt.QueryRowContext(ctx, id, userId).
+		Scan(&member.WorkspaceId, &member.UserId, &member.Email, &member.Name, &member.Role, &member.JoinedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Member{}, NotMember
+		}
+		return Member{}, err
+	}
+	return member, nil
+}
+
+func (s SqliteStorage) GetMembers(ctx context.Context, id Id) ([]Member, error) {
+	stmt, err := s.db.PrepareContext(ctx, `
+		SELECT m.workspace_id, m.user_id, u.email, u.name, m.role, m.joined_at
+		FROM workspace_members m JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id=?
+		ORDER BY m.joined_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []Member
+	for rows.Next() {
+		var member Member
+		err = rows.Scan(&member.WorkspaceId, &member.UserId, &member.Email, &member.Name, &member.Role, &member.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (s SqliteStorage) AddMember(ctx context.Context, id Id, userId user.Id, role Role) error {
+	stmt, err := s.db.PrepareContext(ctx, "INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, id, userId, role)
+	return mapError(err)
+}
+
+func (s SqliteStorage) UpdateMemberRole(ctx context.Context, id Id, userId user.Id, role Role) error {
+	stmt, err := s.db.PrepareContext(ctx, "UPDATE workspace_members SET role=? WHERE workspace_id=? AND user_id=?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	exec, err := stmt.ExecContext(ctx, role, id, userId)
+	if err != nil {
+		return err
+	}
+	affected, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return NotMember
+	}
+	return nil
+}
+
+func (s SqliteStorage) RemoveMember(ctx context.Context, id Id, userId user.Id) error {
+	stmt, err := s.db.PrepareContext(ctx, "DELETE FROM workspace_members WHERE workspace_id=? AND user_id=?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, id, userId)
+	return err
+}
+
+func (s SqliteStorage) CountOwners(ctx context.Context, id Id) (uint, error) {
+	stmt, err := s.db.PrepareContext(ctx, "SELECT COUNT() FROM workspace_members WHERE workspace_id=? AND role=?")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var count uint
+	err = stmt.QueryRowContext(ctx, id, RoleOwner).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func mapError(err error) error {
+	var sqlErr sqlite3.Error
+	if errors.As(err, &sqlErr) {
+		if errors.Is(sqlErr.Code, sqlite3.ErrConstraint) && strings.HasPrefix(err.Error(), "UNIQUE constraint failed") {
+			return AlreadyMember
+		}
+	}
+	return err
+}
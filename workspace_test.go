@@ -0,0 +1,152 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mailToken returns the token of the last link mailed to the address.
+func mailToken(t *testing.T, a *testApp, to string) string {
+	message, ok := a.mailer.last(to)
+	assert.True(t, ok, "no mail to %s", to)
+	_, link, _ := strings.Cut(message.Body, "token=")
+	token, err := url.QueryUnescape(strings.Fields(link)[0])
+	assert.NoError(t, err)
+	return token
+}
+
+func createWorkspace(t *testing.T, a *testApp, token string, name string) string {
+	status, response := a.do(t, "POST", "/workspaces", map[string]string{"name": name}, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	id, _ := response["id"].(string)
+	return id
+}
+
+// join registers a user and adds it to the workspace through an invitation of the owner.
+func join(t *testing.T, a *testApp, owner string, workspaceId string, email string, role string) string {
+	token, _ := a.register(t, email)
+	status, response := a.do(t, "POST", "/workspaces/"+workspaceId+"/invitations", map[string]string{"email": email, "role": role}, bearer(owner)...)
+	assert.Equal(t, 200, status, response)
+	status, response = a.do(t, "POST", "/invitations/accept", map[string]string{"token": mailToken(t, a, email)}, bearer(token)...)
+	assert.Equal(t, 200, status, response)
+	assert.Equal(t, role, response["role"])
+	return token
+}
+
+func TestWorkspaceRoles(t *testing.T) {
+	a := newTestApp(t)
+	owner, _ := a.register(t, "owner@example.com")
+	id := createWorkspace(t, a, owner, "team")
+	admin := join(t, a, owner, id, "admin@example.com", "admin")
+	member := join(t, a, owner, id, "member@example.com", "member")
+	guest := join(t, a, owner, id, "guest@example.com", "guest")
+	stranger, _ := a.register(t, "stranger@example.com")
+	ownerId, adminId, memberId := userId(t, a, owner), userId(t, a, admin), userId(t, a, member)
+	todo := map[string]string{"title": "team"}
+	invite := func(role string) map[string]string {
+		return map[string]string{"email": "new@example.com", "role": role}
+	}
+	setRole := func(role string) map[string]string {
+		return map[string]string{"role": role}
+	}
+
+	status, response := a.do(t, "GET", "/workspaces/"+id, nil, bearer(guest)...)
+	assert.Equal(t, 200, status, response)
+	assert.Equal(t, "guest", response["role"])
+	status, _ = a.do(t, "GET", "/workspaces/"+id, nil, bearer(stranger)...)
+	assert.Equal(t, 403, status, "not a member")
+
+	status, _ = a.do(t, "POST", "/workspaces/"+id+"/todos", todo, bearer(guest)...)
+	assert.Equal(t, 403, status, "guest creates a todo")
+	status, _ = a.do(t, "POST", "/workspaces/"+id+"/todos", todo, bearer(member)...)
+	assert.Equal(t, 200, status, "member creates a todo")
+
+	status, _ = a.do(t, "POST", "/workspaces/"+id+"/invitations", invite("guest"), bearer(member)...)
+	assert.Equal(t, 403, status, "member invites")
+	status, _ = a.do(t, "POST", "/workspaces/"+id+"/invitations", invite("owner"), bearer(admin)...)
+	assert.Equal(t, 403, status, "admin invites an owner")
+	status, _ = a.do(t, "POST", "/workspaces/"+id+"/invitations", invite("admin"), bearer(admin)...)
+	assert.Equal(t, 200, status, "admin invites an admin")
+
+	status, _ = a.do(t, "PUT", "/workspaces/"+id+"/members/"+ownerId, setRole("member"), bearer(admin)...)
+	assert.Equal(t, 403, status, "admin demotes the owner")
+	status, _ = a.do(t, "PUT", "/workspaces/"+id+"/members/"+memberId, setRole("owner"), bearer(admin)...)
+	assert.Equal(t, 403, status, "admin grants a higher role than its own")
+	status, _ = a.do(t, "PUT", "/workspaces/"+id+"/members/"+memberId, setRole("guest"), bearer(admin)...)
+	assert.Equal(t, 200, status, "admin demotes a member")
+	status, _ = a.do(t, "PUT", "/workspaces/"+id+"/members/"+adminId, setRole("guest"), bearer(member)...)
+	assert.Equal(t, 403, status, "guest demotes an admin")
+	status, _ = a.do(t, "DELETE", "/workspaces/"+id+"/members/"+adminId, nil, bearer(admin)...)
+	assert.Equal(t, 200, status, "admin leaves")
+
+	status, _ = a.do(t, "PUT", "/workspaces/"+id+"/members/"+ownerId, setRole("admin"), bearer(owner)...)
+	assert.Equal(t, 400, status, "the only owner demotes itself")
+	status, _ = a.do(t, "DELETE", "/workspaces/"+id+"/members/"+ownerId, nil, bearer(owner)...)
+	assert.Equal(t, 400, status, "the only owner leaves")
+}
+
+func TestInvitation(t *testing.T) {
+	a := newTestApp(t, "INVITATION_PAGE_URL", "https://app.example.com/join")
+	owner, _ := a.register(t, "owner@example.com")
+	id := createWorkspace(t, a, owner, "team")
+	invited, _ := a.register(t, "invited@example.com")
+	other, _ := a.register(t, "other@example.com")
+	invite := map[string]string{"email": "Invited@example.com", "role": "member"}
+
+	status, response := a.do(t, "POST", "/workspaces/"+id+"/invitations", invite, bearer(owner)...)
+	assert.Equal(t, 200, status, response)
+	message, _ := a.mailer.last("Invited@example.com")
+	assert.Contains(t, message.Body, "https://app.example.com/join?token=")
+	accept := map[string]string{"token": mailToken(t, a, "Invited@example.com")}
+
+	status, _ = a.do(t, "POST", "/invitations/accept", map[string]string{"token": "unknown"}, bearer(invited)...)
+	assert.Equal(t, 404, status, "unknown token")
+	status, _ = a.do(t, "POST", "/invitations/accept", accept, bearer(other)...)
+	assert.Equal(t, 403, status, "invitation of another email")
+	status, response = a.do(t, "POST", "/invitations/accept", accept, bearer(invited)...)
+	assert.Equal(t, 200, status, response)
+	assert.Equal(t, "member", response["role"])
+	status, _ = a.do(t, "POST", "/invitations/accept", accept, bearer(invited)...)
+	assert.Equal(t, 410, status, "invitation used twice")
+
+	invite["email"] = "other@example.com"
+	status, response = a.do(t, "POST", "/workspaces/"+id+"/invitations", invite, bearer(owner)...)
+	assert.Equal(t, 200, status, response)
+	_, err := a.db.Exec("UPDATE workspace_invitations SET expires_at=? WHERE email=?", time.Now().UTC().Add(-time.Minute), "other@example.com")
+	assert.NoError(t, err)
+	status, _ = a.do(t, "POST", "/invitations/accept", map[string]string{"token": mailToken(t, a, "other@example.com")}, bearer(other)...)
+	assert.Equal(t, 410, status, "expired invitation")
+	status, _ = a.do(t, "GET", "/workspaces/"+id, nil, bearer(other)...)
+	assert.Equal(t, 403, status, "not a member after the expired invitation")
+}
+
+func TestWorkspaceSelection(t *testing.T) {
+	a := newTestApp(t)
+	owner, _ := a.register(t, "owner@example.com")
+	stranger, _ := a.register(t, "stranger@example.com")
+	id := createWorkspace(t, a, owner, "team")
+	header := []string{"X-Workspace-Id", id}
+
+	createTodo(t, a, owner, "personal")
+	status, response := a.do(t, "POST", "/todos", map[string]string{"title": "by header"}, append(bearer(owner), header...)...)
+	assert.Equal(t, 200, status, response)
+	assert.Equal(t, id, response["workspace_id"])
+	byHeader, _ := response["id"].(string)
+	status, response = a.do(t, "POST", "/workspaces/"+id+"/todos", map[string]string{"title": "by path"}, bearer(owner)...)
+	assert.Equal(t, 200, status, response)
+	assert.Equal(t, id, response["workspace_id"])
+	byPath, _ := response["id"].(string)
+
+	ids, _ := listTodos(t, a, owner, "/todos?sort_by=title&sort_order=asc", header...)
+	assert.Equal(t, []string{byHeader, byPath}, ids)
+	ids, _ = listTodos(t, a, owner, "/workspaces/"+id+"/todos?sort_by=title&sort_order=asc")
+	assert.Equal(t, []string{byHeader, byPath}, ids)
+
+	status, _ = a.do(t, "GET", "/todos", nil, append(bearer(stranger), header...)...)
+	assert.Equal(t, 403, status, "header of a workspace the user is not a member of")
+	status, _ = a.do(t, "GET", "/workspaces/"+id+"/todos", nil, bearer(stranger)...)
+	assert.Equal(t, 403, status, "path of a workspace the user is not a member of")
+}